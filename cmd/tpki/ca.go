@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"math/big"
+	"os"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+	"deleteonerror.com/tyinypki/internal/terminal"
+	"deleteonerror.com/tyinypki/pkg/tinypki"
+)
+
+func runInit(args []string) int {
+	fs := newFlagSet("init", "init -role root|sub [-config file]")
+	role := fs.String("role", "", "create a root or a sub CA")
+	configFile := fs.String("config", "", "the setup configuration, default is root.config.json or sub.config.json in the work folder")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *role != "root" && *role != "sub" || fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if store.IsCaConfigured() {
+		logger.Error("CA is already configured.")
+		return exitFailed
+	}
+
+	root := *role == "root"
+	var config model.Config
+	var err error
+	if *configFile != "" {
+		var raw []byte
+		raw, err = os.ReadFile(*configFile)
+		if err == nil {
+			err = json.Unmarshal(raw, &config)
+		}
+		if err != nil {
+			logger.Error("%v", err)
+			return exitFailed
+		}
+	} else if config, err = store.ReadSetupConfiguration(root); err != nil {
+		logger.Warning("Configuration not found.")
+		if root {
+			config = terminal.GetRootConfigInteractive()
+		} else {
+			config = terminal.GetSubConfigInteractive()
+		}
+	}
+
+	authority := openCA(terminal.AskPassphrase())
+	store.SetupFolders()
+	if root {
+		err = authority.SetupAuthority(config)
+	} else {
+		err = authority.SetupSubAuthority(config)
+	}
+	if err != nil {
+		logger.Error("Setup failed: %v", err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+// runDefault is the one run of tpkiroot and tpkisub without arguments: it verifies the CA, which
+// imports the certificate of a sub CA, revokes and republishes the CRL, and issues all pending requests.
+func runDefault() int {
+	lock, err := store.LockStore(true)
+	if err != nil {
+		logger.Error("%v", err)
+		return exitFailed
+	}
+	defer lock.Release()
+
+	if !store.IsCaConfigured() {
+		logger.Error("CA is not configured, run tpki init.")
+		return exitFailed
+	}
+	authority := openCA(terminal.AskPassphrase())
+	if authority.IsRoot() {
+		err = authority.VerifyAuthority()
+	} else {
+		err = authority.VerifySubAuthority()
+	}
+	if err != nil {
+		logger.Error("%v", err)
+		return exitFailed
+	}
+	return issuePending(authority)
+}
+
+// runIssue issues the requests of the request folders, a root CA issues the sub CA requests only.
+func runIssue(args []string) int {
+	fs := newFlagSet("issue", "issue")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if !requireConfigured() {
+		return exitFailed
+	}
+
+	authority := openCA(terminal.AskPassphrase())
+	if err := authority.Unlock(); err != nil {
+		logger.Error("%v", err)
+		return exitFailed
+	}
+	if !authority.IsRoot() && authority.Certificate() == nil {
+		logger.Error("No Sub Ca Certificate found, run tpki import-ca-cert.")
+		return exitFailed
+	}
+	return issuePending(authority)
+}
+
+// issuePending issues the requests of the request folders, a root CA issues the sub CA requests only.
+func issuePending(authority *tinypki.CA) int {
+	if authority.IsRoot() {
+		if err := authority.IssuePendingCaRequests(); err != nil {
+			logger.Error("Issuance of pending ca request Failed: %v", err)
+			return exitFailed
+		}
+		return exitOK
+	}
+
+	code := exitOK
+	if authority.CanIssueCaCertificates() {
+		if err := authority.IssuePendingCaRequests(); err != nil {
+			logger.Error("Issuance of pending ca request Failed: %v", err)
+			code = exitFailed
+		}
+	}
+	if err := authority.IssuePendingRequests(); err != nil {
+		logger.Error("Issuance of pending request Failed: %v", err)
+		code = exitFailed
+	}
+	if store.IsSSHConfigured() {
+		if err := authority.IssuePendingSSHRequests(); err != nil {
+			logger.Error("Signing of pending ssh keys Failed: %v", err)
+			code = exitFailed
+		}
+	}
+	return code
+}
+
+// runRevoke revokes the certificate with the serial, without serial the certificates placed in the revoke folder.
+func runRevoke(args []string) int {
+	fs := newFlagSet("revoke", "revoke [-reason reason] [serial]")
+	reason := fs.String("reason", "unspecified", "RFC 5280 revocation reason, e.g. keyCompromise, superseded or cessationOfOperation")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	reasonCode, err := tinypki.ParseRevocationReason(*reason)
+	if err != nil {
+		logger.Error("%v", err)
+		return exitUsage
+	}
+	var serial *big.Int
+	if fs.NArg() == 1 {
+		var ok bool
+		if serial, ok = new(big.Int).SetString(fs.Arg(0), 10); !ok {
+			logger.Error("invalid serial %s", fs.Arg(0))
+			return exitUsage
+		}
+	}
+	if !requireConfigured() {
+		return exitFailed
+	}
+
+	authority := openCA(terminal.AskPassphrase())
+	if err := authority.Unlock(); err != nil {
+		logger.Error("%v", err)
+		return exitFailed
+	}
+	if serial == nil {
+		authority.RevokeCertificates()
+		return exitOK
+	}
+	if err := authority.Revoke(serial, reasonCode); err != nil {
+		logger.Error("Revocation failed: %v", err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+// runImportCaCert imports the certificate of a sub CA and publishes its first CRL.
+func runImportCaCert(args []string) int {
+	fs := newFlagSet("import-ca-cert", "import-ca-cert <certificate file, optionally followed by the issuer chain>")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	if !requireConfigured() {
+		return exitFailed
+	}
+	raw, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		logger.Error("%v", err)
+		return exitFailed
+	}
+
+	authority := openCA(terminal.AskPassphrase())
+	if authority.IsRoot() {
+		logger.Error("The root CA certificate is self signed, import-ca-cert is for sub CAs.")
+		return exitFailed
+	}
+	if err := authority.ImportSubCACertificate(raw); err != nil {
+		logger.Error("Import failed: %v", err)
+		return exitFailed
+	}
+	if crl, _ := authority.LatestCRL(); crl == nil {
+		if _, err := authority.GenerateCRL(); err != nil {
+			logger.Error("Publication of the CRL failed: %v", err)
+			return exitFailed
+		}
+	}
+	return exitOK
+}
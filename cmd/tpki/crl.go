@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/terminal"
+	"deleteonerror.com/tyinypki/pkg/tinypki"
+)
+
+const crlUsage = "usage: tpki crl publish | crl due | crl margin -days n | crl daemon [-check interval]"
+
+func runCrl(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, crlUsage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "publish":
+		fs := newFlagSet("crl publish", "crl publish")
+		if code, ok := parseFlags(fs, args[1:]); !ok {
+			return code
+		}
+		if fs.NArg() != 0 {
+			fs.Usage()
+			return exitUsage
+		}
+		if !requireConfigured() {
+			return exitFailed
+		}
+
+		authority := openCA(terminal.AskPassphrase())
+		if err := authority.Unlock(); err != nil {
+			logger.Error("%v", err)
+			return exitFailed
+		}
+		crl, err := authority.GenerateCRL()
+		if err != nil {
+			logger.Error("Publication of the CRL failed: %v", err)
+			return exitFailed
+		}
+		fmt.Printf("CRL %s published, next update %s\n", crl.Number, crl.NextUpdate.UTC().Format(time.RFC3339))
+		return exitOK
+	case "due":
+		return runCrlDue(args[1:])
+	case "margin":
+		fs := newFlagSet("crl margin", "crl margin -days n")
+		days := fs.Int("days", 0, "republish the CRL the days before its next update")
+		if code, ok := parseFlags(fs, args[1:]); !ok {
+			return code
+		}
+		if fs.NArg() != 0 || *days == 0 {
+			fs.Usage()
+			return exitUsage
+		}
+		if !requireConfigured() {
+			return exitFailed
+		}
+		if err := openCA(terminal.AskPassphrase()).SetCRLRefreshDays(*days); err != nil {
+			logger.Error("%v", err)
+			return exitFailed
+		}
+		return exitOK
+	case "-h", "-help", "--help":
+		fmt.Fprintln(os.Stderr, crlUsage)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "unknown crl command %s\n", args[0])
+		fmt.Fprintln(os.Stderr, crlUsage)
+		return exitUsage
+	}
+}
+
+// runCrlDue exits with exitDue if the last CRL has to be republished, so an external timer can run crl publish.
+func runCrlDue(args []string) int {
+	fs := newFlagSet("crl due", "crl due")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if !requireConfigured() {
+		return exitFailed
+	}
+
+	authority := openCA(nil)
+	crl, err := authority.LatestCRL()
+	if err != nil {
+		logger.Error("%v", err)
+		return exitFailed
+	}
+	due, isDue, err := authority.CRLDue()
+	if err != nil {
+		logger.Error("%v", err)
+		return exitFailed
+	}
+	if crl == nil {
+		fmt.Println("No CRL published, republication is due.")
+		return exitDue
+	}
+	fmt.Printf("CRL %s, next update %s, republication due at %s\n", crl.Number,
+		crl.NextUpdate.UTC().Format(time.RFC3339), due.UTC().Format(time.RFC3339))
+	if isDue {
+		return exitDue
+	}
+	return exitOK
+}
+
+// runCrlDaemon republishes the CRL whenever it is due until the process is stopped. It takes the store lock
+// for each check only, so the other commands keep working on the store.
+func runCrlDaemon(args []string) int {
+	fs := newFlagSet("crl daemon", "crl daemon [-check interval]")
+	check := fs.Duration("check", time.Hour, "the longest time between two checks of the CRL")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 0 || *check < time.Minute {
+		fs.Usage()
+		return exitUsage
+	}
+	if !requireConfigured() {
+		return exitFailed
+	}
+
+	authority := openCA(terminal.AskPassphrase())
+	if err := authority.Unlock(); err != nil {
+		logger.Error("%v", err)
+		return exitFailed
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	logger.Info("CRL daemon started, CRLs are republished %d days before their next update", int(authority.CRLRefreshMargin().Hours()/24))
+	for {
+		timer := time.NewTimer(republishIfDue(authority, *check))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logger.Info("CRL daemon stopped")
+			return exitOK
+		case <-timer.C:
+		}
+	}
+}
+
+// republishIfDue publishes a new CRL if it is due and returns the time to wait for the next check.
+func republishIfDue(authority *tinypki.CA, check time.Duration) time.Duration {
+	const retry = time.Minute
+
+	lock, err := store.LockStore(true)
+	if err != nil {
+		if errors.Is(err, tinypki.ErrLocked) {
+			logger.Info("%v", err)
+		} else {
+			logger.Error("%v", err)
+		}
+		return retry
+	}
+	defer lock.Release()
+	authority.Refresh()
+
+	if _, err := authority.RepublishCRLIfDue(); err != nil {
+		logger.Error("Publication of the CRL failed: %v", err)
+		return retry
+	}
+	due, _, err := authority.CRLDue()
+	if err != nil {
+		return retry
+	}
+	wait := time.Until(due)
+	logger.Debug("Next CRL republication at %s", due.UTC().Format(time.RFC3339))
+	return max(min(wait, check), retry)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+	"deleteonerror.com/tyinypki/internal/terminal"
+	"deleteonerror.com/tyinypki/pkg/tinypki"
+)
+
+// Exit codes of all commands.
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitUsage  = 2
+	// exitDue is returned by crl due if the CRL has to be republished.
+	exitDue = 3
+)
+
+var store tinypki.Store
+
+func init() {
+	log.SetFlags(log.LstdFlags)
+	log.SetFlags(log.Flags() &^ (log.Lshortfile | log.Llongfile))
+	logger.FromEnvironment()
+}
+
+// openCA returns the CA on the store with the given passphrase.
+func openCA(pass []byte) *tinypki.CA {
+	authority, err := tinypki.New(tinypki.Options{Store: store, Passphrase: pass})
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(exitFailed)
+	}
+	return authority
+}
+
+func main() {
+	fs := flag.NewFlagSet("tpki", flag.ContinueOnError)
+	fs.Usage = usage
+	storePath := fs.String("store", "", "the folder of the CA files")
+	workPath := fs.String("work", "", "the folder of the request and publication folders")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(exitOK)
+		}
+		os.Exit(exitUsage)
+	}
+	if fs.Arg(0) == "help" {
+		usage()
+		os.Exit(exitOK)
+	}
+
+	env := tinypki.StoreFromEnvironment()
+	if *storePath != "" || *workPath != "" {
+		s := tinypki.NewFileStore(orDefault(*storePath, env.StorePath), orDefault(*workPath, env.WorkPath))
+		s.LockWait = env.LockWait
+		env = s
+	}
+	store = env
+	if fs.NArg() == 0 {
+		os.Exit(runDefault())
+	}
+	os.Exit(runCommand(fs.Args()))
+}
+
+func orDefault(value string, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: tpki [-store folder] [-work folder] [<command> [options]]")
+	fmt.Fprintln(os.Stderr, "  -store folder        the folder of the CA files, default is store below `TINY_ROOT_PATH`")
+	fmt.Fprintln(os.Stderr, "  -work folder         the folder of the request and publication folders, default is work below `TINY_ROOT_PATH`")
+	fmt.Fprintln(os.Stderr, "without a command the CA is verified, the revocations and the CRL published and all pending")
+	fmt.Fprintln(os.Stderr, "requests issued in one run, like tpkiroot and tpkisub do")
+	fmt.Fprintln(os.Stderr, "commands:")
+	fmt.Fprintln(os.Stderr, "  init -role root|sub [-config file]")
+	fmt.Fprintln(os.Stderr, "                       create the CA from the setup configuration")
+	fmt.Fprintln(os.Stderr, "  status [-json]       print the CA certificate, the last CRL and the pending requests")
+	fmt.Fprintln(os.Stderr, "  issue                issue the requests waiting in the request folders")
+	fmt.Fprintln(os.Stderr, "  revoke [-reason reason] [serial]")
+	fmt.Fprintln(os.Stderr, "                       revoke a certificate, without serial the certificates in the revoke folder")
+	fmt.Fprintln(os.Stderr, "  crl publish          sign and publish a new CRL")
+	fmt.Fprintln(os.Stderr, "  crl due              exit with 3 if the CRL has to be republished")
+	fmt.Fprintln(os.Stderr, "  crl margin -days n   republish the CRL n days before its next update")
+	fmt.Fprintln(os.Stderr, "  crl daemon [-check interval]")
+	fmt.Fprintln(os.Stderr, "                       republish the CRL whenever it is due")
+	fmt.Fprintln(os.Stderr, "  publish serve [-listen addr] [-max-age duration]")
+	fmt.Fprintln(os.Stderr, "                       serve the published certificates and CRLs over HTTP")
+	fmt.Fprintln(os.Stderr, "  publish sync         copy all published files to the publish targets")
+	fmt.Fprintln(os.Stderr, "  import-ca-cert <file>")
+	fmt.Fprintln(os.Stderr, "                       import the certificate the root issued for this sub CA")
+	fmt.Fprintln(os.Stderr, "  list [-json] [-status valid|revoked|expired]")
+	fmt.Fprintln(os.Stderr, "                       list the issued certificates")
+	fmt.Fprintln(os.Stderr, "  backup [-out file]   write an encrypted backup of the CA store")
+	fmt.Fprintln(os.Stderr, "  restore <file>       verify a backup and restore it into an empty store")
+	fmt.Fprintln(os.Stderr, "  check [-json] [-repair]")
+	fmt.Fprintln(os.Stderr, "                       verify the consistency of the CA store")
+	fmt.Fprintln(os.Stderr, "run tpki <command> -help for the options of a command")
+}
+
+func runCommand(args []string) int {
+	// the daemon takes the store lock for each check, the publication server needs no lock
+	if args[0] == "crl" && len(args) > 1 && args[1] == "daemon" {
+		return runCrlDaemon(args[2:])
+	}
+	if args[0] == "publish" && len(args) > 1 && args[1] == "serve" {
+		return runPublish(args[1:])
+	}
+
+	lock, err := store.LockStore(!readOnly(args))
+	if err != nil {
+		logger.Error("%v", err)
+		return exitFailed
+	}
+	defer lock.Release()
+
+	switch args[0] {
+	case "init":
+		return runInit(args[1:])
+	case "status":
+		return runStatus(args[1:])
+	case "issue":
+		return runIssue(args[1:])
+	case "revoke":
+		return runRevoke(args[1:])
+	case "crl":
+		return runCrl(args[1:])
+	case "publish":
+		return runPublish(args[1:])
+	case "import-ca-cert":
+		return runImportCaCert(args[1:])
+	case "list":
+		return runList(args[1:])
+	case "backup":
+		return runBackup(args[1:])
+	case "restore":
+		return runRestore(args[1:])
+	case "check":
+		return runCheck(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", args[0])
+		usage()
+		return exitUsage
+	}
+}
+
+// readOnly reports if the command only reads the store, such commands share the store lock.
+func readOnly(args []string) bool {
+	switch args[0] {
+	case "backup", "status", "list":
+		return true
+	case "crl":
+		return len(args) > 1 && args[1] == "due"
+	case "check":
+		for _, arg := range args[1:] {
+			if arg == "-repair" || arg == "--repair" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// newFlagSet returns the flag set of a command, -help prints the usage line and the options.
+func newFlagSet(name string, usageLine string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: tpki "+usageLine)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses the arguments of a command. If ok is false the command is done and returns code,
+// -help exits with success, wrong arguments with exitUsage.
+func parseFlags(fs *flag.FlagSet, args []string) (code int, ok bool) {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK, false
+	}
+	if err != nil {
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// requireConfigured logs an error and returns false if the store holds no CA yet.
+func requireConfigured() bool {
+	if !store.IsCaConfigured() {
+		logger.Error("CA is not configured, run tpki init.")
+		return false
+	}
+	return true
+}
+
+func runBackup(args []string) int {
+	fs := newFlagSet("backup", "backup [-out file]")
+	out := fs.String("out", "", "the backup file, default is a new file in the backups folder")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if !requireConfigured() {
+		return exitFailed
+	}
+
+	pass := terminal.AskNewBackupPassphrase()
+	file, err := openCA(nil).Backup(*out, pass)
+	if err != nil {
+		logger.Error("Backup failed: %v", err)
+		return exitFailed
+	}
+	fmt.Println(file)
+	return exitOK
+}
+
+func runRestore(args []string) int {
+	fs := newFlagSet("restore", "restore <backup file>")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	backupPass := terminal.AskBackupPassphrase()
+	caPass := terminal.AskPassphrase()
+	err := openCA(caPass).Restore(fs.Arg(0), backupPass)
+	if err != nil {
+		logger.Error("Restore failed: %v", err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+func runCheck(args []string) int {
+	fs := newFlagSet("check", "check [-json] [-repair]")
+	asJson := fs.Bool("json", false, "print the findings as json")
+	repair := fs.Bool("repair", false, "apply safe fixes")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if !requireConfigured() {
+		return exitFailed
+	}
+
+	findings := openCA(terminal.AskPassphrase()).CheckStore(*repair)
+
+	if *asJson {
+		out, err := json.MarshalIndent(findings, "", "  ")
+		if err != nil {
+			logger.Error("%v", err)
+			return exitFailed
+		}
+		fmt.Println(string(out))
+	} else {
+		terminal.PrintFindings(findings)
+	}
+
+	for _, f := range findings {
+		if f.Status == model.CheckError && !f.Repaired {
+			return exitFailed
+		}
+	}
+	return exitOK
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/publish"
+)
+
+const publishUsage = "usage: tpki publish serve [-listen addr] [-max-age duration] | sync"
+
+func runPublish(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, publishUsage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "serve":
+		return runPublishServe(args[1:])
+	case "sync":
+		return runPublishSync(args[1:])
+	case "-h", "-help", "--help":
+		fmt.Fprintln(os.Stderr, publishUsage)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "unknown publish command %s\n", args[0])
+		fmt.Fprintln(os.Stderr, publishUsage)
+		return exitUsage
+	}
+}
+
+// runPublishServe serves the publish folder for the AIA and CDP URLs. It only reads published files,
+// which are replaced at once, so it runs without the store lock and without the passphrase.
+func runPublishServe(args []string) int {
+	fs := newFlagSet("publish serve", "publish serve [-listen addr] [-max-age duration]")
+	listen := fs.String("listen", ":8080", "the address to listen on")
+	maxAge := fs.Duration("max-age", time.Hour, "the longest time clients cache a file, CRLs are never cached beyond their next update")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           &publish.Server{Store: store, MaxAge: *maxAge},
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	logger.Info("Publishing %s on %s", store.GetPathByName("ca-publish"), *listen)
+	if err := server.ListenAndServe(); err != nil {
+		logger.Error("%v", err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+// runPublishSync copies all published files to the publish targets, e.g. after a target was added
+// or was unreachable. It needs no passphrase.
+func runPublishSync(args []string) int {
+	fs := newFlagSet("publish sync", "publish sync")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if !requireConfigured() {
+		return exitFailed
+	}
+	if err := store.SyncPublished(); err != nil {
+		logger.Error("Publication failed: %v", err)
+		return exitFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+	"deleteonerror.com/tyinypki/pkg/tinypki"
+)
+
+// caStatus is the output of tpki status.
+type caStatus struct {
+	Name          string     `json:"name"`
+	Role          string     `json:"role"`
+	Subject       string     `json:"subject,omitempty"`
+	NotAfter      *time.Time `json:"not_after,omitempty"`
+	LastSerial    *big.Int   `json:"last_serial"`
+	CRLNumber     *big.Int   `json:"crl_number,omitempty"`
+	CRLThisUpdate *time.Time `json:"crl_this_update,omitempty"`
+	CRLNextUpdate *time.Time `json:"crl_next_update,omitempty"`
+	Valid         int        `json:"valid"`
+	Revoked       int        `json:"revoked"`
+	Expired       int        `json:"expired"`
+	// Requests waiting in the request folders and for approval.
+	Waiting int `json:"waiting"`
+	Pending int `json:"pending"`
+	// Publication holds the outcome of the copies to each publish target.
+	Publication []model.PublicationStatus `json:"publication,omitempty"`
+}
+
+// runStatus prints the state of the CA, it needs no passphrase.
+func runStatus(args []string) int {
+	fs := newFlagSet("status", "status [-json]")
+	asJson := fs.Bool("json", false, "print the status as json")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if !requireConfigured() {
+		return exitFailed
+	}
+
+	authority := openCA(nil)
+	conf := authority.Config()
+	status := caStatus{Name: conf.Name, Role: "sub", LastSerial: conf.LastIssuedSerial}
+	if authority.IsRoot() {
+		status.Role = "root"
+	}
+	if cert := authority.Certificate(); cert != nil {
+		status.Subject = cert.Subject.String()
+		status.NotAfter = &cert.NotAfter
+	}
+	crl, err := authority.LatestCRL()
+	if err != nil {
+		logger.Error("%v", err)
+		return exitFailed
+	}
+	if crl != nil {
+		status.CRLNumber = crl.Number
+		status.CRLThisUpdate = &crl.ThisUpdate
+		status.CRLNextUpdate = &crl.NextUpdate
+	}
+
+	certs, err := authority.Certificates()
+	if err != nil {
+		logger.Error("%v", err)
+		return exitFailed
+	}
+	for _, c := range certs {
+		switch c.Status {
+		case model.CertificateValid:
+			status.Valid++
+		case model.CertificateRevoked:
+			status.Revoked++
+		case model.CertificateExpired:
+			status.Expired++
+		}
+	}
+	status.Waiting = len(store.GetCertificateRequests())
+	records, err := authority.RequestRecords()
+	if err != nil {
+		logger.Error("%v", err)
+		return exitFailed
+	}
+	for _, r := range records {
+		if r.Status == model.RequestPending {
+			status.Pending++
+		}
+	}
+
+	if status.Publication, err = publicationStatus(conf); err != nil {
+		logger.Error("%v", err)
+		return exitFailed
+	}
+
+	if *asJson {
+		out, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			logger.Error("%v", err)
+			return exitFailed
+		}
+		fmt.Println(string(out))
+		return exitOK
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Name\t%s\n", status.Name)
+	fmt.Fprintf(w, "Role\t%s\n", status.Role)
+	if status.NotAfter == nil {
+		fmt.Fprintf(w, "Certificate\tnone, waiting for the certificate of the root\n")
+	} else {
+		fmt.Fprintf(w, "Subject\t%s\n", status.Subject)
+		fmt.Fprintf(w, "Valid until\t%s\n", status.NotAfter.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "Last serial\t%s\n", status.LastSerial)
+	if status.CRLNumber == nil {
+		fmt.Fprintf(w, "CRL\tnone published\n")
+	} else {
+		fmt.Fprintf(w, "CRL\t%s, next update %s\n", status.CRLNumber, status.CRLNextUpdate.UTC().Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "Certificates\t%d valid, %d revoked, %d expired\n", status.Valid, status.Revoked, status.Expired)
+	fmt.Fprintf(w, "Requests\t%d in the request folders, %d pending approval\n", status.Waiting, status.Pending)
+	for _, p := range status.Publication {
+		switch {
+		case p.IsFailing():
+			fmt.Fprintf(w, "Publication\t%s FAILED %s, %d files waiting: %s\n", p.Target, p.LastFailure.UTC().Format(time.RFC3339), len(p.Failed), p.Error)
+		case p.LastSuccess != nil:
+			fmt.Fprintf(w, "Publication\t%s ok %s\n", p.Target, p.LastSuccess.UTC().Format(time.RFC3339))
+		default:
+			fmt.Fprintf(w, "Publication\t%s nothing copied yet\n", p.Target)
+		}
+	}
+	w.Flush()
+	return exitOK
+}
+
+// publicationStatus returns the status of each configured publish target.
+func publicationStatus(conf model.Config) ([]model.PublicationStatus, error) {
+	last, err := store.ReadPublicationStatus()
+	if err != nil {
+		return nil, err
+	}
+	var status []model.PublicationStatus
+	for _, target := range conf.PublishTargets {
+		current := model.PublicationStatus{Target: target.DisplayName()}
+		for _, p := range last {
+			if p.Target == current.Target {
+				current = p
+			}
+		}
+		status = append(status, current)
+	}
+	return status, nil
+}
+
+func runList(args []string) int {
+	fs := newFlagSet("list", "list [-json] [-status valid|revoked|expired]")
+	asJson := fs.Bool("json", false, "print the certificates as json")
+	status := fs.String("status", "", "only list certificates with the status, valid, revoked or expired")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if !requireConfigured() {
+		return exitFailed
+	}
+
+	certs, err := openCA(nil).Certificates()
+	if err != nil {
+		logger.Error("%v", err)
+		return exitFailed
+	}
+	var listed []tinypki.IssuedCertificate
+	for _, c := range certs {
+		if *status == "" || c.Status == *status {
+			listed = append(listed, c)
+		}
+	}
+
+	if *asJson {
+		out, err := json.MarshalIndent(listed, "", "  ")
+		if err != nil {
+			logger.Error("%v", err)
+			return exitFailed
+		}
+		fmt.Println(string(out))
+		return exitOK
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIAL\tSTATUS\tNOT AFTER\tSUBJECT")
+	for _, c := range listed {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Serial, c.Status, c.NotAfter.UTC().Format(time.RFC3339), c.Subject)
+	}
+	w.Flush()
+	return exitOK
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"os"
 
 	"deleteonerror.com/tyinypki/internal/ca"
 	"deleteonerror.com/tyinypki/internal/data"
@@ -17,6 +18,10 @@ func init() {
 	log.SetFlags(log.Flags() &^ (log.Lshortfile | log.Llongfile))
 }
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "status" {
+		os.Exit(runStatus(os.Args[2:]))
+	}
+
 	var filePath string
 
 	verbose := flag.Bool("verbose", false, "enable verbose mode")
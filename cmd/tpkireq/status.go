@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/data"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// runStatus prints the status files of requests placed in the request folders. The arguments are
+// requests, status files or request folders.
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the status files as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: tpkireq status [-json] <request, status file or request folder>...")
+		return 2
+	}
+
+	var paths []string
+	for _, arg := range fs.Args() {
+		info, err := os.Stat(arg)
+		if err == nil && info.IsDir() {
+			found, err := filepath.Glob(filepath.Join(arg, "*.status.json"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				return 1
+			}
+			paths = append(paths, found...)
+			continue
+		}
+		paths = append(paths, arg)
+	}
+
+	code := 0
+	var statuses []model.RequestStatus
+	for _, path := range paths {
+		status, err := data.ReadRequestStatusFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "%s: no status yet, the request was not picked up by the CA\n", path)
+			} else {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			}
+			code = 1
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+
+	if *asJSON {
+		out, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		fmt.Println(string(out))
+		return code
+	}
+
+	for i, s := range statuses {
+		if i > 0 {
+			fmt.Println()
+		}
+		printStatus(s)
+	}
+	return code
+}
+
+func printStatus(s model.RequestStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Request\t%s\n", s.Request)
+	fmt.Fprintf(w, "ID\t%s\n", s.ID)
+	fmt.Fprintf(w, "Profile\t%s\n", s.Profile)
+	fmt.Fprintf(w, "Status\t%s\n", s.Status)
+	if s.Reason != "" {
+		fmt.Fprintf(w, "Reason\t%s\n", s.Reason)
+	}
+	if s.Serial != nil {
+		fmt.Fprintf(w, "Serial\t%s\n", s.Serial)
+	}
+	if s.Certificate != "" {
+		fmt.Fprintf(w, "Certificate\t%s\n", s.Certificate)
+		fmt.Fprintf(w, "Chain\t%s\n", s.Chain)
+	}
+	for _, f := range s.Lint {
+		fmt.Fprintf(w, "Lint\t%s %s: %s\n", f.Severity, f.Lint, f.Message)
+	}
+	fmt.Fprintf(w, "Updated\t%s\n", s.Updated.Format(time.RFC3339))
+	w.Flush()
+}
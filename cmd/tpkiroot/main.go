@@ -1,42 +1,329 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
+	"net"
 	"os"
+	"strings"
 
-	"deleteonerror.com/tyinypki/internal/ca"
-	"deleteonerror.com/tyinypki/internal/data"
 	"deleteonerror.com/tyinypki/internal/logger"
 	"deleteonerror.com/tyinypki/internal/terminal"
+	"deleteonerror.com/tyinypki/pkg/tinypki"
 )
 
+var store tinypki.Store
+
 func init() {
 	log.SetFlags(log.LstdFlags)
 	log.SetFlags(log.Flags() &^ (log.Lshortfile | log.Llongfile))
-	data.Initialize()
+	logger.FromEnvironment()
+	store = tinypki.StoreFromEnvironment()
+}
+
+// openCA returns the root CA on the store, the passphrase is asked on the terminal.
+func openCA() *tinypki.CA {
+	authority, err := tinypki.New(tinypki.Options{Store: store, Passphrase: terminal.AskPassphrase()})
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+	return authority
 }
 
 func main() {
 
-	if data.IsCaConfigured() {
-		pass := terminal.AskPassphrase()
-		ca.VerifyAuthority(pass)
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	lock, err := store.LockStore(true)
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+	defer lock.Release()
+
+	var authority *tinypki.CA
+	if store.IsCaConfigured() {
+		authority = openCA()
+		if err := authority.VerifyAuthority(); err != nil {
+			logger.Error("%v", err)
+			os.Exit(1)
+		}
 	} else {
-		config, err := data.ReadSetupConfiguration(true)
+		config, err := store.ReadSetupConfiguration(true)
 		if err != nil {
 			logger.Warning("Configuration not found.")
 			config = terminal.GetRootConfigInteractive()
 		}
-		pass := terminal.AskPassphrase()
-		data.SetupFolders()
-		err = ca.SetupAuthority(config, pass)
+		authority = openCA()
+		store.SetupFolders()
+		err = authority.SetupAuthority(config)
 		if err != nil {
 			logger.Error("Setup failed: %v", err)
 			os.Exit(1)
 		}
 	}
-	err := ca.IssuePendingCaRequests()
+	err = authority.IssuePendingCaRequests()
 	if err != nil {
 		logger.Error("Issuance of pending request Failed: %v", err)
 	}
 }
+
+func runCommand(args []string) int {
+	lock, err := store.LockStore(!readOnly(args))
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	defer lock.Release()
+
+	switch args[0] {
+	case "subs":
+		return runSubs(args[1:])
+	case "cross-sign":
+		return runCrossSign(args[1:])
+	case "cross-check":
+		return runCrossCheck(args[1:])
+	case "export-bundle":
+		return runExportBundle(args[1:])
+	case "import-bundle":
+		return runImportBundle(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", args[0])
+		fmt.Fprintln(os.Stderr, "usage: tpkiroot [subs list | subs revoke [-reason reason] <name|serial> | cross-sign [options] <cert|csr> | cross-check [options] <leaf> | export-bundle [-out file] | import-bundle [-fingerprint hex] <file>]")
+		return 2
+	}
+}
+
+// readOnly reports if the command only reads the store, such commands share the store lock.
+func readOnly(args []string) bool {
+	switch args[0] {
+	case "cross-check", "export-bundle":
+		return true
+	case "subs":
+		return len(args) > 1 && args[1] == "list"
+	}
+	return false
+}
+
+func runCrossSign(args []string) int {
+	fs := flag.NewFlagSet("cross-sign", flag.ContinueOnError)
+	pathLen := fs.Int("path-len", 0, "maximum number of CA levels below the cross certified CA")
+	permitDNS := fs.String("permit-dns", "", "comma separated list of permitted DNS domains")
+	excludeDNS := fs.String("exclude-dns", "", "comma separated list of excluded DNS domains")
+	permitIP := fs.String("permit-ip", "", "comma separated list of permitted IP ranges in CIDR notation")
+	excludeIP := fs.String("exclude-ip", "", "comma separated list of excluded IP ranges in CIDR notation")
+	permitEmail := fs.String("permit-email", "", "comma separated list of permitted email addresses or domains")
+	excludeEmail := fs.String("exclude-email", "", "comma separated list of excluded email addresses or domains")
+	permitURI := fs.String("permit-uri", "", "comma separated list of permitted URI domains")
+	excludeURI := fs.String("exclude-uri", "", "comma separated list of excluded URI domains")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: tpkiroot cross-sign [options] <self signed ca certificate or ca request>")
+		fs.PrintDefaults()
+		return 2
+	}
+	if !store.IsCaConfigured() {
+		logger.Error("Root CA is not configured.")
+		return 1
+	}
+
+	opts := tinypki.CrossOptions{
+		PathLen:                 *pathLen,
+		PermittedDNSDomains:     splitList(*permitDNS),
+		ExcludedDNSDomains:      splitList(*excludeDNS),
+		PermittedEmailAddresses: splitList(*permitEmail),
+		ExcludedEmailAddresses:  splitList(*excludeEmail),
+		PermittedURIDomains:     splitList(*permitURI),
+		ExcludedURIDomains:      splitList(*excludeURI),
+	}
+	var err error
+	if opts.PermittedIPRanges, err = parseCIDRs(*permitIP); err != nil {
+		logger.Error("%v", err)
+		return 2
+	}
+	if opts.ExcludedIPRanges, err = parseCIDRs(*excludeIP); err != nil {
+		logger.Error("%v", err)
+		return 2
+	}
+
+	raw, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+
+	err = openCA().CrossSign(raw, opts)
+	if err != nil {
+		logger.Error("Cross signing failed: %v", err)
+		return 1
+	}
+	return 0
+}
+
+func runCrossCheck(args []string) int {
+	fs := flag.NewFlagSet("cross-check", flag.ContinueOnError)
+	foreignRoot := fs.String("foreign-root", "", "the self signed certificate of the other CA")
+	intermediates := fs.String("intermediates", "", "optional PEM bundle with intermediate certificates of the other CA")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 || *foreignRoot == "" {
+		fmt.Fprintln(os.Stderr, "usage: tpkiroot cross-check -foreign-root <cert> [-intermediates <bundle>] <leaf certificate>")
+		fs.PrintDefaults()
+		return 2
+	}
+
+	leafRaw, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	rootRaw, err := os.ReadFile(*foreignRoot)
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	var interRaw []byte
+	if *intermediates != "" {
+		interRaw, err = os.ReadFile(*intermediates)
+		if err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+	}
+
+	authority, err := tinypki.New(tinypki.Options{Store: store})
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	own, foreign, err := authority.VerifyCrossChains(leafRaw, rootRaw, interRaw)
+	fmt.Println("Chains to this root:")
+	terminal.PrintChains(own)
+	fmt.Println("Chains to the foreign root:")
+	terminal.PrintChains(foreign)
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	return 0
+}
+
+func runExportBundle(args []string) int {
+	fs := flag.NewFlagSet("export-bundle", flag.ContinueOnError)
+	out := fs.String("out", "", "the bundle file, default is a new file in the bundles folder")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if !store.IsCaConfigured() {
+		logger.Error("Root CA is not configured.")
+		return 1
+	}
+
+	file, err := openCA().ExportBundle(tinypki.RootBundleKinds, *out)
+	if err != nil {
+		logger.Error("Export failed: %v", err)
+		return 1
+	}
+	fmt.Println(file)
+	return 0
+}
+
+func runImportBundle(args []string) int {
+	fs := flag.NewFlagSet("import-bundle", flag.ContinueOnError)
+	fingerprint := fs.String("fingerprint", "", "expected SHA-256 fingerprint of the producer key")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: tpkiroot import-bundle [-fingerprint hex] <bundle file>")
+		return 2
+	}
+	if !store.IsCaConfigured() {
+		logger.Error("Root CA is not configured.")
+		return 1
+	}
+
+	err := openCA().ImportBundle(fs.Arg(0), *fingerprint)
+	if err != nil {
+		logger.Error("Import failed: %v", err)
+		return 1
+	}
+	return 0
+}
+
+func splitList(value string) []string {
+	var result []string
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+func parseCIDRs(value string) ([]*net.IPNet, error) {
+	var result []*net.IPNet
+	for _, part := range splitList(value) {
+		_, ipNet, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, ipNet)
+	}
+	return result, nil
+}
+
+func runSubs(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: tpkiroot subs list | subs revoke [-reason reason] <name|serial>")
+		return 2
+	}
+	if !store.IsCaConfigured() {
+		logger.Error("Root CA is not configured.")
+		return 1
+	}
+
+	switch args[0] {
+	case "list":
+		authority, err := tinypki.New(tinypki.Options{Store: store})
+		if err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+		subs, err := authority.ListSubAuthorities()
+		if err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+		terminal.PrintSubAuthorities(subs)
+		return 0
+	case "revoke":
+		fs := flag.NewFlagSet("subs revoke", flag.ContinueOnError)
+		reason := fs.String("reason", "unspecified", "RFC 5280 revocation reason, e.g. keyCompromise, cACompromise, superseded or cessationOfOperation")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "usage: tpkiroot subs revoke [-reason reason] <name|serial>")
+			return 2
+		}
+
+		err := openCA().RevokeSubAuthority(fs.Arg(0), *reason)
+		if err != nil {
+			logger.Error("Revocation failed: %v", err)
+			return 1
+		}
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown subs command %s\n", args[0])
+		return 2
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/api"
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/pkg/tinypki"
+)
+
+// runAPI serves the REST API or adds a token to a tokens file. The server takes the store lock per request,
+// so it runs without the lock of runCommand.
+func runAPI(args []string) int {
+	if len(args) > 0 && args[0] == "token" {
+		return runAPIToken(args[1:])
+	}
+
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	listen := fs.String("listen", ":9443", "the address to listen on")
+	tlsCert := fs.String("tls-cert", "", "the PEM encoded server certificate with its chain")
+	tlsKey := fs.String("tls-key", "", "the PEM encoded server key")
+	tokensFile := fs.String("tokens", "", "the tokens file for bearer auth, see tpkisub api token")
+	operators := fs.String("operators", "", "comma separated SHA-256 fingerprints of client certificates with the operator role")
+	requesterProfiles := fs.String("requester-profiles", strings.Join(api.DefaultRequesterProfiles, ","), "comma separated profiles requesters may submit")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *tlsCert == "" || *tlsKey == "" || fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: tpkisub api [-listen addr] -tls-cert file -tls-key file [-tokens file] [-operators sha256,...] [-requester-profiles name,...]")
+		return 2
+	}
+	if !store.IsCaConfigured() {
+		logger.Error("Sub CA is not configured.")
+		return 1
+	}
+
+	var tokens api.Tokens
+	if *tokensFile != "" {
+		var err error
+		tokens, err = api.LoadTokens(*tokensFile)
+		if err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+	}
+
+	operatorFingerprints, err := api.ParseFingerprints(*operators)
+	if err != nil {
+		logger.Error("%v", err)
+		return 2
+	}
+	profiles, err := tinypki.ParseProfiles(*requesterProfiles)
+	if err != nil {
+		logger.Error("%v", err)
+		return 2
+	}
+
+	authority, err := verifiedCA()
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+
+	clientCAs := x509.NewCertPool()
+	for _, c := range authority.CertificateChain() {
+		clientCAs.AddCert(c)
+	}
+
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           &api.Server{CA: authority, Tokens: tokens, Operators: operatorFingerprints, RequesterProfiles: profiles},
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			ClientCAs:  clientCAs,
+		},
+	}
+
+	logger.Info("API listening on %s", *listen)
+	if err := server.ListenAndServeTLS(*tlsCert, *tlsKey); err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	return 0
+}
+
+// runAPIToken creates a token, appends its hash to the tokens file and prints the token once.
+func runAPIToken(args []string) int {
+	fs := flag.NewFlagSet("api token", flag.ContinueOnError)
+	tokensFile := fs.String("tokens", "", "the tokens file, the token is appended")
+	role := fs.String("role", api.RoleRequester, "the role of the token, requester or operator")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *tokensFile == "" || fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: tpkisub api token -tokens file [-role requester|operator] <name>")
+		return 2
+	}
+
+	token, line, err := api.NewToken(fs.Arg(0), *role)
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+
+	f, err := os.OpenFile(*tokensFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	fmt.Println(token)
+	return 0
+}
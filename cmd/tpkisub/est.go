@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/est"
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/terminal"
+	"deleteonerror.com/tyinypki/pkg/tinypki"
+)
+
+// runEST serves EST or adds a user to a users file. The server takes the store lock per request,
+// so it runs without the lock of runCommand.
+func runEST(args []string) int {
+	if len(args) > 0 && args[0] == "adduser" {
+		return runESTAddUser(args[1:])
+	}
+
+	fs := flag.NewFlagSet("est", flag.ContinueOnError)
+	listen := fs.String("listen", ":8443", "the address to listen on")
+	tlsCert := fs.String("tls-cert", "", "the PEM encoded server certificate with its chain")
+	tlsKey := fs.String("tls-key", "", "the PEM encoded server key")
+	usersFile := fs.String("users", "", "the users file for basic auth, see tpkisub est adduser")
+	enrollersFile := fs.String("enrollers", "", "the file of the client certificates which may enroll, fingerprint:profiles per line")
+	profile := fs.String("profile", "client", "the profile used if the path has no label")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *tlsCert == "" || *tlsKey == "" || fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: tpkisub est [-listen addr] -tls-cert file -tls-key file [-users file] [-enrollers file] [-profile name]")
+		return 2
+	}
+	if !slices.Contains(tinypki.Profiles, *profile) {
+		logger.Error("unknown profile %s", *profile)
+		return 2
+	}
+	if !store.IsCaConfigured() {
+		logger.Error("Sub CA is not configured.")
+		return 1
+	}
+
+	var users est.Users
+	if *usersFile != "" {
+		var err error
+		users, err = est.LoadUsers(*usersFile)
+		if err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+	}
+
+	var enrollers est.Enrollers
+	if *enrollersFile != "" {
+		var err error
+		enrollers, err = est.LoadEnrollers(*enrollersFile)
+		if err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+	}
+
+	authority, err := verifiedCA()
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+
+	clientCAs := x509.NewCertPool()
+	for _, c := range authority.CertificateChain() {
+		clientCAs.AddCert(c)
+	}
+
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           &est.Server{CA: authority, Users: users, Enrollers: enrollers, DefaultProfile: *profile},
+		ReadHeaderTimeout: 10 * time.Second,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			ClientCAs:  clientCAs,
+		},
+	}
+
+	logger.Info("EST listening on %s", *listen)
+	if err := server.ListenAndServeTLS(*tlsCert, *tlsKey); err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	return 0
+}
+
+// verifiedCA opens the CA and checks the key under the store lock.
+func verifiedCA() (*tinypki.CA, error) {
+	lock, err := store.LockStore(true)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Release()
+
+	authority := openCA()
+	if err := authority.VerifySubAuthority(); err != nil {
+		return nil, err
+	}
+	return authority, nil
+}
+
+func runESTAddUser(args []string) int {
+	fs := flag.NewFlagSet("est adduser", flag.ContinueOnError)
+	usersFile := fs.String("users", "", "the users file, the user is appended")
+	profiles := fs.String("profiles", "", "comma separated profiles the user may enroll, the default profile of the server if empty")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *usersFile == "" || fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: tpkisub est adduser -users file [-profiles name,...] <name>")
+		return 2
+	}
+	allowed, err := tinypki.ParseProfiles(*profiles)
+	if err != nil {
+		logger.Error("%v", err)
+		return 2
+	}
+
+	line, err := est.UserLine(fs.Arg(0), allowed, terminal.AskNewUserPassword(fs.Arg(0)))
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+
+	f, err := os.OpenFile(*usersFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	return 0
+}
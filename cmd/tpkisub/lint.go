@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/pkg/tinypki"
+)
+
+const lintUsage = "usage: tpkisub lint policy -block error|warning|notice|none | lint cert <certificate file>"
+
+func runLint(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, lintUsage)
+		return 2
+	}
+	if !store.IsCaConfigured() {
+		logger.Error("Sub CA is not configured.")
+		return 1
+	}
+
+	switch args[0] {
+	case "policy":
+		fs := flag.NewFlagSet("lint policy", flag.ContinueOnError)
+		block := fs.String("block", "error", "the lowest severity of findings which stops signing")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if fs.NArg() != 0 {
+			fmt.Fprintln(os.Stderr, "usage: tpkisub lint policy -block error|warning|notice|none")
+			return 2
+		}
+		if err := openCA().SetLintBlocking(*block); err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+		return 0
+	case "cert":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: tpkisub lint cert <certificate file>")
+			return 2
+		}
+		raw, err := os.ReadFile(args[1])
+		if err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+		if block, _ := pem.Decode(raw); block != nil {
+			raw = block.Bytes
+		}
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+
+		// linting needs the CA certificate only, not the key
+		authority, err := tinypki.New(tinypki.Options{Store: store})
+		if err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+		findings := authority.LintCertificate(cert)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SEVERITY\tLINT\tMESSAGE")
+		for _, f := range findings {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", f.Severity, f.Lint, f.Message)
+		}
+		w.Flush()
+		if len(authority.BlockingLint(findings)) > 0 {
+			return 1
+		}
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown lint command %s\n", args[0])
+		return 2
+	}
+}
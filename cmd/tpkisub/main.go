@@ -1,43 +1,241 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
 
-	"deleteonerror.com/tyinypki/internal/ca"
-	"deleteonerror.com/tyinypki/internal/data"
 	"deleteonerror.com/tyinypki/internal/logger"
 	"deleteonerror.com/tyinypki/internal/terminal"
+	"deleteonerror.com/tyinypki/pkg/tinypki"
 )
 
+var store tinypki.Store
+
 func init() {
 	log.SetFlags(log.LstdFlags)
 	log.SetFlags(log.Flags() &^ (log.Lshortfile | log.Llongfile))
-	data.Initialize()
+	logger.FromEnvironment()
+	store = tinypki.StoreFromEnvironment()
+}
+
+// openCA returns the sub CA on the store, the passphrase is asked on the terminal.
+func openCA() *tinypki.CA {
+	authority, err := tinypki.New(tinypki.Options{Store: store, Passphrase: terminal.AskPassphrase()})
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+	return authority
 }
 
 func main() {
 
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	lock, err := store.LockStore(true)
+	if err != nil {
+		logger.Error("%v", err)
+		os.Exit(1)
+	}
+	defer lock.Release()
+
 	// ToDo: run as daemon (only sub ca) and watch files in in/request and in in/revoke
-	if data.IsCaConfigured() {
-		pass := terminal.AskPassphrase()
-		ca.VerifySubAuthority(pass)
+	var authority *tinypki.CA
+	if store.IsCaConfigured() {
+		authority = openCA()
+		if err := authority.VerifySubAuthority(); err != nil {
+			logger.Error("%v", err)
+			os.Exit(1)
+		}
+
+		if authority.CanIssueCaCertificates() {
+			err := authority.IssuePendingCaRequests()
+			if err != nil {
+				logger.Error("Issuance of pending ca request Failed: %v", err)
+			}
+		}
 	} else {
-		config, err := data.ReadSetupConfiguration(false)
+		config, err := store.ReadSetupConfiguration(false)
 		if err != nil {
 			logger.Warning("Configuration not found.")
 			config = terminal.GetSubConfigInteractive()
 		}
-		pass := terminal.AskPassphrase()
-		data.SetupFolders()
-		err = ca.SetupSubAuthority(config, pass)
+		authority = openCA()
+		store.SetupFolders()
+		err = authority.SetupSubAuthority(config)
 		if err != nil {
 			logger.Error("Setup failed: %v", err)
 			os.Exit(1)
 		}
 	}
-	err := ca.IssuePendingRequests()
+	err = authority.IssuePendingRequests()
 	if err != nil {
 		logger.Error("Issuance of pending request Failed: %v", err)
 	}
+
+	if store.IsSSHConfigured() {
+		err = authority.IssuePendingSSHRequests()
+		if err != nil {
+			logger.Error("Signing of pending ssh keys Failed: %v", err)
+		}
+	}
+}
+
+func runCommand(args []string) int {
+	// the servers take the store lock per request
+	switch {
+	case args[0] == "est":
+		return runEST(args[1:])
+	case args[0] == "api":
+		return runAPI(args[1:])
+	case args[0] == "scep" && len(args) > 1 && args[1] == "serve":
+		return runSCEPServe(args[2:])
+	}
+
+	lock, err := store.LockStore(!readOnly(args))
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	defer lock.Release()
+
+	switch args[0] {
+	case "export-bundle":
+		return runExportBundle(args[1:])
+	case "import-bundle":
+		return runImportBundle(args[1:])
+	case "ssh":
+		return runSSH(args[1:])
+	case "scep":
+		return runSCEP(args[1:])
+	case "requests":
+		return runRequests(args[1:])
+	case "lint":
+		return runLint(args[1:])
+	case "sign":
+		return runSign(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %s\n", args[0])
+		fmt.Fprintln(os.Stderr, "usage: tpkisub [sign [-profile name] [-csr file] [-out folder] | export-bundle [-out file] | import-bundle [-fingerprint hex] <file> | ssh init | ssh revoke [-reason reason] <serial> | ssh krl | est [-listen addr] -tls-cert file -tls-key file [-users file] [-enrollers file] [-profile name] | est adduser -users file [-profiles name,...] <name> | scep init|serve|challenge | api [-listen addr] -tls-cert file -tls-key file [-tokens file] [-operators sha256,...] [-requester-profiles name,...] | api token -tokens file [-role requester|operator] <name> | requests list|show|approve|reject|policy | lint policy|cert]")
+		return 2
+	}
+}
+
+// readOnly reports if the command only reads the store, such commands share the store lock.
+func readOnly(args []string) bool {
+	if len(args) > 1 && (args[0] == "requests" && (args[1] == "list" || args[1] == "show") || args[0] == "lint" && args[1] == "cert") {
+		return true
+	}
+	return args[0] == "export-bundle"
+}
+
+func runExportBundle(args []string) int {
+	fs := flag.NewFlagSet("export-bundle", flag.ContinueOnError)
+	out := fs.String("out", "", "the bundle file, default is a new file in the bundles folder")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if !store.IsCaConfigured() {
+		logger.Error("Sub CA is not configured.")
+		return 1
+	}
+
+	file, err := openCA().ExportBundle(tinypki.SubBundleKinds, *out)
+	if err != nil {
+		logger.Error("Export failed: %v", err)
+		return 1
+	}
+	fmt.Println(file)
+	return 0
+}
+
+func runImportBundle(args []string) int {
+	fs := flag.NewFlagSet("import-bundle", flag.ContinueOnError)
+	fingerprint := fs.String("fingerprint", "", "expected SHA-256 fingerprint of the producer key")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: tpkisub import-bundle [-fingerprint hex] <bundle file>")
+		return 2
+	}
+	if !store.IsCaConfigured() {
+		logger.Error("Sub CA is not configured.")
+		return 1
+	}
+
+	err := openCA().ImportBundle(fs.Arg(0), *fingerprint)
+	if err != nil {
+		logger.Error("Import failed: %v", err)
+		return 1
+	}
+	return 0
+}
+
+func runSSH(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: tpkisub ssh init | ssh revoke [-reason reason] <serial> | ssh krl")
+		return 2
+	}
+	if !store.IsCaConfigured() {
+		logger.Error("Sub CA is not configured.")
+		return 1
+	}
+	if args[0] != "init" && !store.IsSSHConfigured() {
+		logger.Error("SSH CA is not configured, run tpkisub ssh init.")
+		return 1
+	}
+
+	switch args[0] {
+	case "init":
+		store.SetupFolders()
+		err := openCA().SetupSSHAuthority()
+		if err != nil {
+			logger.Error("Setup failed: %v", err)
+			return 1
+		}
+		return 0
+	case "revoke":
+		fs := flag.NewFlagSet("ssh revoke", flag.ContinueOnError)
+		reason := fs.String("reason", "unspecified", "RFC 5280 revocation reason, e.g. keyCompromise, superseded or cessationOfOperation")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "usage: tpkisub ssh revoke [-reason reason] <serial>")
+			return 2
+		}
+		serial, err := strconv.ParseUint(fs.Arg(0), 10, 64)
+		if err != nil {
+			logger.Error("invalid serial %s", fs.Arg(0))
+			return 2
+		}
+		code, err := tinypki.ParseRevocationReason(*reason)
+		if err != nil {
+			logger.Error("%v", err)
+			return 2
+		}
+
+		err = openCA().RevokeSSH(serial, code)
+		if err != nil {
+			logger.Error("Revocation failed: %v", err)
+			return 1
+		}
+		return 0
+	case "krl":
+		_, err := openCA().GenerateKRL()
+		if err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown ssh command %s\n", args[0])
+		return 2
+	}
 }
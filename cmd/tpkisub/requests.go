@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+const requestsUsage = "usage: tpkisub requests list [-status status] | requests show <id> | requests approve <id> | requests reject -reason text <id> | requests policy [-approval=true|false] [-sensitive profile,...]"
+
+func runRequests(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, requestsUsage)
+		return 2
+	}
+	if !store.IsCaConfigured() {
+		logger.Error("Sub CA is not configured.")
+		return 1
+	}
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("requests list", flag.ContinueOnError)
+		status := fs.String("status", "", "only list requests with the status, pending, issued or rejected")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		requests, err := store.ReadRequestRegistry()
+		if err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTATUS\tPROFILE\tAPPROVALS\tRECEIVED\tREQUESTER\tSUBJECT")
+		for _, r := range requests {
+			if *status != "" && r.Status != *status {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", r.ID, r.Status, r.Profile, len(r.Approvals), r.Received.Format(time.RFC3339), r.Requester, r.Subject)
+		}
+		w.Flush()
+		return 0
+	case "show":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: tpkisub requests show <id>")
+			return 2
+		}
+		requests, err := store.ReadRequestRegistry()
+		if err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+		for _, r := range requests {
+			if r.ID == args[1] {
+				printRequest(r)
+				return 0
+			}
+		}
+		logger.Error("no request %s found", args[1])
+		return 1
+	case "approve":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "usage: tpkisub requests approve <id>")
+			return 2
+		}
+		record, err := openCA().ApproveRequest(args[1])
+		if err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+		printRequest(record)
+		return 0
+	case "reject":
+		fs := flag.NewFlagSet("requests reject", flag.ContinueOnError)
+		reason := fs.String("reason", "", "the reason of the rejection, it is written back for the requester")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if fs.NArg() != 1 || *reason == "" {
+			fmt.Fprintln(os.Stderr, "usage: tpkisub requests reject -reason text <id>")
+			return 2
+		}
+		if _, err := openCA().RejectRequest(fs.Arg(0), *reason); err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+		return 0
+	case "policy":
+		fs := flag.NewFlagSet("requests policy", flag.ContinueOnError)
+		approval := fs.Bool("approval", true, "keep new requests pending until an operator approves them")
+		sensitive := fs.String("sensitive", "", "comma separated profiles which need two distinct approvers")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if fs.NArg() != 0 {
+			fmt.Fprintln(os.Stderr, "usage: tpkisub requests policy [-approval=true|false] [-sensitive profile,...]")
+			return 2
+		}
+		var profiles []string
+		for _, p := range strings.Split(*sensitive, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				profiles = append(profiles, p)
+			}
+		}
+		if err := openCA().SetApprovalPolicy(*approval, profiles); err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown requests command %s\n", args[0])
+		return 2
+	}
+}
+
+func printRequest(r model.RequestRecord) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\t%s\n", r.ID)
+	fmt.Fprintf(w, "Status\t%s\n", r.Status)
+	fmt.Fprintf(w, "Profile\t%s\n", r.Profile)
+	fmt.Fprintf(w, "Subject\t%s\n", r.Subject)
+	fmt.Fprintf(w, "Requester\t%s\n", r.Requester)
+	if r.Source != "" {
+		fmt.Fprintf(w, "File\t%s\n", r.Source)
+	}
+	if r.Transaction != "" {
+		fmt.Fprintf(w, "Transaction\t%s\n", r.Transaction)
+	}
+	fmt.Fprintf(w, "Received\t%s\n", r.Received.Format(time.RFC3339))
+	for _, a := range r.Approvals {
+		fmt.Fprintf(w, "Approved\t%s by %s (uid %s)\n", a.Time.Format(time.RFC3339), a.Operator, a.UID)
+	}
+	if r.Rejection != nil {
+		fmt.Fprintf(w, "Rejected\t%s by %s (uid %s)\n", r.Rejection.Time.Format(time.RFC3339), r.Rejection.Operator, r.Rejection.UID)
+	}
+	if r.Reason != "" {
+		fmt.Fprintf(w, "Reason\t%s\n", r.Reason)
+	}
+	if r.Serial != nil {
+		fmt.Fprintf(w, "Serial\t%s\n", r.Serial)
+	}
+	for _, f := range r.Lint {
+		fmt.Fprintf(w, "Lint\t%s %s: %s\n", f.Severity, f.Lint, f.Message)
+	}
+	w.Flush()
+}
+
+// currentUser is the name of the OS user running tpkisub.
+func currentUser() string {
+	u, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return u.Username
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/scep"
+)
+
+const scepUsage = "usage: tpkisub scep init | scep serve [-listen addr] [-tls-cert file -tls-key file] | scep challenge [-profile client|server] [-valid duration] [-approval]"
+
+func runSCEP(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, scepUsage)
+		return 2
+	}
+	if !store.IsCaConfigured() {
+		logger.Error("Sub CA is not configured.")
+		return 1
+	}
+	if args[0] != "init" && !store.IsSCEPConfigured() {
+		logger.Error("SCEP is not configured, run tpkisub scep init.")
+		return 1
+	}
+
+	switch args[0] {
+	case "init":
+		store.SetupFolders()
+		err := openCA().SetupSCEPAuthority()
+		if err != nil {
+			logger.Error("Setup failed: %v", err)
+			return 1
+		}
+		return 0
+	case "challenge":
+		fs := flag.NewFlagSet("scep challenge", flag.ContinueOnError)
+		profile := fs.String("profile", "client", "the profile of the certificate, client or server")
+		valid := fs.Duration("valid", 24*time.Hour, "how long the challenge can be used")
+		approval := fs.Bool("approval", false, "hold the request until operators approve it with tpkisub requests")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+
+		password, err := openCA().NewSCEPChallenge(*profile, *valid, *approval)
+		if err != nil {
+			logger.Error("%v", err)
+			return 1
+		}
+		fmt.Println(password)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown scep command %s\n", args[0])
+		return 2
+	}
+}
+
+// runSCEPServe serves SCEP, the server takes the store lock per request.
+func runSCEPServe(args []string) int {
+	fs := flag.NewFlagSet("scep serve", flag.ContinueOnError)
+	listen := fs.String("listen", ":8080", "the address to listen on")
+	tlsCert := fs.String("tls-cert", "", "the PEM encoded server certificate with its chain, SCEP messages are protected without TLS")
+	tlsKey := fs.String("tls-key", "", "the PEM encoded server key")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if (*tlsCert == "") != (*tlsKey == "") || fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: tpkisub scep serve [-listen addr] [-tls-cert file -tls-key file]")
+		return 2
+	}
+	if !store.IsCaConfigured() || !store.IsSCEPConfigured() {
+		logger.Error("SCEP is not configured, run tpkisub scep init.")
+		return 1
+	}
+
+	authority, err := verifiedCA()
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	if _, _, err := authority.SCEPRecipient(); err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+
+	handler := &scep.Server{CA: authority}
+	mux := http.NewServeMux()
+	mux.Handle("/scep", handler)
+	mux.Handle("/cgi-bin/pkiclient.exe", handler)
+
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	logger.Info("SCEP listening on %s", *listen)
+	if *tlsCert != "" {
+		err = server.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		logger.Error("%v", err)
+		return 1
+	}
+	return 0
+}
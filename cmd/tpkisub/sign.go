@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+	"deleteonerror.com/tyinypki/internal/terminal"
+	"deleteonerror.com/tyinypki/pkg/tinypki"
+)
+
+// Exit codes of tpkisub sign, scripts tell a refused request from a broken one.
+const (
+	signFailed   = 1
+	signUsage    = 2
+	signParse    = 3
+	signRejected = 4
+	signUnlock   = 5
+	signPending  = 6
+)
+
+// runSign issues a single request read from stdin or a file and writes the certificate to stdout
+// or a folder. The request is submitted like a request of the API, the request folders are not scanned.
+func runSign(args []string) int {
+	fs := flag.NewFlagSet("sign", flag.ContinueOnError)
+	profile := fs.String("profile", "request", "the certificate profile, one of "+strings.Join(tinypki.Profiles, ", "))
+	csrFile := fs.String("csr", "", "the certificate request, default is stdin")
+	out := fs.String("out", "", "the folder to write the certificate to, default is stdout")
+	if err := fs.Parse(args); err != nil {
+		return signUsage
+	}
+	if fs.NArg() != 0 {
+		fmt.Fprintln(os.Stderr, "usage: tpkisub sign [-profile name] [-csr file] [-out folder] < request > certificate")
+		return signUsage
+	}
+	if !slices.Contains(tinypki.Profiles, *profile) {
+		logger.Error("unknown profile %s", *profile)
+		return signUsage
+	}
+	if !store.IsCaConfigured() {
+		logger.Error("Sub CA is not configured.")
+		return signFailed
+	}
+
+	var raw []byte
+	var err error
+	if *csrFile == "" {
+		raw, err = io.ReadAll(os.Stdin)
+	} else {
+		raw, err = os.ReadFile(*csrFile)
+	}
+	if err != nil {
+		logger.Error("%v", err)
+		return signFailed
+	}
+	csr, err := parseRequest(raw)
+	if err != nil {
+		logger.Error("Failed to parse request: %v", err)
+		return signParse
+	}
+
+	passphrase, err := terminal.AskPassphraseOnTerminal()
+	if err != nil {
+		logger.Error("%v", err)
+		return signUnlock
+	}
+	authority, err := tinypki.New(tinypki.Options{Store: store, Passphrase: passphrase})
+	if err == nil {
+		err = authority.Unlock()
+	}
+	if err != nil {
+		logger.Error("%v", err)
+		return signUnlock
+	}
+	if authority.Certificate() == nil {
+		logger.Error("No Sub Ca Certificate found.")
+		return signFailed
+	}
+
+	requester := currentUser()
+	if requester == "" {
+		requester = "sign"
+	}
+	record, err := authority.SubmitRequest(csr, *profile, requester)
+	if err != nil {
+		logger.Error("%v", err)
+		return signFailed
+	}
+	switch record.Status {
+	case model.RequestPending:
+		logger.Warning("Request %s is pending approval, see tpkisub requests show %s", record.ID, record.ID)
+		return signPending
+	case model.RequestRejected:
+		logger.Error("Request %s rejected: %s", record.ID, record.Reason)
+		return signRejected
+	}
+
+	cert, err := authority.IssuedCertificate(record.Serial)
+	if err != nil {
+		logger.Error("%v", err)
+		return signFailed
+	}
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if *out == "" {
+		if _, err := os.Stdout.Write(encoded); err != nil {
+			logger.Error("%v", err)
+			return signFailed
+		}
+		return 0
+	}
+
+	name := cert.Subject.CommonName
+	if *csrFile != "" {
+		name = strings.TrimSuffix(filepath.Base(*csrFile), filepath.Ext(*csrFile))
+	}
+	file := filepath.Join(*out, name+".pem")
+	if err := os.WriteFile(file, encoded, 0644); err != nil {
+		logger.Error("%v", err)
+		return signFailed
+	}
+	fmt.Println(file)
+	return 0
+}
+
+// parseRequest parses a PEM or DER encoded certificate request and checks its signature.
+func parseRequest(raw []byte) (*x509.CertificateRequest, error) {
+	if block, _ := pem.Decode(raw); block != nil {
+		if block.Type != "CERTIFICATE REQUEST" && block.Type != "NEW CERTIFICATE REQUEST" {
+			return nil, fmt.Errorf("unexpected pem block %s", block.Type)
+		}
+		raw = block.Bytes
+	}
+	csr, err := x509.ParseCertificateRequest(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid request signature: %w", err)
+	}
+	return csr, nil
+}
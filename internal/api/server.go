@@ -0,0 +1,338 @@
+// Package api serves a JSON HTTP API to submit certificate requests, download certificates
+// and, for operators, to search and revoke issued certificates.
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"crypto/x509"
+
+	"deleteonerror.com/tyinypki/internal/ca"
+	"deleteonerror.com/tyinypki/internal/data"
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// maxRequestSize limits the size of a posted JSON body.
+const maxRequestSize = 64 * 1024
+
+// Server routes the API below /api/v1. Clients authenticate with a bearer token or with a valid
+// client certificate of the CA. Certificates are issued by CA.Issue with the requested profile.
+type Server struct {
+	CA     *ca.Authority
+	Tokens Tokens
+	// Operators are the hex encoded SHA-256 fingerprints of the client certificates with the operator role,
+	// all other valid client certificates of the CA are requesters. The subject is chosen by the requester,
+	// so it does not make an operator.
+	Operators []string
+	// RequesterProfiles are the profiles requesters may submit, operators may submit every profile.
+	RequesterProfiles []string
+
+	// mu serializes the use of the CA, the store lock is taken per request
+	mu   sync.Mutex
+	once sync.Once
+	mux  *http.ServeMux
+}
+
+type submitRequest struct {
+	// CSR is the PEM encoded certificate request.
+	CSR     string `json:"csr"`
+	Profile string `json:"profile"`
+}
+
+type revokeRequest struct {
+	Reason string `json:"reason"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.once.Do(func() {
+		s.mux = http.NewServeMux()
+		s.mux.HandleFunc("POST /api/v1/requests", s.handle(true, RoleRequester, s.submit))
+		s.mux.HandleFunc("GET /api/v1/requests", s.handle(false, RoleRequester, s.listRequests))
+		s.mux.HandleFunc("GET /api/v1/requests/{id}", s.handle(false, RoleRequester, s.getRequest))
+		s.mux.HandleFunc("GET /api/v1/requests/{id}/certificate", s.handle(false, RoleRequester, s.getCertificate))
+		s.mux.HandleFunc("GET /api/v1/requests/{id}/chain", s.handle(false, RoleRequester, s.getCertificate))
+		s.mux.HandleFunc("GET /api/v1/certificates", s.handle(false, RoleOperator, s.listCertificates))
+		s.mux.HandleFunc("POST /api/v1/certificates/{serial}/revoke", s.handle(true, RoleOperator, s.revoke))
+	})
+	s.mux.ServeHTTP(w, r)
+}
+
+// handle takes the store lock, authenticates the client and checks its role before calling fn.
+func (s *Server) handle(exclusive bool, role string, fn func(http.ResponseWriter, *http.Request, identity)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		lock, err := s.CA.Store().LockStore(exclusive)
+		if err != nil {
+			if errors.Is(err, data.ErrLocked) {
+				w.Header().Set("Retry-After", "10")
+				writeError(w, http.StatusServiceUnavailable, "the ca is busy")
+				return
+			}
+			writeError(w, http.StatusInternalServerError, "internal error")
+			return
+		}
+		defer lock.Release()
+		s.CA.Refresh()
+
+		client, ok := s.authenticate(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tinypki"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if role == RoleOperator && client.role != RoleOperator {
+			writeError(w, http.StatusForbidden, "operators only")
+			return
+		}
+		fn(w, r, client)
+	}
+}
+
+// authenticate returns the owner of the bearer token or of the TLS client certificate.
+func (s *Server) authenticate(r *http.Request) (identity, bool) {
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		client, ok := s.Tokens.lookup(strings.TrimSpace(token))
+		if !ok {
+			logger.Warning("API bearer token rejected")
+		}
+		return client, ok
+	}
+
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return identity{}, false
+	}
+	peer := r.TLS.PeerCertificates[0]
+	if err := s.CA.VerifyIssued(peer); err != nil {
+		logger.Warning("API client certificate %s rejected: %v", peer.Subject.CommonName, err)
+		return identity{}, false
+	}
+	// the subject is chosen by the requester, the fingerprint names the certificate
+	sum := sha256.Sum256(peer.Raw)
+	fingerprint := hex.EncodeToString(sum[:])
+	client := identity{name: certificatePrefix + fingerprint, role: RoleRequester}
+	if slices.Contains(s.Operators, fingerprint) {
+		client.role = RoleOperator
+	}
+	return client, true
+}
+
+func (s *Server) submit(w http.ResponseWriter, r *http.Request, client identity) {
+	var req submitRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if !slices.Contains(ca.Profiles, req.Profile) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown profile %q", req.Profile))
+		return
+	}
+	if client.role != RoleOperator && !slices.Contains(s.RequesterProfiles, req.Profile) {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("profile %q is for operators only", req.Profile))
+		return
+	}
+	block, _ := pem.Decode([]byte(req.CSR))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		writeError(w, http.StatusBadRequest, "no pem encoded certificate request found")
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	record, err := s.CA.SubmitRequest(csr, req.Profile, client.name)
+	if err != nil {
+		logger.Error("%v", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+	w.Header().Set("Location", "/api/v1/requests/"+record.ID)
+	writeJSON(w, http.StatusCreated, record)
+}
+
+// listRequests returns the requests of the client, operators see all requests.
+func (s *Server) listRequests(w http.ResponseWriter, r *http.Request, client identity) {
+	records, err := s.CA.RequestRecords()
+	if err != nil {
+		logger.Error("%v", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	result := []model.RequestRecord{}
+	for _, record := range records {
+		if client.role != RoleOperator && record.Requester != client.name {
+			continue
+		}
+		if status != "" && record.Status != status {
+			continue
+		}
+		result = append(result, record)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) getRequest(w http.ResponseWriter, r *http.Request, client identity) {
+	record, ok := s.visibleRequest(w, r, client)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, record)
+}
+
+// getCertificate returns the issued certificate of a request as PEM, the chain path adds the CA chain without the root.
+func (s *Server) getCertificate(w http.ResponseWriter, r *http.Request, client identity) {
+	record, ok := s.visibleRequest(w, r, client)
+	if !ok {
+		return
+	}
+	if !slices.Contains(ca.Profiles, record.Profile) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("request %s has no x509 certificate", record.ID))
+		return
+	}
+	if record.Status != model.RequestIssued || record.Serial == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("request %s is %s", record.ID, record.Status))
+		return
+	}
+
+	cert, err := s.CA.IssuedCertificate(record.Serial)
+	if err != nil {
+		logger.Error("%v", err)
+		writeError(w, http.StatusNotFound, "certificate not found")
+		return
+	}
+
+	if strings.HasSuffix(r.URL.Path, "/chain") {
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		_, _ = w.Write(s.CA.IssuedChain(cert))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	_, _ = w.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+// visibleRequest returns the request of the path if the client submitted it or is an operator.
+func (s *Server) visibleRequest(w http.ResponseWriter, r *http.Request, client identity) (model.RequestRecord, bool) {
+	record, found, err := s.CA.RequestRecord(r.PathValue("id"))
+	if err != nil {
+		logger.Error("%v", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return record, false
+	}
+	if !found || (client.role != RoleOperator && record.Requester != client.name) {
+		writeError(w, http.StatusNotFound, "request not found")
+		return record, false
+	}
+	return record, true
+}
+
+// listCertificates searches the issued certificates. q matches a part of the subject or of a DNS name,
+// status is valid, revoked or expired and serial is a decimal serial number.
+func (s *Server) listCertificates(w http.ResponseWriter, r *http.Request, client identity) {
+	certs, err := s.CA.Certificates()
+	if err != nil {
+		logger.Error("%v", err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+		return
+	}
+
+	query := r.URL.Query()
+	q := strings.ToLower(query.Get("q"))
+	result := []model.IssuedCertificate{}
+	for _, c := range certs {
+		if status := query.Get("status"); status != "" && c.Status != status {
+			continue
+		}
+		if serial := query.Get("serial"); serial != "" && c.Serial.String() != serial {
+			continue
+		}
+		if q != "" && !strings.Contains(strings.ToLower(c.Subject), q) &&
+			!slices.ContainsFunc(c.DNSNames, func(name string) bool { return strings.Contains(strings.ToLower(name), q) }) {
+			continue
+		}
+		result = append(result, c)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) revoke(w http.ResponseWriter, r *http.Request, client identity) {
+	serial, ok := new(big.Int).SetString(r.PathValue("serial"), 10)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "invalid serial")
+		return
+	}
+	req := revokeRequest{Reason: "unspecified"}
+	if r.ContentLength != 0 && !readJSON(w, r, &req) {
+		return
+	}
+	reason, err := ca.ParseRevocationReason(req.Reason)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.CA.Revoke(serial, reason); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	logger.Info("API revoked serial %s for %s", serial, client.name)
+
+	certs, err := s.CA.Certificates()
+	if err == nil {
+		for _, c := range certs {
+			if c.Serial.Cmp(serial) == 0 {
+				writeJSON(w, http.StatusOK, c)
+				return
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	if len(body) > maxRequestSize {
+		writeError(w, http.StatusRequestEntityTooLarge, "request too large")
+		return false
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("%v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, errorResponse{Error: message})
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Roles of the API clients, operators may do everything requesters may do.
+const (
+	RoleRequester = "requester"
+	RoleOperator  = "operator"
+)
+
+var roles = []string{RoleRequester, RoleOperator}
+
+// DefaultRequesterProfiles are the profiles requesters may submit unless configured otherwise. The request
+// profile copies the key usages of the CSR, code and ocsp sign for others, they are left to operators.
+var DefaultRequesterProfiles = []string{"server", "webserver", "client"}
+
+// ParseFingerprints parses comma separated hex encoded SHA-256 fingerprints, colons between the bytes are allowed.
+func ParseFingerprints(list string) ([]string, error) {
+	var fingerprints []string
+	for _, f := range strings.Split(list, ",") {
+		f = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(f), ":", ""))
+		if f == "" {
+			continue
+		}
+		if raw, err := hex.DecodeString(f); err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", f)
+		}
+		fingerprints = append(fingerprints, f)
+	}
+	return fingerprints, nil
+}
+
+// Prefixes of the names of API clients, so a token name never equals the name of a client certificate.
+const (
+	tokenPrefix       = "token:"
+	certificatePrefix = "cert:"
+)
+
+// identity is an authenticated API client, the name is the token name or the SHA-256 fingerprint
+// of the client certificate with its prefix.
+type identity struct {
+	name string
+	role string
+}
+
+// Tokens holds the SHA-256 hashes of the bearer tokens with the name and role of their owner.
+type Tokens []tokenEntry
+
+type tokenEntry struct {
+	identity
+	hash []byte
+}
+
+// LoadTokens reads a tokens file, each line is name:role:hash with the hex encoded SHA-256 hash
+// of the token. Empty lines and lines starting with # are skipped.
+func LoadTokens(path string) (Tokens, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens Tokens
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: expected name:role:hash", path, n)
+		}
+		if !slices.Contains(roles, fields[1]) {
+			return nil, fmt.Errorf("%s:%d: unknown role %s", path, n, fields[1])
+		}
+		hash, err := hex.DecodeString(fields[2])
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: invalid hash", path, n)
+		}
+		tokens = append(tokens, tokenEntry{identity: identity{name: tokenPrefix + fields[0], role: fields[1]}, hash: hash})
+	}
+	return tokens, scanner.Err()
+}
+
+// lookup returns the owner of the token.
+func (t Tokens) lookup(token string) (identity, bool) {
+	hash := sha256.Sum256([]byte(token))
+
+	var found identity
+	ok := false
+	for _, e := range t {
+		if subtle.ConstantTimeCompare(hash[:], e.hash) == 1 {
+			found, ok = e.identity, true
+		}
+	}
+	return found, ok
+}
+
+// NewToken returns a new random token and the line of the tokens file for it.
+func NewToken(name string, role string) (token string, line string, err error) {
+	if name == "" || strings.ContainsAny(name, ":\n") {
+		return "", "", fmt.Errorf("invalid token name %q", name)
+	}
+	if !slices.Contains(roles, role) {
+		return "", "", fmt.Errorf("unknown role %s", role)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(secret)
+	hash := sha256.Sum256([]byte(token))
+	return token, fmt.Sprintf("%s:%s:%s", name, role, hex.EncodeToString(hash[:])), nil
+}
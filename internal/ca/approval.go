@@ -0,0 +1,290 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os/user"
+	"slices"
+	"strings"
+
+	"deleteonerror.com/tyinypki/internal/model"
+	"golang.org/x/crypto/ssh"
+)
+
+// ApprovalRequired reports if new requests wait for the approval of an operator.
+func (a *Authority) ApprovalRequired() bool {
+	return a.getConfiguration().RequireApproval
+}
+
+// RequiredApprovals returns the number of distinct operators which have to approve the request. The request
+// profile takes the extended key usages from the request, so it needs two approvers like a sensitive profile
+// whose usages it asks for.
+func (a *Authority) RequiredApprovals(record model.RequestRecord) int {
+	sensitive := a.getConfiguration().SensitiveProfiles
+	if slices.Contains(sensitive, record.Profile) {
+		return 2
+	}
+	if record.Profile == "request" && requestsSensitiveUsage(record.Request, sensitive) {
+		return 2
+	}
+	return 1
+}
+
+// requestsSensitiveUsage reports if the certificate of the request would have an extended key usage of
+// one of the sensitive profiles. A request without extended key usages gets a certificate for any usage.
+func requestsSensitiveUsage(raw []byte, sensitive []string) bool {
+	var usages []x509.ExtKeyUsage
+	for _, p := range sensitive {
+		usages = append(usages, profileExtKeyUsages[p]...)
+		if p == "code" {
+			usages = append(usages, x509.ExtKeyUsageMicrosoftCommercialCodeSigning, x509.ExtKeyUsageMicrosoftKernelCodeSigning)
+		}
+	}
+	if len(usages) == 0 {
+		return false
+	}
+
+	csr, err := x509.ParseCertificateRequest(raw)
+	if err != nil {
+		return true
+	}
+	requested, err := getExtKeyUsage(*csr)
+	if err != nil || len(requested) == 0 || slices.Contains(requested, x509.ExtKeyUsageAny) {
+		return true
+	}
+	return slices.ContainsFunc(requested, func(u x509.ExtKeyUsage) bool { return slices.Contains(usages, u) })
+}
+
+// SetApprovalPolicy turns the approval of new requests on or off and sets the profiles which need two approvers.
+func (a *Authority) SetApprovalPolicy(require bool, sensitive []string) error {
+	for _, p := range sensitive {
+		if !slices.Contains(Profiles, p) && !slices.Contains(queueProfiles, p) {
+			return fmt.Errorf("unknown profile %s", p)
+		}
+	}
+	conf := a.getConfiguration()
+	if len(conf.Name) == 0 {
+		return errors.New("no ca configuration found")
+	}
+	conf.RequireApproval = require
+	conf.SensitiveProfiles = sensitive
+	if err := a.updateConfiguration(conf); err != nil {
+		return err
+	}
+	a.log.Info("Approval of requests required: %t, sensitive profiles: %v", require, sensitive)
+	return nil
+}
+
+// ProfileCA is the profile of a queued CA certificate request, ssh-user and ssh-host those of queued SSH public keys.
+const ProfileCA = "ca"
+
+// queueProfiles are the profiles of queued requests besides Profiles.
+var queueProfiles = []string{ProfileCA, sshProfile(model.SSHUser), sshProfile(model.SSHHost)}
+
+// PendingError is returned instead of a certificate if the request waits for the approval of operators.
+type PendingError struct {
+	Record model.RequestRecord
+}
+
+func (e *PendingError) Error() string {
+	return fmt.Sprintf("request %s waits for approval", e.Record.ID)
+}
+
+// QueueRequest records the request as pending until operators approve or reject it.
+func (a *Authority) QueueRequest(csr *x509.CertificateRequest, profile string, requester string) (model.RequestRecord, error) {
+	record, err := a.newRequestRecord(csr, profile, requester)
+	if err != nil {
+		return model.RequestRecord{}, err
+	}
+	_, err = a.IssueRequest(csr, profile, record, true)
+	var pending *PendingError
+	if errors.As(err, &pending) {
+		return pending.Record, nil
+	}
+	return model.RequestRecord{}, err
+}
+
+// issueOrQueue issues the request with the profile of the record or, if approval is required or hold is set,
+// queues the record and returns a *PendingError. All requests are issued through here, so an approval
+// policy applies to the request folders, EST, SCEP and the API alike.
+func (a *Authority) issueOrQueue(csr *x509.CertificateRequest, record model.RequestRecord, hold bool) (*x509.Certificate, error) {
+	if !hold && !a.ApprovalRequired() {
+		return a.issue(csr, record.Profile, record.Source)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid request signature: %w", err)
+	}
+	record.Subject = csr.Subject.String()
+	record.Request = csr.Raw
+	queued, err := a.queueRequest(record)
+	if err != nil {
+		return nil, err
+	}
+	a.log.Info("Request %s of %s for %s waits for %d approval(s)", queued.ID, queued.Requester, csr.Subject.CommonName, a.RequiredApprovals(queued))
+	return nil, &PendingError{Record: queued}
+}
+
+// queueRequest adds the record as pending, it gets a new id if it has none.
+func (a *Authority) queueRequest(record model.RequestRecord) (model.RequestRecord, error) {
+	if !slices.Contains(Profiles, record.Profile) && !slices.Contains(queueProfiles, record.Profile) {
+		return record, fmt.Errorf("unknown profile %s", record.Profile)
+	}
+	if record.ID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return record, err
+		}
+		record.ID = hex.EncodeToString(id)
+	}
+	if record.Received.IsZero() {
+		record.Received = a.now().UTC()
+	}
+	requests, err := a.store.ReadRequestRegistry()
+	if err != nil {
+		return record, err
+	}
+	for _, r := range requests {
+		if r.ID == record.ID {
+			return record, fmt.Errorf("request %s is already known", record.ID)
+		}
+	}
+
+	record.Status = model.RequestPending
+	if err := a.store.WriteRequestRegistry(append(requests, record)); err != nil {
+		return record, err
+	}
+	return record, nil
+}
+
+// ApproveRequest records the approval of the OS user running the process, see currentOperator.
+// A user can approve a request once, with the last required approval the certificate is issued.
+func (a *Authority) ApproveRequest(id string) (model.RequestRecord, error) {
+	approval, err := currentOperator()
+	if err != nil {
+		return model.RequestRecord{}, err
+	}
+	requests, i, err := a.pendingRequest(id)
+	if err != nil {
+		return model.RequestRecord{}, err
+	}
+	record := &requests[i]
+	for _, other := range record.Approvals {
+		if other.UID == approval.UID {
+			return model.RequestRecord{}, fmt.Errorf("request %s is already approved by %s (uid %s)", id, other.Operator, other.UID)
+		}
+	}
+	operator := approval.Operator
+	approval.Time = a.now().UTC()
+	record.Approvals = append(record.Approvals, approval)
+
+	required := a.RequiredApprovals(*record)
+	if len(record.Approvals) < required {
+		if err := a.store.WriteRequestRegistry(requests); err != nil {
+			return model.RequestRecord{}, err
+		}
+		a.log.Info("Request %s approved by %s, %d of %d approvals", id, operator, len(record.Approvals), required)
+		return *record, nil
+	}
+
+	if certType, ok := strings.CutPrefix(record.Profile, "ssh-"); ok {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(record.Request)
+		if err != nil {
+			return model.RequestRecord{}, err
+		}
+		cert, err := a.signSSHKey(pub, certType, record.Subject)
+		if err != nil {
+			return model.RequestRecord{}, err
+		}
+		record.Serial = new(big.Int).SetUint64(cert.Serial)
+		a.log.Info("Request %s approved by %s, signed ssh certificate %s (serial %d)", id, operator, cert.KeyId, cert.Serial)
+	} else {
+		csr, err := x509.ParseCertificateRequest(record.Request)
+		if err != nil {
+			return model.RequestRecord{}, err
+		}
+		cert, err := a.issue(csr, record.Profile, record.Source)
+		if err != nil {
+			return model.RequestRecord{}, err
+		}
+		record.Serial = cert.SerialNumber
+		if status, ok := a.queuedRequestStatus(*record); ok {
+			a.updateRequestStatus(record.Source, a.issuedStatus(status, cert))
+		}
+		a.log.Info("Request %s approved by %s, issued %s (serial %s)", id, operator, cert.Subject.CommonName, cert.SerialNumber)
+	}
+	record.Status = model.RequestIssued
+	record.Request = nil
+	if err := a.store.WriteRequestRegistry(requests); err != nil {
+		return model.RequestRecord{}, err
+	}
+	return *record, nil
+}
+
+// RejectRequest rejects a pending request. The reason is kept with the request and, for requests
+// of the request folders, written to the status file for the requester.
+func (a *Authority) RejectRequest(id string, reason string) (model.RequestRecord, error) {
+	if reason == "" {
+		return model.RequestRecord{}, errors.New("a rejection needs a reason")
+	}
+	rejection, err := currentOperator()
+	if err != nil {
+		return model.RequestRecord{}, err
+	}
+	requests, i, err := a.pendingRequest(id)
+	if err != nil {
+		return model.RequestRecord{}, err
+	}
+	operator := rejection.Operator
+	rejection.Time = a.now().UTC()
+	record := &requests[i]
+	record.Status = model.RequestRejected
+	record.Reason = reason
+	record.Rejection = &rejection
+	record.Request = nil
+	if err := a.store.WriteRequestRegistry(requests); err != nil {
+		return model.RequestRecord{}, err
+	}
+
+	if status, ok := a.queuedRequestStatus(*record); ok {
+		a.updateRequestStatus(record.Source, rejectedStatus(status, fmt.Sprintf("rejected by %s: %s", operator, reason)))
+	}
+	a.log.Info("Request %s rejected by %s: %s", id, operator, reason)
+	return *record, nil
+}
+
+// currentOperator returns the operator deciding on a request, tests replace it.
+var currentOperator = osOperator
+
+// osOperator returns the OS user running the process, approvals are bound to the user id, not
+// to a name the operator could choose. Root is refused, it could act as any user.
+func osOperator() (model.RequestDecision, error) {
+	u, err := user.Current()
+	if err != nil {
+		return model.RequestDecision{}, fmt.Errorf("the operator is unknown: %w", err)
+	}
+	if u.Uid == "0" {
+		return model.RequestDecision{}, errors.New("root can not decide on requests, run the command as the user of the operator")
+	}
+	return model.RequestDecision{Operator: u.Username, UID: u.Uid}, nil
+}
+
+func (a *Authority) pendingRequest(id string) ([]model.RequestRecord, int, error) {
+	requests, err := a.store.ReadRequestRegistry()
+	if err != nil {
+		return nil, 0, err
+	}
+	for i, r := range requests {
+		if r.ID != id {
+			continue
+		}
+		if r.Status != model.RequestPending {
+			return nil, 0, fmt.Errorf("request %s is %s", id, r.Status)
+		}
+		return requests, i, nil
+	}
+	return nil, 0, fmt.Errorf("no request %s found", id)
+}
@@ -0,0 +1,144 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"os"
+	"testing"
+
+	"deleteonerror.com/tyinypki/internal/data"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// asOperator makes the approvals of the test come from the uid.
+func asOperator(t *testing.T, uid string) {
+	t.Helper()
+	previous := currentOperator
+	currentOperator = func() (model.RequestDecision, error) {
+		return model.RequestDecision{Operator: "operator" + uid, UID: uid}, nil
+	}
+	t.Cleanup(func() { currentOperator = previous })
+}
+
+// queueTestRequest issues the request with the profile and returns the record it is queued with.
+func queueTestRequest(t *testing.T, a *Authority, csr *x509.CertificateRequest, profile string) model.RequestRecord {
+	t.Helper()
+	_, err := a.Issue(csr, profile)
+	var pending *PendingError
+	if !errors.As(err, &pending) {
+		t.Fatalf("Issue error = %v, want the request queued", err)
+	}
+	return pending.Record
+}
+
+// ekuRequest returns a request for the extended key usages, none leaves the extension out.
+func ekuRequest(t *testing.T, cn string, usages ...asn1.ObjectIdentifier) *x509.CertificateRequest {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: cn}, DNSNames: []string{cn}}
+	if len(usages) > 0 {
+		value, err := asn1.Marshal(usages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{2, 5, 29, 37}, Value: value}}
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+func TestApproveSensitiveRequest(t *testing.T) {
+	a := newTestAuthority(t, data.NewMemoryStore())
+	if err := a.SetApprovalPolicy(true, []string{"code"}); err != nil {
+		t.Fatal(err)
+	}
+	record := queueTestRequest(t, a, testRequest(t, "signer"), "code")
+
+	asOperator(t, "1001")
+	approved, err := a.ApproveRequest(record.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approved.Status != model.RequestPending || approved.Serial != nil {
+		t.Fatalf("request is %s after one of two approvals", approved.Status)
+	}
+	if _, err := a.ApproveRequest(record.ID); err == nil {
+		t.Fatal("the same operator approved twice")
+	}
+
+	asOperator(t, "1002")
+	approved, err = a.ApproveRequest(record.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approved.Status != model.RequestIssued || approved.Serial == nil || len(approved.Approvals) != 2 {
+		t.Fatalf("request is %s with %d approvals, want issued after two", approved.Status, len(approved.Approvals))
+	}
+	cert, err := a.IssuedCertificate(approved.Serial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if CertificateProfile(cert) != "code" {
+		t.Errorf("issued certificate has the extended key usages %v", cert.ExtKeyUsage)
+	}
+}
+
+func TestRequiredApprovalsOfRequestProfile(t *testing.T) {
+	a := newTestAuthority(t, data.NewMemoryStore())
+	if err := a.SetApprovalPolicy(true, []string{"code"}); err != nil {
+		t.Fatal(err)
+	}
+	asOperator(t, "1001")
+	codeSigning := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 3}
+	serverAuth := asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}
+
+	for _, tc := range []struct {
+		name     string
+		csr      *x509.CertificateRequest
+		required int
+	}{
+		{"code signing", ekuRequest(t, "signer.example.com", serverAuth, codeSigning), 2},
+		{"server auth", ekuRequest(t, "host.example.com", serverAuth), 1},
+		{"any usage", ekuRequest(t, "any.example.com"), 2},
+	} {
+		// the generic request folder takes the usages from the request
+		record := queueTestRequest(t, a, tc.csr, "request")
+		if got := a.RequiredApprovals(record); got != tc.required {
+			t.Errorf("%s: %d approvals required, want %d", tc.name, got, tc.required)
+		}
+		approved, err := a.ApproveRequest(record.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := map[int]string{1: model.RequestIssued, 2: model.RequestPending}[tc.required]; approved.Status != want {
+			t.Errorf("%s: request is %s after one approval, want %s", tc.name, approved.Status, want)
+		}
+	}
+}
+
+func TestRootIsNoOperator(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("the test runs as root only")
+	}
+	// the sudo variables are set by the caller, they do not name another operator
+	t.Setenv("SUDO_UID", "1001")
+	t.Setenv("SUDO_USER", "alice")
+	if operator, err := osOperator(); err == nil {
+		t.Errorf("root decides as %s (uid %s)", operator.Operator, operator.UID)
+	}
+}
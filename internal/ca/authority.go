@@ -0,0 +1,109 @@
+package ca
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/data"
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+	"golang.org/x/crypto/ssh"
+)
+
+// Options configure an Authority.
+type Options struct {
+	// Store holds the CA files and the work folders.
+	Store data.Store
+	// Passphrase decrypts the CA key in the store, it is not needed if Signer is set.
+	Passphrase []byte
+	// Signer signs certificates and CRLs in place of the key in the store, e.g. a key held by a HSM.
+	Signer crypto.Signer
+	// Clock returns the current time, time.Now if nil.
+	Clock func() time.Time
+	// Logger receives the messages of the CA, logger.Default if nil.
+	Logger logger.Logger
+}
+
+// Authority is a CA working on one store. It holds no global state, so several
+// authorities can be used in one process.
+type Authority struct {
+	store  data.Store
+	signer crypto.Signer
+	// sshSigner is the SSH CA key, decrypted on first use.
+	sshSigner ssh.Signer
+	// scep is the SCEP RA, loaded on first use.
+	scep scepRA
+	now  func() time.Time
+	log  logger.Logger
+	pass []byte
+	cfg  config
+}
+
+// New returns an Authority for the store in the options. The CA key is read when it is first needed.
+func New(opts Options) (*Authority, error) {
+	if opts.Store == nil {
+		return nil, errors.New("no store given")
+	}
+
+	a := &Authority{
+		store:  opts.Store,
+		signer: opts.Signer,
+		now:    opts.Clock,
+		log:    opts.Logger,
+		pass:   opts.Passphrase,
+	}
+	if a.now == nil {
+		a.now = time.Now
+	}
+	if a.log == nil {
+		a.log = logger.Default
+	}
+	return a, nil
+}
+
+// Store returns the store of the authority.
+func (a *Authority) Store() data.Store {
+	return a.store
+}
+
+// Config returns the configuration of the authority.
+func (a *Authority) Config() model.Config {
+	return a.getConfiguration()
+}
+
+// Certificate returns the CA certificate, nil if the CA has no certificate yet.
+func (a *Authority) Certificate() *x509.Certificate {
+	cert := a.getCaCertificate()
+	if len(cert.Raw) == 0 {
+		return nil
+	}
+	return &cert
+}
+
+// IsRoot reports if the CA certificate is self signed. A sub CA waiting for its certificate is no root.
+func (a *Authority) IsRoot() bool {
+	cert := a.getCaCertificate()
+	return len(cert.Raw) > 0 && isSelfSigned(cert)
+}
+
+// Now returns the time of the clock of the authority.
+func (a *Authority) Now() time.Time {
+	return a.now()
+}
+
+// CertificateChain returns the CA certificate followed by the certificates of its issuers.
+func (a *Authority) CertificateChain() []*x509.Certificate {
+	var chain []*x509.Certificate
+	for _, c := range a.getCaChain() {
+		chain = append(chain, &c)
+	}
+	return chain
+}
+
+// Refresh drops the cached configuration and CA certificate, so a long running service
+// sees the changes other processes made to the store. Call it after taking the store lock.
+func (a *Authority) Refresh() {
+	a.cfg = config{}
+}
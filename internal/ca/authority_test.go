@@ -0,0 +1,145 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"deleteonerror.com/tyinypki/internal/data"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// newTestAuthority sets up a root CA in the store.
+func newTestAuthority(t *testing.T, store data.Store) *Authority {
+	t.Helper()
+	store.SetupFolders()
+	a, err := New(Options{Store: store, Passphrase: []byte("passphrase123")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.SetupAuthority(model.Config{Name: "Test Root", BaseUrl: "http://pki.example.com", LastIssuedSerial: big.NewInt(0), LastCRLNumber: big.NewInt(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func testRequest(t *testing.T, cn string) *x509.CertificateRequest {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: []string{cn},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+// issueAndRevoke issues a certificate, revokes it and checks the CRL and the inventory.
+func issueAndRevoke(t *testing.T, a *Authority) *x509.Certificate {
+	t.Helper()
+	cert, err := a.Issue(testRequest(t, "host.example.com"), "webserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.VerifyIssued(cert); err != nil {
+		t.Fatalf("issued certificate does not verify: %v", err)
+	}
+	if err := a.Revoke(cert.SerialNumber, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	crl, err := a.LatestCRL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Errorf("CRL lists %d entries, want serial %s", len(crl.RevokedCertificateEntries), cert.SerialNumber)
+	}
+
+	certs, err := a.Certificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the store holds the self-signed CA certificate as the first issued certificate
+	if len(certs) != 2 || certs[1].Serial.Cmp(cert.SerialNumber) != 0 || certs[1].Status != model.CertificateRevoked {
+		t.Errorf("inventory = %+v, want the CA certificate and the revoked certificate", certs)
+	}
+	return cert
+}
+
+func TestAuthorityMemoryStore(t *testing.T) {
+	store := data.NewMemoryStore()
+	a := newTestAuthority(t, store)
+	if !a.IsRoot() || a.Certificate().Subject.CommonName != "Test Root" {
+		t.Fatalf("CA certificate = %s, want the root Test Root", a.Certificate().Subject)
+	}
+	issueAndRevoke(t, a)
+
+	// a second authority on the same store finds the CA
+	b, err := New(Options{Store: store, Passphrase: []byte("passphrase123")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.VerifyAuthority(); err != nil {
+		t.Fatal(err)
+	}
+	next, err := b.Issue(testRequest(t, "other.example.com"), "webserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.SerialNumber.Cmp(big.NewInt(3)) != 0 {
+		t.Errorf("serial = %s, want 3 after the CA certificate and the first certificate", next.SerialNumber)
+	}
+}
+
+func TestAuthorityDBStore(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ca.db")
+	store, err := data.OpenDBStore(path, filepath.Join(dir, "work"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := issueAndRevoke(t, newTestAuthority(t, store))
+
+	// the changes are in the file, a new store reads them
+	store, err = data.OpenDBStore(path, filepath.Join(dir, "work"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(Options{Store: store, Passphrase: []byte("passphrase123")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.VerifyAuthority(); err != nil {
+		t.Fatal(err)
+	}
+	issued, err := a.IssuedCertificate(cert.SerialNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !issued.Equal(cert) {
+		t.Error("reopened store returns another certificate")
+	}
+	crl, err := a.LatestCRL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crl.RevokedCertificateEntries) != 1 {
+		t.Errorf("CRL of the reopened store lists %d entries, want 1", len(crl.RevokedCertificateEntries))
+	}
+}
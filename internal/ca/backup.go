@@ -0,0 +1,293 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"path"
+	"strings"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/data"
+	"deleteonerror.com/tyinypki/internal/model"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// backupMagic starts every backup file, it is authenticated together with the salt.
+const backupMagic = "TPKIBAK1"
+
+const backupManifest = "manifest.json"
+
+type backupManifestFile struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type backupManifestData struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	CaName  string    `json:"ca_name"`
+	// The hex encoded SHA-256 fingerprint of the DER encoded CA certificate, empty for a sub CA without certificate.
+	Fingerprint      string               `json:"fingerprint"`
+	LastIssuedSerial *big.Int             `json:"last_issued_serial"`
+	Files            []backupManifestFile `json:"files"`
+}
+
+// Backup writes the whole store into one archive encrypted with the backup pass phrase.
+// If dest is empty the backup is placed in the backups folder.
+func (a *Authority) Backup(dest string, backupPass []byte) (string, error) {
+	files, err := a.store.GetStoreFiles()
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", errors.New("the store is empty")
+	}
+
+	conf := a.getConfiguration()
+	manifest := backupManifestData{
+		Version:          1,
+		Created:          a.now().UTC(),
+		CaName:           conf.Name,
+		LastIssuedSerial: conf.LastIssuedSerial,
+	}
+
+	if cert := a.findBackupCertificate(files); cert != nil {
+		sum := sha256.Sum256(cert.Raw)
+		manifest.Fingerprint = hex.EncodeToString(sum[:])
+	}
+
+	archive := []model.BundleFile{{Name: backupManifest}}
+	for _, f := range files {
+		sum := sha256.Sum256(f.Data)
+		manifest.Files = append(manifest.Files, backupManifestFile{
+			Name:   f.Name,
+			Size:   len(f.Data),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+		archive = append(archive, model.BundleFile{Name: path.Join("store", f.Name), Data: f.Data})
+	}
+
+	archive[0].Data, err = json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := data.EncodeArchive(archive)
+	if err != nil {
+		return "", err
+	}
+
+	encrypted, err := encryptBackup(raw, backupPass)
+	if err != nil {
+		return "", err
+	}
+
+	filename := strings.ReplaceAll(conf.Name, " ", "_") + "_" + manifest.Created.Format("2006-01-02_15-04-05") + ".tpbak"
+	out, err := a.store.WriteBackup(dest, filename, encrypted)
+	if err != nil {
+		return "", err
+	}
+
+	a.log.Info("Backup of %d files written to %s, ca fingerprint %s", len(files), out, manifest.Fingerprint)
+	return out, nil
+}
+
+// Restore verifies a backup and unpacks it into an empty store. Before anything is written
+// the hashes, the pairing of key and certificate and the serial counter are checked.
+// The key in the backup must open with the passphrase of the authority.
+func (a *Authority) Restore(src string, backupPass []byte) error {
+	empty, err := a.store.IsStoreEmpty()
+	if err != nil {
+		return err
+	}
+	if !empty {
+		return errors.New("the store is not empty, restore needs an empty store")
+	}
+
+	encrypted, err := data.ReadBackup(src)
+	if err != nil {
+		return err
+	}
+
+	raw, err := decryptBackup(encrypted, backupPass)
+	if err != nil {
+		return err
+	}
+
+	archive, err := data.DecodeArchive(raw)
+	if err != nil {
+		return err
+	}
+
+	var manifest backupManifestData
+	content := map[string][]byte{}
+	for _, f := range archive {
+		if f.Name == backupManifest {
+			if err := json.Unmarshal(f.Data, &manifest); err != nil {
+				return fmt.Errorf("invalid manifest: %w", err)
+			}
+			continue
+		}
+		content[strings.TrimPrefix(f.Name, "store/")] = f.Data
+	}
+	if manifest.Version != 1 {
+		return fmt.Errorf("unsupported backup version %d", manifest.Version)
+	}
+	if len(content) != len(manifest.Files) {
+		return errors.New("the backup content does not match the manifest")
+	}
+
+	var files []model.BundleFile
+	for _, f := range manifest.Files {
+		raw, ok := content[f.Name]
+		if !ok {
+			return fmt.Errorf("%s is missing in the backup", f.Name)
+		}
+		sum := sha256.Sum256(raw)
+		if len(raw) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return fmt.Errorf("hash mismatch for %s", f.Name)
+		}
+		files = append(files, model.BundleFile{Name: f.Name, Data: raw})
+	}
+
+	err = a.verifyBackupContent(manifest, content)
+	if err != nil {
+		return err
+	}
+
+	err = a.store.RestoreStoreFiles(files)
+	if err != nil {
+		return err
+	}
+	a.store.SetupFolders()
+
+	a.log.Info("Restored %d files of %s", len(files), manifest.CaName)
+	return nil
+}
+
+// verifyBackupContent checks that the key opens with the CA pass phrase and matches the certificate,
+// and that the serial counter is not behind any issued certificate.
+func (a *Authority) verifyBackupContent(manifest backupManifestData, content map[string][]byte) error {
+	nonce := content[a.store.StoreFileName("ca-key", "ca.key.nonce")]
+	encryptedKey := content[a.store.StoreFileName("ca-key", "ca.key")]
+	if len(nonce) == 0 || len(encryptedKey) == 0 {
+		return errors.New("the backup holds no ca key")
+	}
+
+	der, err := decryptPrivateKey(a.pass, nonce, encryptedKey)
+	if err != nil {
+		return errors.New("could not decrypt the ca key, wrong passphrase or corrupted backup")
+	}
+	key, err := x509.ParseECPrivateKey(der)
+	if err != nil {
+		return err
+	}
+
+	var files []model.BundleFile
+	for name, raw := range content {
+		files = append(files, model.BundleFile{Name: name, Data: raw})
+	}
+
+	cert := a.findBackupCertificate(files)
+	if cert != nil {
+		pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+		if !ok || !arePublicKeysEqual(pub, &key.PublicKey) {
+			return errors.New("the ca key does not match the ca certificate")
+		}
+		sum := sha256.Sum256(cert.Raw)
+		if hex.EncodeToString(sum[:]) != manifest.Fingerprint {
+			return errors.New("the ca certificate does not match the fingerprint of the manifest")
+		}
+	} else {
+		a.log.Warning("The backup holds no ca certificate, only the key was verified.")
+	}
+
+	var conf model.Config
+	if err := json.Unmarshal(content[a.store.StoreFileName("ca-cer", "config.json")], &conf); err != nil {
+		return fmt.Errorf("invalid ca configuration: %w", err)
+	}
+
+	issuedPrefix := a.store.StoreFileName("ca-issued", "")
+	for name, raw := range content {
+		if !strings.HasPrefix(name, issuedPrefix+"/") || strings.Contains(strings.TrimPrefix(name, issuedPrefix+"/"), "/") {
+			continue
+		}
+		issued, err := parseCertificate(raw)
+		if err != nil {
+			continue
+		}
+		if issued.SerialNumber.Cmp(conf.LastIssuedSerial) > 0 {
+			return fmt.Errorf("serial counter %s is lower than the issued serial %s in %s", conf.LastIssuedSerial, issued.SerialNumber, name)
+		}
+	}
+	return nil
+}
+
+func (a *Authority) findBackupCertificate(files []model.BundleFile) *x509.Certificate {
+	name := a.store.StoreFileName("ca-cer", "ca.cer")
+	for _, f := range files {
+		if f.Name != name {
+			continue
+		}
+		cert, err := x509.ParseCertificate(derBytes(f.Data))
+		if err != nil {
+			return nil
+		}
+		return cert
+	}
+	return nil
+}
+
+// encryptBackup encrypts with XChaCha20-Poly1305, the key is derived with Argon2id from the pass phrase.
+func encryptBackup(plain []byte, pass []byte) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(argon2.IDKey(pass, salt, 3, 64*1024, 4, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(backupMagic), salt...)
+	out := append(bytes.Clone(header), nonce...)
+	return aead.Seal(out, nonce, plain, header), nil
+}
+
+func decryptBackup(encrypted []byte, pass []byte) ([]byte, error) {
+	headerSize := len(backupMagic) + 16
+	if len(encrypted) < headerSize+chacha20poly1305.NonceSizeX || string(encrypted[:len(backupMagic)]) != backupMagic {
+		return nil, errors.New("not a tiny pki backup")
+	}
+
+	header := encrypted[:headerSize]
+	salt := header[len(backupMagic):]
+	nonce := encrypted[headerSize : headerSize+chacha20poly1305.NonceSizeX]
+
+	aead, err := chacha20poly1305.NewX(argon2.IDKey(pass, salt, 3, 64*1024, 4, chacha20poly1305.KeySize))
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := aead.Open(nil, nonce, encrypted[headerSize+chacha20poly1305.NonceSizeX:], header)
+	if err != nil {
+		return nil, errors.New("could not decrypt the backup, wrong passphrase or corrupted file")
+	}
+	return plain, nil
+}
@@ -0,0 +1,330 @@
+package ca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"deleteonerror.com/tyinypki/internal/data"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+const (
+	bundleManifest  = "manifest.json"
+	bundleSignature = "manifest.sig"
+	bundleProducer  = "producer.pem"
+)
+
+// RootBundleKinds are exported by the root: issued CA certificates and the files for publication.
+var RootBundleKinds = []string{"ca-cert", "publish"}
+
+// SubBundleKinds are exported by a sub CA: its CA requests.
+var SubBundleKinds = []string{"csr"}
+
+// ExportBundle writes a transfer bundle with the files of the given kinds, signed with the CA key.
+// If dest is empty the bundle is placed in the bundles folder.
+func (a *Authority) ExportBundle(kinds []string, dest string) (string, error) {
+	signer, err := a.signingKey()
+	if err != nil {
+		return "", err
+	}
+	pub, err := a.publicKey()
+	if err != nil {
+		return "", err
+	}
+	conf := a.getConfiguration()
+
+	manifest := model.BundleManifest{
+		Version:  1,
+		Producer: conf.Name,
+		Created:  a.now().UTC(),
+	}
+
+	var files []model.BundleFile
+	for _, kind := range kinds {
+		sources, err := a.store.GetBundleSources(kind)
+		if err != nil {
+			return "", err
+		}
+		for _, src := range sources {
+			name := path.Join(kind, src.Name)
+			sum := sha256.Sum256(src.Data)
+			manifest.Files = append(manifest.Files, model.BundleManifestFile{
+				Name:   name,
+				Kind:   kind,
+				Size:   len(src.Data),
+				SHA256: hex.EncodeToString(sum[:]),
+			})
+			files = append(files, model.BundleFile{Name: name, Data: src.Data})
+		}
+	}
+	if len(files) == 0 {
+		return "", errors.New("nothing to export")
+	}
+
+	producer, fingerprint, err := a.encodeProducer(pub)
+	if err != nil {
+		return "", err
+	}
+	manifest.Fingerprint = fingerprint
+
+	rawManifest, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	digest := sha512.Sum384(rawManifest)
+	signature, err := signer.Sign(rand.Reader, digest[:], crypto.SHA384)
+	if err != nil {
+		a.log.Error("%v", err)
+		return "", err
+	}
+
+	files = append([]model.BundleFile{
+		{Name: bundleManifest, Data: rawManifest},
+		{Name: bundleSignature, Data: signature},
+		{Name: bundleProducer, Data: producer},
+	}, files...)
+
+	filename := strings.ReplaceAll(conf.Name, " ", "_") + "_" + manifest.Created.Format("2006-01-02_15-04-05") + ".tpb"
+	out, err := a.store.WriteBundle(dest, filename, files)
+	if err != nil {
+		return "", err
+	}
+
+	a.log.Info("Bundle with %d files written to %s, producer fingerprint %s", len(manifest.Files), out, fingerprint)
+	return out, nil
+}
+
+// ImportBundle verifies the signature and the hashes of a transfer bundle and places its files
+// in the ca-req folder of a root or the ca-cert-in and ca-publish folders of a sub CA.
+// If fingerprint is set, the producer key must match it.
+func (a *Authority) ImportBundle(src string, fingerprint string) error {
+	if _, err := a.signingKey(); err != nil {
+		return err
+	}
+
+	files, err := data.ReadBundle(src)
+	if err != nil {
+		return err
+	}
+
+	content := map[string][]byte{}
+	for _, f := range files {
+		content[f.Name] = f.Data
+	}
+
+	rawManifest, ok := content[bundleManifest]
+	if !ok {
+		return errors.New("bundle has no manifest")
+	}
+	var manifest model.BundleManifest
+	if err := json.Unmarshal(rawManifest, &manifest); err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.Version != 1 {
+		return fmt.Errorf("unsupported bundle version %d", manifest.Version)
+	}
+
+	producerKey, producerChain, err := decodeProducer(content[bundleProducer])
+	if err != nil {
+		return err
+	}
+
+	digest := sha512.Sum384(rawManifest)
+	if !ecdsa.VerifyASN1(producerKey, digest[:], content[bundleSignature]) {
+		return errors.New("invalid bundle signature")
+	}
+
+	producerFingerprint, err := keyFingerprint(producerKey)
+	if err != nil {
+		return err
+	}
+	if producerFingerprint != manifest.Fingerprint {
+		return errors.New("producer key does not match the manifest")
+	}
+
+	for _, f := range manifest.Files {
+		raw, ok := content[f.Name]
+		if !ok {
+			return fmt.Errorf("%s is missing in the bundle", f.Name)
+		}
+		sum := sha256.Sum256(raw)
+		if len(raw) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return fmt.Errorf("hash mismatch for %s", f.Name)
+		}
+	}
+
+	err = a.trustProducer(manifest, producerKey, producerChain, fingerprint)
+	if err != nil {
+		return err
+	}
+	if err := a.checkBundleFiles(manifest.Files); err != nil {
+		return err
+	}
+
+	for _, f := range manifest.Files {
+		dest, err := a.store.ImportBundleFile(f.Kind, path.Base(f.Name), content[f.Name])
+		if err != nil {
+			return err
+		}
+		a.log.Info("Imported %s to %s", f.Name, dest)
+	}
+	return nil
+}
+
+// trustProducer decides if the verified producer of a bundle is trusted by this CA.
+// The producer is trusted if its key is pinned by fingerprint, if it belongs to the chain of this CA,
+// or if it is a sub CA this root issued and still lists in its registry. Anything a bundle says about
+// itself proves nothing, so the first contact needs the fingerprint compared out of band.
+func (a *Authority) trustProducer(manifest model.BundleManifest, producerKey *ecdsa.PublicKey, producerChain []x509.Certificate, fingerprint string) error {
+	if fingerprint != "" {
+		if !strings.EqualFold(strings.ReplaceAll(fingerprint, ":", ""), manifest.Fingerprint) {
+			return fmt.Errorf("producer fingerprint %s does not match the expected fingerprint", manifest.Fingerprint)
+		}
+		a.log.Info("Bundle producer %s matches the expected fingerprint", manifest.Producer)
+		return nil
+	}
+
+	chain := a.getCaChain()
+	for _, c := range chain {
+		pub, ok := c.PublicKey.(*ecdsa.PublicKey)
+		if ok && arePublicKeysEqual(pub, producerKey) {
+			a.log.Info("Bundle producer %s is part of the CA chain", manifest.Producer)
+			return nil
+		}
+	}
+
+	if len(chain) > 0 && len(producerChain) > 0 {
+		pub, ok := producerChain[0].PublicKey.(*ecdsa.PublicKey)
+		if ok && arePublicKeysEqual(pub, producerKey) {
+			roots := x509.NewCertPool()
+			intermediates := x509.NewCertPool()
+			for _, c := range chain {
+				if isSelfSigned(c) {
+					roots.AddCert(&c)
+				}
+			}
+			for _, c := range producerChain[1:] {
+				intermediates.AddCert(&c)
+			}
+			_, err := producerChain[0].Verify(x509.VerifyOptions{
+				Roots:         roots,
+				Intermediates: intermediates,
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+			})
+			if err == nil && a.isRegisteredSubAuthority(&producerChain[0]) {
+				a.log.Info("Bundle producer %s is a sub CA of this root", manifest.Producer)
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("bundle producer %s with fingerprint %s is not trusted, compare the fingerprint with the one printed by its export-bundle and pass it with -fingerprint", manifest.Producer, manifest.Fingerprint)
+}
+
+// checkBundleFiles checks that the files have a kind this CA imports and are named after it. A root takes
+// the requests of its sub CAs, a sub CA the certificates and publications of its root.
+func (a *Authority) checkBundleFiles(files []model.BundleManifestFile) error {
+	accepted := RootBundleKinds
+	if a.IsRoot() {
+		accepted = SubBundleKinds
+	}
+	for _, f := range files {
+		if !slices.Contains(accepted, f.Kind) {
+			return fmt.Errorf("%s of kind %s can not be imported by this CA", f.Name, f.Kind)
+		}
+		if f.Name != path.Join(f.Kind, path.Base(f.Name)) {
+			return fmt.Errorf("%s is not a file of kind %s", f.Name, f.Kind)
+		}
+	}
+	return nil
+}
+
+// isRegisteredSubAuthority reports if the certificate is a CA certificate this root issued and lists
+// in its registry as not revoked. Any certificate of the PKI chains to the root, end entities included.
+func (a *Authority) isRegisteredSubAuthority(cert *x509.Certificate) bool {
+	if !cert.IsCA || !cert.BasicConstraintsValid {
+		return false
+	}
+	subs, err := a.store.ReadSubCaRegistry()
+	if err != nil {
+		a.log.Error("%v", err)
+		return false
+	}
+	for _, sub := range subs {
+		if sub.Serial != nil && sub.Serial.Cmp(cert.SerialNumber) == 0 && !sub.Cross && !sub.IsRevoked() &&
+			sub.SubjectKeyId == hex.EncodeToString(cert.SubjectKeyId) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeProducer returns the PEM encoded public key followed by the CA chain and the key fingerprint.
+func (a *Authority) encodeProducer(pub *ecdsa.PublicKey) ([]byte, string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, "", err
+	}
+	fingerprint, err := keyFingerprint(pub)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	_ = pem.Encode(&buf, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	chain := a.getCaChain()
+	if len(chain) > 0 {
+		own, ok := chain[0].PublicKey.(*ecdsa.PublicKey)
+		if ok && arePublicKeysEqual(own, pub) {
+			buf.Write(encodeChain(chain, true))
+		}
+	}
+
+	return buf.Bytes(), fingerprint, nil
+}
+
+// keyFingerprint returns the hex encoded SHA-256 hash of the DER encoded public key.
+func keyFingerprint(pub *ecdsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func decodeProducer(raw []byte) (*ecdsa.PublicKey, []x509.Certificate, error) {
+	block, rest := pem.Decode(raw)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, nil, errors.New("bundle has no producer key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, nil, errors.New("unsupported producer key")
+	}
+
+	chain, err := parseCertificates(rest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, chain, nil
+}
@@ -0,0 +1,94 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/data"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// producerManifest returns the manifest of a bundle signed by the key.
+func producerManifest(t *testing.T, key *ecdsa.PrivateKey) model.BundleManifest {
+	t.Helper()
+	fingerprint, err := keyFingerprint(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model.BundleManifest{Version: 1, Producer: "producer", Fingerprint: fingerprint}
+}
+
+func TestTrustProducer(t *testing.T) {
+	a := newTestAuthority(t, data.NewMemoryStore())
+	root := a.getCaCertificate()
+	signer, err := a.signingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := producerManifest(t, key)
+
+	// an end entity certificate of the PKI chains to the root as well
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "alice"}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, _ := x509.ParseCertificateRequest(der)
+	client, err := a.Issue(csr, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.trustProducer(manifest, &key.PublicKey, []x509.Certificate{*client, root}, ""); err == nil {
+		t.Error("the holder of a client certificate is trusted as bundle producer")
+	}
+
+	der, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:          big.NewInt(100),
+		Subject:               pkix.Name{CommonName: "Test Sub"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SubjectKeyId:          []byte{1, 2, 3},
+	}, &root, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, _ := x509.ParseCertificate(der)
+	if err := a.trustProducer(manifest, &key.PublicKey, []x509.Certificate{*sub, root}, ""); err == nil {
+		t.Error("a CA certificate missing in the registry is trusted as bundle producer")
+	}
+	if err := a.registerSubAuthority(sub, "Test Sub.cer", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.trustProducer(manifest, &key.PublicKey, []x509.Certificate{*sub, root}, ""); err != nil {
+		t.Errorf("registered sub CA is not trusted: %v", err)
+	}
+}
+
+func TestCheckBundleFiles(t *testing.T) {
+	a := newTestAuthority(t, data.NewMemoryStore())
+	if err := a.checkBundleFiles([]model.BundleManifestFile{{Name: "csr/Test Sub.csr", Kind: "csr"}}); err != nil {
+		t.Errorf("root refuses a CA request: %v", err)
+	}
+	for _, f := range []model.BundleManifestFile{
+		{Name: "publish/Test Root.crl", Kind: "publish"},
+		{Name: "ca-cert/Test Root.cer", Kind: "ca-cert"},
+		{Name: "publish/Test Root.crl", Kind: "csr"},
+		{Name: "csr/../publish/Test Root.crl", Kind: "csr"},
+	} {
+		if err := a.checkBundleFiles([]model.BundleManifestFile{f}); err == nil {
+			t.Errorf("root imports %s of kind %s", f.Name, f.Kind)
+		}
+	}
+}
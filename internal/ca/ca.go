@@ -6,145 +6,142 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/hex"
+	"errors"
 	"math/big"
-	"net/url"
-	"os"
-	"time"
 
-	"deleteonerror.com/tyinypki/internal/data"
-	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/lint"
 	"deleteonerror.com/tyinypki/internal/model"
 )
 
-var PassPhrase []byte
-
-func VerifyAuthority(pass []byte) {
-	PassPhrase = pass
-
-	getPrivateKey()
-	canf := getConfiguration()
-	logger.Debug("%v\n", canf)
+// VerifyAuthority opens the root CA, finishes an interrupted operation, imports new revocations
+// and renews the CRL if it expires soon.
+func (a *Authority) VerifyAuthority() error {
+	if _, err := a.signingKey(); err != nil {
+		return err
+	}
+	canf := a.getConfiguration()
+	a.log.Debug("%v\n", canf)
 
-	cert := getCaCertificate()
+	cert := a.getCaCertificate()
 	if len(cert.Raw) == 0 {
-		os.Exit(1)
+		return errors.New("no ca certificate found")
 	}
 
-	if cert.NotAfter.Before(time.Now().AddDate(0, 0, 90)) {
-		logger.Warning("Root cert will expire in less than 90 days.")
-	} else {
-		logger.Info("Root certificate is valid.")
+	if err := a.recoverJournal(); err != nil {
+		a.log.Error("%v", err)
+		return err
 	}
 
-	RevokeCertificates()
-
-	crl, err := getLatestCRL()
-	if err != nil {
-		logger.Error("Unable not read last CRL: %v", err)
-	}
-	if crl == nil {
-		logger.Info("No published crls found.")
-		return
+	if cert.NotAfter.Before(a.now().AddDate(0, 0, 90)) {
+		a.log.Warning("Root cert will expire in less than 90 days.")
+	} else {
+		a.log.Info("Root certificate is valid.")
 	}
 
-	if crl.NextUpdate.Before(time.Now().AddDate(0, 0, 30)) {
-		logger.Warning("CRL will expire in less than 30 days")
+	a.checkSubAuthorities(90)
 
-		err = PublishRevocationList()
-		if err != nil {
-			logger.Error("%v", err)
-		}
-	} else {
-		logger.Info("last published crl is valid.")
+	a.RevokeCertificates()
+
+	published, err := a.RepublishCRLIfDue()
+	if err != nil {
+		a.log.Error("%v", err)
+	} else if !published {
+		a.log.Info("last published crl is valid.")
 	}
 
+	return nil
 }
 
-func SetupAuthority(initConfig model.Config, pass []byte) error {
-	PassPhrase = pass
-
-	updateConfiguration(initConfig)
+// SetupAuthority creates the root CA with the given configuration and publishes its certificate and first CRL.
+func (a *Authority) SetupAuthority(initConfig model.Config) error {
+	a.updateConfiguration(initConfig)
 
-	privateKey, err := createEncryptedPrivateKey(pass)
+	signer, err := a.setupKey()
 	if err != nil {
-		logger.Error("%v", err)
 		return err
 	}
 
-	publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	publicKey, err := x509.MarshalPKIXPublicKey(signer.Public())
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return err
 	}
 	ski := sha256.Sum256(publicKey)
 
-	srl := cfg.Config.LastIssuedSerial
-	srl.Add(srl, big.NewInt(1))
-
-	cdp, err := url.JoinPath(cfg.Config.BaseUrl, url.PathEscape(cfg.Config.Name+".crl"))
+	srl := new(big.Int).Add(a.cfg.Config.LastIssuedSerial, big.NewInt(1))
+	err = a.updateLastSerial(srl)
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return err
 	}
 
-	aia, err := url.JoinPath(cfg.Config.BaseUrl, url.PathEscape(cfg.Config.Name+".cer"))
+	urls, err := a.certificateURLs(ski[:])
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return err
 	}
 
 	caCert := &x509.Certificate{
 		SerialNumber: srl,
 		Subject: pkix.Name{
-			Organization:       []string{cfg.Config.Organization},
-			OrganizationalUnit: []string{cfg.Config.OrganizationalUnit},
-			Country:            []string{cfg.Config.Country},
-			CommonName:         cfg.Config.Name,
+			Organization:       []string{a.cfg.Config.Organization},
+			OrganizationalUnit: []string{a.cfg.Config.OrganizationalUnit},
+			Country:            []string{a.cfg.Config.Country},
+			CommonName:         a.cfg.Config.Name,
 		},
 		Issuer: pkix.Name{
-			Organization:       []string{cfg.Config.Organization},
-			OrganizationalUnit: []string{cfg.Config.OrganizationalUnit},
-			Country:            []string{cfg.Config.Country},
-			CommonName:         cfg.Config.Name,
+			Organization:       []string{a.cfg.Config.Organization},
+			OrganizationalUnit: []string{a.cfg.Config.OrganizationalUnit},
+			Country:            []string{a.cfg.Config.Country},
+			CommonName:         a.cfg.Config.Name,
 		},
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(10, 0, 0),
+		NotBefore:             a.now(),
+		NotAfter:              a.now().AddDate(10, 0, 0),
 		IsCA:                  true,
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
 		AuthorityKeyId:        ski[:],
 		SubjectKeyId:          ski[:],
-		IssuingCertificateURL: []string{aia},
-		CRLDistributionPoints: []string{cdp},
+		IssuingCertificateURL: urls.aia,
+		CRLDistributionPoints: urls.cdp,
+	}
+
+	if a.cfg.Config.PathLen != nil && *a.cfg.Config.PathLen >= 0 {
+		caCert.MaxPathLen = *a.cfg.Config.PathLen
+		caCert.MaxPathLenZero = *a.cfg.Config.PathLen == 0
 	}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, caCert, caCert, &privateKey.PublicKey, privateKey) // &key
+	if err := a.checkLint(caCert.Subject.CommonName, lint.Certificate(caCert, caCert, signer.Public())); err != nil {
+		return err
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, caCert, caCert, signer.Public(), signer)
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return err
 	}
 
 	caCert, _ = x509.ParseCertificate(certBytes)
 
-	_, err = data.WriteRawIssuedCertificate(certBytes, caCert.Subject.CommonName+"_"+hex.EncodeToString(caCert.SubjectKeyId))
+	_, err = a.store.WriteRawIssuedCertificate(certBytes, caCert.Subject.CommonName+"_"+hex.EncodeToString(caCert.SubjectKeyId))
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return err
 	}
 
-	file, err := data.WriteRawCaCertificate(certBytes)
+	_, err = a.store.WriteRawCaCertificate(certBytes)
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return err
 	}
-	data.Publish(file, cfg.Config.Name+".cer")
-	err = PublishRevocationList()
+	if err := a.publishCaCertificate(caCert); err != nil {
+		a.log.Error("%v", err)
+	}
+	err = a.publishRevocationList()
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return err
 	}
 
-	updateLastSerial(srl)
-
 	return nil
 }
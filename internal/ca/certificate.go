@@ -4,35 +4,31 @@ import (
 	"crypto/x509"
 	"encoding/pem"
 
-	"deleteonerror.com/tyinypki/internal/data"
 	"deleteonerror.com/tyinypki/internal/logger"
 )
 
-func getCaCertificate() x509.Certificate {
-	if len(cfg.Certificate.Raw) == 0 {
-		derCert, err := data.ReadCaCertificate()
+func (a *Authority) getCaCertificate() x509.Certificate {
+	if len(a.cfg.Certificate.Raw) == 0 {
+		derCert, err := a.store.ReadCaCertificate()
 		if err != nil {
-			logger.Debug("Cold not read Certificate file: %v", err)
+			a.log.Debug("Cold not read Certificate file: %v", err)
 			return x509.Certificate{}
 		}
-		block, _ := pem.Decode(derCert)
-
-		pCaCert, err := x509.ParseCertificate(block.Bytes)
+		pCaCert, err := x509.ParseCertificate(derBytes(derCert))
 		if err != nil {
-			logger.Error("Cold not pars Certificate file: %v", err)
+			a.log.Error("Cold not pars Certificate file: %v", err)
+			return x509.Certificate{}
 		}
-		logger.Debug("Certificate loaded.")
-		cfg.Certificate = *pCaCert
+		a.log.Debug("Certificate loaded.")
+		a.cfg.Certificate = *pCaCert
 
 	}
-	return cfg.Certificate
+	return a.cfg.Certificate
 }
 
 func parseCertificate(raw []byte) (x509.Certificate, error) {
 
-	block, _ := pem.Decode(raw)
-
-	cert, err := x509.ParseCertificate(block.Bytes)
+	cert, err := x509.ParseCertificate(derBytes(raw))
 	if err != nil {
 		logger.Error("%v", err)
 		return x509.Certificate{}, err
@@ -40,3 +36,11 @@ func parseCertificate(raw []byte) (x509.Certificate, error) {
 	return *cert, nil
 
 }
+
+// derBytes returns the content of the first PEM block in raw, raw without PEM block is taken as DER.
+func derBytes(raw []byte) []byte {
+	if block, _ := pem.Decode(raw); block != nil {
+		return block.Bytes
+	}
+	return raw
+}
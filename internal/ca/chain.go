@@ -0,0 +1,162 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// getCaChain returns the certificate of this CA followed by the certificates of its issuers.
+// The root CA has no issuers, so its chain is the root certificate only.
+func (a *Authority) getCaChain() []x509.Certificate {
+	cert := a.getCaCertificate()
+	if len(cert.Raw) == 0 {
+		return nil
+	}
+
+	chain := []x509.Certificate{cert}
+
+	raw, err := a.store.ReadCaChain()
+	if err != nil {
+		a.log.Error("Could not read CA chain: %v", err)
+		return chain
+	}
+
+	issuers, err := parseCertificates(raw)
+	if err != nil {
+		a.log.Error("Could not parse CA chain: %v", err)
+		return chain
+	}
+
+	return append(chain, issuers...)
+}
+
+// encodeChain PEM encodes the given certificates in order, self signed certificates are skipped if withRoot is false.
+func encodeChain(certs []x509.Certificate, withRoot bool) []byte {
+	var buf bytes.Buffer
+	for _, c := range certs {
+		if !withRoot && isSelfSigned(c) {
+			continue
+		}
+		_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	return buf.Bytes()
+}
+
+// writeIssuedChain places the issued certificate together with the CA chain (without the root) next to the issued certificate.
+func (a *Authority) writeIssuedChain(certBytes []byte, filename string) {
+	leaf, err := x509.ParseCertificate(certBytes)
+	if err != nil {
+		a.log.Error("%v", err)
+		return
+	}
+
+	_, err = a.store.WriteIssuedChain(a.IssuedChain(leaf), filename)
+	if err != nil {
+		a.log.Error("%v", err)
+	}
+}
+
+// IssuedChain returns the PEM encoded certificate followed by the CA chain without the root,
+// like the chain files placed in the issued folder.
+func (a *Authority) IssuedChain(cert *x509.Certificate) []byte {
+	return encodeChain(append([]x509.Certificate{*cert}, a.getCaChain()...), false)
+}
+
+// verifyCaChain checks that the CA certificate chains up to the self signed certificate in the stored CA chain.
+func (a *Authority) verifyCaChain() error {
+	chain := a.getCaChain()
+	if len(chain) == 0 {
+		return errors.New("no ca certificate found")
+	}
+	if len(chain) == 1 {
+		if isSelfSigned(chain[0]) {
+			return nil
+		}
+		return errors.New("the issuer chain of the ca certificate is unknown")
+	}
+
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		if isSelfSigned(c) {
+			roots.AddCert(&c)
+		} else {
+			intermediates.AddCert(&c)
+		}
+	}
+
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("ca chain verification failed: %w", err)
+	}
+	return nil
+}
+
+// allowedPathLen returns the largest path length an issued CA certificate may have.
+// The second return value is false if the CA is not allowed to issue CA certificates at all.
+func allowedPathLen(issuer x509.Certificate) (int, bool) {
+	if !issuer.IsCA || !issuer.BasicConstraintsValid {
+		return 0, false
+	}
+	if issuer.MaxPathLen < 0 || (issuer.MaxPathLen == 0 && !issuer.MaxPathLenZero) {
+		return -1, true
+	}
+	if issuer.MaxPathLen == 0 {
+		return 0, false
+	}
+	return issuer.MaxPathLen - 1, true
+}
+
+// CanIssueCaCertificates reports if the path length of this CA leaves room for subordinate CAs.
+func (a *Authority) CanIssueCaCertificates() bool {
+	cert := a.getCaCertificate()
+	if len(cert.Raw) == 0 {
+		return false
+	}
+	_, ok := allowedPathLen(cert)
+	return ok
+}
+
+func isSelfSigned(cert x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(&cert) == nil
+}
+
+// parseCertificates parses all PEM encoded certificates in raw, raw without PEM block holds DER encoded certificates.
+func parseCertificates(raw []byte) ([]x509.Certificate, error) {
+	var result []x509.Certificate
+
+	if len(raw) > 0 && !bytes.Contains(raw, []byte("-----BEGIN")) {
+		certs, err := x509.ParseCertificates(raw)
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range certs {
+			result = append(result, *cert)
+		}
+		return result, nil
+	}
+
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *cert)
+	}
+	return result, nil
+}
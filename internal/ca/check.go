@@ -0,0 +1,247 @@
+package ca
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// CheckStore verifies that the store is coherent. With repair set, safe fixes are applied:
+// counters are raised to the highest issued values, folder permissions are reset and a stale CRL is republished.
+func (a *Authority) CheckStore(repair bool) []model.CheckFinding {
+	var findings []model.CheckFinding
+	add := func(check string, status string, format string, args ...any) *model.CheckFinding {
+		findings = append(findings, model.CheckFinding{Check: check, Status: status, Message: fmt.Sprintf(format, args...)})
+		return &findings[len(findings)-1]
+	}
+
+	conf, err := a.store.ReadCaConfiguration()
+	if err != nil {
+		add("config", model.CheckError, "could not read the ca configuration: %v", err)
+		return findings
+	}
+	a.cfg.Config = conf
+	add("config", model.CheckOk, "configuration of %s loaded", conf.Name)
+
+	cert := a.getCaCertificate()
+	if len(cert.Raw) == 0 {
+		add("certificate", model.CheckError, "no ca certificate found")
+	}
+
+	key, keyErr := a.publicKey()
+	switch {
+	case keyErr != nil:
+		add("key", model.CheckError, "could not open the ca key: %v", keyErr)
+	case len(cert.Raw) == 0:
+		add("key", model.CheckWarning, "ca key opened, no certificate to compare with")
+	default:
+		pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+		if ok && arePublicKeysEqual(pub, key) {
+			add("key", model.CheckOk, "ca key matches ca.cer")
+		} else {
+			add("key", model.CheckError, "ca key does not match ca.cer")
+		}
+	}
+
+	a.checkJournal(repair && keyErr == nil && len(cert.Raw) > 0, add)
+
+	maxSerial := a.checkIssued(cert, add)
+	a.checkRevoked(cert, add)
+
+	if maxSerial != nil {
+		if conf.LastIssuedSerial.Cmp(maxSerial) < 0 {
+			f := add("serial", model.CheckError, "last issued serial %s is lower than the highest issued serial %s", conf.LastIssuedSerial, maxSerial)
+			if repair && a.updateLastSerial(new(big.Int).Set(maxSerial)) == nil {
+				f.Repaired = true
+			}
+		} else {
+			add("serial", model.CheckOk, "last issued serial %s", conf.LastIssuedSerial)
+		}
+	}
+
+	a.checkCRLs(cert, repair, keyErr == nil, add)
+	a.checkFolders(repair, add)
+
+	return findings
+}
+
+type addFinding func(check string, status string, format string, a ...any) *model.CheckFinding
+
+// checkJournal reports an operation left by an interrupted run, repair finishes or rolls it back.
+func (a *Authority) checkJournal(repair bool, add addFinding) {
+	entry, err := a.store.ReadJournal()
+	if err != nil {
+		add("journal", model.CheckError, "could not read the journal: %v", err)
+		return
+	}
+	if entry == nil {
+		add("journal", model.CheckOk, "no interrupted operation")
+		return
+	}
+
+	f := add("journal", model.CheckError, "interrupted %s with number %v started at %s", entry.Operation, entry.Number, entry.Started.Format(time.RFC3339))
+	if repair && a.recoverJournal() == nil {
+		f.Repaired = true
+	}
+}
+
+// checkIssued verifies that every issued certificate parses, is signed by the CA and has a unique serial.
+// It returns the highest issued serial.
+func (a *Authority) checkIssued(ca x509.Certificate, add addFinding) *big.Int {
+	files, err := a.store.GetIssuedCertificatesFromCaStore()
+	if err != nil {
+		add("issued", model.CheckError, "could not read issued certificates: %v", err)
+		return nil
+	}
+
+	serials := map[string]string{}
+	maxSerial := big.NewInt(0)
+	problems := 0
+	for _, f := range files {
+		cert, err := parseCertificate(f.Data)
+		if err != nil {
+			add("issued", model.CheckError, "%s does not parse: %v", f.Name, err)
+			problems++
+			continue
+		}
+		if len(ca.Raw) > 0 && cert.CheckSignatureFrom(&ca) != nil && !cert.Equal(&ca) {
+			add("issued", model.CheckError, "%s is not signed by this ca", f.Name)
+			problems++
+		}
+		if other, ok := serials[cert.SerialNumber.String()]; ok {
+			add("serial", model.CheckError, "serial %s is used by %s and %s", cert.SerialNumber, other, f.Name)
+			problems++
+		}
+		serials[cert.SerialNumber.String()] = f.Name
+		if cert.SerialNumber.Cmp(maxSerial) > 0 {
+			maxSerial = cert.SerialNumber
+		}
+	}
+	if problems == 0 {
+		add("issued", model.CheckOk, "%d issued certificates verified", len(files))
+	}
+	return maxSerial
+}
+
+// checkRevoked verifies that every revoked certificate parses and is signed by the CA.
+func (a *Authority) checkRevoked(ca x509.Certificate, add addFinding) {
+	files, err := a.store.GetRevokedCertificatesFromCaStore()
+	if err != nil {
+		add("revoked", model.CheckError, "could not read revoked certificates: %v", err)
+		return
+	}
+
+	problems := 0
+	for _, f := range files {
+		cert, err := parseCertificate(f.Data)
+		if err != nil {
+			add("revoked", model.CheckError, "%s does not parse: %v", f.Name, err)
+			problems++
+			continue
+		}
+		if len(ca.Raw) > 0 && cert.CheckSignatureFrom(&ca) != nil {
+			add("revoked", model.CheckError, "%s is not signed by this ca", f.Name)
+			problems++
+		}
+	}
+	if problems == 0 {
+		add("revoked", model.CheckOk, "%d revoked certificates verified", len(files))
+	}
+}
+
+// checkCRLs compares the CRL number counter with the CRL files and checks the freshness of the latest CRL.
+func (a *Authority) checkCRLs(ca x509.Certificate, repair bool, canSign bool, add addFinding) {
+	republish := repair && canSign && len(ca.Raw) > 0
+	files, err := a.store.GetCRLs()
+	if err != nil {
+		add("crl", model.CheckError, "could not read crls: %v", err)
+		return
+	}
+
+	maxNumber := big.NewInt(0)
+	var latest *x509.RevocationList
+	for _, f := range files {
+		number, ok := new(big.Int).SetString(strings.TrimSuffix(f.Name, ".crl"), 10)
+		if !ok {
+			add("crl", model.CheckWarning, "%s is not named after its crl number", f.Name)
+			continue
+		}
+
+		crl, err := x509.ParseRevocationList(derBytes(f.Data))
+		if err != nil {
+			add("crl", model.CheckError, "%s does not parse: %v", f.Name, err)
+			continue
+		}
+		if crl.Number == nil || crl.Number.Cmp(number) != 0 {
+			add("crl", model.CheckError, "%s holds crl number %v", f.Name, crl.Number)
+		}
+		if len(ca.Raw) > 0 && crl.CheckSignatureFrom(&ca) != nil {
+			add("crl", model.CheckError, "%s is not signed by this ca", f.Name)
+		}
+
+		if number.Cmp(maxNumber) >= 0 {
+			maxNumber = number
+			latest = crl
+		}
+	}
+
+	if a.cfg.Config.LastCRLNumber.Cmp(maxNumber) < 0 {
+		f := add("crl-number", model.CheckError, "last crl number %s is lower than the highest crl %s", a.cfg.Config.LastCRLNumber, maxNumber)
+		if repair && a.updateLastCrl(new(big.Int).Set(maxNumber)) == nil {
+			f.Repaired = true
+		}
+	} else if a.cfg.Config.LastCRLNumber.Cmp(maxNumber) > 0 {
+		add("crl-number", model.CheckWarning, "last crl number %s has no crl file, the highest crl is %s", a.cfg.Config.LastCRLNumber, maxNumber)
+	} else {
+		add("crl-number", model.CheckOk, "last crl number %s", a.cfg.Config.LastCRLNumber)
+	}
+
+	switch {
+	case latest == nil:
+		f := add("crl-fresh", model.CheckError, "no crl published")
+		if republish && a.publishRevocationList() == nil {
+			f.Repaired = true
+		}
+	case latest.NextUpdate.Before(a.now()):
+		f := add("crl-fresh", model.CheckError, "latest crl %s expired at %s", latest.Number, latest.NextUpdate.Format(time.RFC3339))
+		if republish && a.publishRevocationList() == nil {
+			f.Repaired = true
+		}
+	case latest.NextUpdate.Before(a.now().AddDate(0, 0, 30)):
+		f := add("crl-fresh", model.CheckWarning, "latest crl %s expires at %s", latest.Number, latest.NextUpdate.Format(time.RFC3339))
+		if republish && a.publishRevocationList() == nil {
+			f.Repaired = true
+		}
+	default:
+		add("crl-fresh", model.CheckOk, "latest crl %s is valid until %s", latest.Number, latest.NextUpdate.Format(time.RFC3339))
+	}
+}
+
+// checkFolders compares the folder permissions with the permissions the folders are created with.
+func (a *Authority) checkFolders(repair bool, add addFinding) {
+	problems := 0
+	for _, f := range a.store.GetFolderPermissions() {
+		if f.Exists && f.Actual == f.Expected {
+			continue
+		}
+		problems++
+
+		var finding *model.CheckFinding
+		if !f.Exists {
+			finding = add("folders", model.CheckWarning, "%s is missing", f.Path)
+		} else {
+			finding = add("folders", model.CheckWarning, "%s has permissions %o, expected %o", f.Path, f.Actual, f.Expected)
+		}
+		if repair && a.store.RepairFolder(f.Name) == nil {
+			finding.Repaired = true
+		}
+	}
+	if problems == 0 {
+		add("folders", model.CheckOk, "folder permissions match")
+	}
+}
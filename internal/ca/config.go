@@ -1,51 +1,45 @@
 package ca
 
 import (
-	"crypto/ecdsa"
 	"crypto/x509"
 	"math/big"
 
-	"deleteonerror.com/tyinypki/internal/data"
-	"deleteonerror.com/tyinypki/internal/logger"
 	"deleteonerror.com/tyinypki/internal/model"
 )
 
 type config struct {
 	Config      model.Config
-	PrivateKey  ecdsa.PrivateKey
 	Certificate x509.Certificate
 }
 
-var cfg config
+func (a *Authority) getConfiguration() model.Config {
 
-func getConfiguration() model.Config {
-
-	if len(cfg.Config.Name) == 0 {
-		conf, err := data.ReadCaConfiguration()
+	if len(a.cfg.Config.Name) == 0 {
+		conf, err := a.store.ReadCaConfiguration()
 		if err != nil {
-			logger.Error("Unable to read CA configuration file: %v", err)
+			a.log.Error("Unable to read CA configuration file: %v", err)
 		}
-		cfg.Config = conf
+		a.cfg.Config = conf
 	}
 
-	return cfg.Config
+	return a.cfg.Config
 }
 
-func updateLastSerial(serial *big.Int) error {
-	cfg.Config.LastIssuedSerial = serial
-	logger.Debug("configuration Changed new LastIssuedSerial %d", serial)
-	return data.WriteCaConfiguration(cfg.Config)
+func (a *Authority) updateLastSerial(serial *big.Int) error {
+	a.cfg.Config.LastIssuedSerial = serial
+	a.log.Debug("configuration Changed new LastIssuedSerial %d", serial)
+	return a.store.WriteCaConfiguration(a.cfg.Config)
 }
 
-func updateLastCrl(crl *big.Int) error {
-	cfg.Config.LastCRLNumber = crl
-	logger.Debug("configuration Changed new LastCRLNumber %d", crl)
-	return data.WriteCaConfiguration(cfg.Config)
+func (a *Authority) updateLastCrl(crl *big.Int) error {
+	a.cfg.Config.LastCRLNumber = crl
+	a.log.Debug("configuration Changed new LastCRLNumber %d", crl)
+	return a.store.WriteCaConfiguration(a.cfg.Config)
 }
 
-func updateConfiguration(conf model.Config) error {
-	logger.Debug("configuration updated")
-	cfg.Config = conf
-	return data.WriteCaConfiguration(conf)
+func (a *Authority) updateConfiguration(conf model.Config) error {
+	a.log.Debug("configuration updated")
+	a.cfg.Config = conf
+	return a.store.WriteCaConfiguration(conf)
 
 }
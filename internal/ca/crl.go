@@ -3,85 +3,150 @@ package ca
 import (
 	"crypto/rand"
 	"crypto/x509"
-	"crypto/x509/pkix"
 	"encoding/pem"
-	"math/big"
-	"time"
+	"errors"
+	"io/fs"
 
-	"deleteonerror.com/tyinypki/internal/data"
-	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/lint"
 	"deleteonerror.com/tyinypki/internal/model"
 )
 
-func PublishRevocationList() error {
+// GenerateCRL signs a new CRL with all revoked certificates, keeps it in the store and publishes it.
+func (a *Authority) GenerateCRL() (*x509.RevocationList, error) {
+	a.getConfiguration()
+	cert := a.getCaCertificate()
+	if len(cert.Raw) == 0 {
+		return nil, errors.New("no ca certificate found")
+	}
+
+	file, crl, err := a.generateCRL()
+	if err != nil {
+		a.log.Error("%v", err)
+		return nil, err
+	}
+
+	err = a.publishCRL(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.publishMissingCaCertificate(&cert); err != nil {
+		a.log.Error("%v", err)
+	}
+	return crl, a.finishOperation()
+}
 
-	file, err := generateCRL()
+// publishCRL publishes the CRL file of the store under the file names of the CDP URLs.
+func (a *Authority) publishCRL(file string) error {
+	names, err := a.crlPublishNames()
 	if err != nil {
-		logger.Error("%v", err)
 		return err
 	}
-	if file == "" {
-		return nil
+	for _, name := range names {
+		if err := a.store.Publish(file, name); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	cert := getCaCertificate()
+// publishCaCertificate publishes the certificate of the CA under the file names of the AIA URLs.
+func (a *Authority) publishCaCertificate(cert *x509.Certificate) error {
+	names, err := a.caCertificatePublishNames(cert.SubjectKeyId)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := a.store.PublishRawCertificate(cert.Raw, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	data.Publish(file, cert.Subject.CommonName+".crl")
+// publishMissingCaCertificate publishes the certificate of the CA if it is missing under a file name of
+// the AIA URLs, e.g. after the AIA URLs of the configuration changed.
+func (a *Authority) publishMissingCaCertificate(cert *x509.Certificate) error {
+	names, err := a.caCertificatePublishNames(cert.SubjectKeyId)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, _, err := a.store.ReadPublished(name); errors.Is(err, fs.ErrNotExist) {
+			return a.publishCaCertificate(cert)
+		}
+	}
 	return nil
 }
 
-func getLatestCRL() (*x509.RevocationList, error) {
-	data, err := data.GetLatestCRL()
+func (a *Authority) publishRevocationList() error {
+	_, err := a.GenerateCRL()
+	return err
+}
+
+// LatestCRL returns the last CRL signed by the CA, nil if no CRL was published yet.
+func (a *Authority) LatestCRL() (*x509.RevocationList, error) {
+	return a.getLatestCRL()
+}
+
+func (a *Authority) getLatestCRL() (*x509.RevocationList, error) {
+	data, err := a.store.GetLatestCRL()
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return nil, err
 	}
 	if len(data) == 0 {
 		return nil, nil
 	}
 
-	block, _ := pem.Decode(data)
-	crl, err := x509.ParseRevocationList(block.Bytes)
+	crl, err := x509.ParseRevocationList(derBytes(data))
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return nil, err
 	}
 	return crl, nil
 }
 
-func generateCRL() (string, error) {
+func (a *Authority) generateCRL() (string, *x509.RevocationList, error) {
 
-	rawCerts, err := data.GetRevokedCertificatesFromCaStore()
+	rawCerts, err := a.store.GetRevokedCertificatesFromCaStore()
 	if err != nil {
-		logger.Error("%v", err)
-		return "", err
+		a.log.Error("%v", err)
+		return "", nil, err
 	}
 
-	revokedCertificates, err := convertCertificatesToCRL(rawCerts)
+	revokedCertificates, err := a.convertCertificatesToCRL(rawCerts)
 	if err != nil {
-		logger.Error("%v", err)
-		return "", err
+		a.log.Error("%v", err)
+		return "", nil, err
 	}
 
-	nextId := cfg.Config.LastCRLNumber
-	nextId.Add(nextId, big.NewInt(1))
+	nextId, err := a.reserveCrlNumber()
+	if err != nil {
+		return "", nil, err
+	}
 
-	cert := getCaCertificate()
+	cert := a.getCaCertificate()
 
 	crlTemplate := &x509.RevocationList{
-		Number:              nextId,
-		ThisUpdate:          time.Now(),
-		NextUpdate:          time.Now().Add(120 * 24 * time.Hour),
-		RevokedCertificates: revokedCertificates,
-		Issuer:              cert.Issuer,
-		AuthorityKeyId:      cert.SubjectKeyId,
+		Number:                    nextId,
+		ThisUpdate:                a.now(),
+		NextUpdate:                a.now().Add(crlValidity),
+		RevokedCertificateEntries: revokedCertificates,
+		Issuer:                    cert.Issuer,
+		AuthorityKeyId:            cert.SubjectKeyId,
 	}
 
-	privkey := getPrivateKey()
-	crlBytes, err := x509.CreateRevocationList(rand.Reader, crlTemplate, &cert, &privkey)
+	signer, err := a.signingKey()
 	if err != nil {
-		logger.Error("%v", err)
-		return "", err
+		return "", nil, err
+	}
+	if err := a.checkLint("CRL "+nextId.String(), lint.CRL(crlTemplate, &cert)); err != nil {
+		return "", nil, err
+	}
+	crlBytes, err := x509.CreateRevocationList(rand.Reader, crlTemplate, &cert, signer)
+	if err != nil {
+		a.log.Error("%v", err)
+		return "", nil, err
 	}
 
 	crlPemBlock := &pem.Block{
@@ -89,29 +154,44 @@ func generateCRL() (string, error) {
 		Bytes: crlBytes,
 	}
 
-	filename, err := data.WriteCRL(pem.EncodeToMemory(crlPemBlock), nextId.String())
+	filename, err := a.store.WriteCRL(pem.EncodeToMemory(crlPemBlock), nextId.String())
 	if err != nil {
-		logger.Error("%v", err)
-		return "", err
+		a.log.Error("%v", err)
+		return "", nil, err
 	}
 
-	updateLastCrl(nextId)
-	return filename, nil
+	crl, err := x509.ParseRevocationList(crlBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	return filename, crl, nil
 }
 
-func convertCertificatesToCRL(certificates []model.FileContentWithPath) ([]pkix.RevokedCertificate, error) {
-	result := []pkix.RevokedCertificate{}
+func (a *Authority) convertCertificatesToCRL(certificates []model.FileContentWithPath) ([]x509.RevocationListEntry, error) {
+	result := []x509.RevocationListEntry{}
+
+	revocations, err := a.store.ReadRevocations()
+	if err != nil {
+		a.log.Error("%v", err)
+		return nil, err
+	}
 
 	for _, certFile := range certificates {
 		cert, err := parseCertificate(certFile.Data)
-		if err != nil || len(cert.Raw) == 0 || cert.NotAfter.Before(time.Now()) {
+		if err != nil || len(cert.Raw) == 0 || cert.NotAfter.Before(a.now()) {
 			continue
 		}
-		revokedCert := pkix.RevokedCertificate{
+		revokedCert := x509.RevocationListEntry{
 			SerialNumber:   cert.SerialNumber,
 			RevocationTime: certFile.PrefixDate,
 		}
 
+		for _, r := range revocations {
+			if r.Serial != nil && r.Serial.Cmp(cert.SerialNumber) == 0 {
+				revokedCert.ReasonCode = r.Reason
+			}
+		}
+
 		result = append(result, revokedCert)
 	}
 
@@ -0,0 +1,297 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/lint"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// CrossOptions holds the constraints placed into a cross certificate.
+type CrossOptions struct {
+	// PathLen limits the CA levels below the cross certified CA.
+	PathLen int
+
+	PermittedDNSDomains     []string
+	ExcludedDNSDomains      []string
+	PermittedIPRanges       []*net.IPNet
+	ExcludedIPRanges        []*net.IPNet
+	PermittedEmailAddresses []string
+	ExcludedEmailAddresses  []string
+	PermittedURIDomains     []string
+	ExcludedURIDomains      []string
+}
+
+func (o CrossOptions) hasNameConstraints() bool {
+	return len(o.PermittedDNSDomains) > 0 || len(o.ExcludedDNSDomains) > 0 ||
+		len(o.PermittedIPRanges) > 0 || len(o.ExcludedIPRanges) > 0 ||
+		len(o.PermittedEmailAddresses) > 0 || len(o.ExcludedEmailAddresses) > 0 ||
+		len(o.PermittedURIDomains) > 0 || len(o.ExcludedURIDomains) > 0
+}
+
+// crossSubject is the part of a foreign CA certificate or CA request a cross certificate is issued for.
+type crossSubject struct {
+	RawSubject   []byte
+	Subject      pkix.Name
+	PublicKey    any
+	SubjectKeyId []byte
+	NotAfter     time.Time
+}
+
+// CrossSign issues a cross certificate for the CA in the given self signed certificate or CA request.
+func (a *Authority) CrossSign(raw []byte, opts CrossOptions) error {
+	signer, err := a.signingKey()
+	if err != nil {
+		return err
+	}
+
+	a.getConfiguration()
+	cert := a.getCaCertificate()
+	if len(cert.Raw) == 0 {
+		return errors.New("no ca certificate found")
+	}
+
+	subject, err := parseCrossSubject(raw)
+	if err != nil {
+		return err
+	}
+
+	maxPathLen, ok := allowedPathLen(cert)
+	if !ok {
+		return errors.New("the path length of this ca does not allow to issue ca certificates")
+	}
+	if opts.PathLen < 0 || (maxPathLen >= 0 && opts.PathLen > maxPathLen) {
+		return fmt.Errorf("path length %d is not allowed for this ca", opts.PathLen)
+	}
+
+	urls, err := a.certificateURLs(cert.SubjectKeyId)
+	if err != nil {
+		a.log.Error("%v", err)
+		return err
+	}
+
+	notAfter := a.now().AddDate(6, 0, 0)
+	if !subject.NotAfter.IsZero() && subject.NotAfter.Before(notAfter) {
+		notAfter = subject.NotAfter
+	}
+
+	name := subject.Subject.CommonName
+	filename := name + "_cross_" + hex.EncodeToString(subject.SubjectKeyId)
+	srl, err := a.reserveSerial(model.JournalEntry{
+		Operation: model.JournalCross,
+		File:      a.store.StoreFileName("ca-issued", filename+".cer"),
+		Publish:   name + "_cross.cer",
+	})
+	if err != nil {
+		return err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:                srl,
+		RawSubject:                  subject.RawSubject,
+		Subject:                     subject.Subject,
+		NotBefore:                   a.now(),
+		NotAfter:                    notAfter,
+		KeyUsage:                    x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		IsCA:                        true,
+		BasicConstraintsValid:       true,
+		MaxPathLen:                  opts.PathLen,
+		MaxPathLenZero:              opts.PathLen == 0,
+		SubjectKeyId:                subject.SubjectKeyId,
+		AuthorityKeyId:              cert.SubjectKeyId,
+		IssuingCertificateURL:       urls.aia,
+		CRLDistributionPoints:       urls.cdp,
+		OCSPServer:                  urls.ocsp,
+		PermittedDNSDomainsCritical: opts.hasNameConstraints(),
+		PermittedDNSDomains:         opts.PermittedDNSDomains,
+		ExcludedDNSDomains:          opts.ExcludedDNSDomains,
+		PermittedIPRanges:           opts.PermittedIPRanges,
+		ExcludedIPRanges:            opts.ExcludedIPRanges,
+		PermittedEmailAddresses:     opts.PermittedEmailAddresses,
+		ExcludedEmailAddresses:      opts.ExcludedEmailAddresses,
+		PermittedURIDomains:         opts.PermittedURIDomains,
+		ExcludedURIDomains:          opts.ExcludedURIDomains,
+	}
+
+	if err := a.checkLint(template.Subject.CommonName, lint.Certificate(template, &cert, subject.PublicKey)); err != nil {
+		return err
+	}
+
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, &cert, subject.PublicKey, signer)
+	if err != nil {
+		a.log.Error("%v", err)
+		return err
+	}
+
+	err = a.storeCaCertificate(certBytes, filename, name+"_cross.cer", true)
+	if err != nil {
+		return err
+	}
+	// the certificate is stored, a journal left behind is completed by the next run
+	if err := a.finishOperation(); err != nil {
+		a.log.Error("Could not clear the journal after issuing serial %s: %v", srl, err)
+	}
+
+	a.log.Info("Cross certificate for %s issued with serial %s", name, srl)
+	return nil
+}
+
+// parseCrossSubject reads a PEM or DER encoded self signed CA certificate or certificate request.
+func parseCrossSubject(raw []byte) (crossSubject, error) {
+	der := raw
+	if block, _ := pem.Decode(raw); block != nil {
+		der = block.Bytes
+	}
+
+	if cert, err := x509.ParseCertificate(der); err == nil {
+		if !cert.IsCA || !isSelfSigned(*cert) {
+			return crossSubject{}, errors.New("the certificate is not a self signed ca certificate")
+		}
+		ski := cert.SubjectKeyId
+		if len(ski) == 0 {
+			ski, err = subjectKeyId(cert.PublicKey)
+			if err != nil {
+				return crossSubject{}, err
+			}
+		}
+		return crossSubject{
+			RawSubject:   cert.RawSubject,
+			Subject:      cert.Subject,
+			PublicKey:    cert.PublicKey,
+			SubjectKeyId: ski,
+			NotAfter:     cert.NotAfter,
+		}, nil
+	}
+
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return crossSubject{}, errors.New("neither a certificate nor a certificate request")
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return crossSubject{}, fmt.Errorf("invalid request signature: %w", err)
+	}
+
+	ski, err := subjectKeyId(csr.PublicKey)
+	if err != nil {
+		return crossSubject{}, err
+	}
+	return crossSubject{
+		RawSubject:   csr.RawSubject,
+		Subject:      csr.Subject,
+		PublicKey:    csr.PublicKey,
+		SubjectKeyId: ski,
+	}, nil
+}
+
+func subjectKeyId(pub any) ([]byte, error) {
+	publicKey, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	ski := sha256.Sum256(publicKey)
+	return ski[:], nil
+}
+
+// VerifyCrossChains verifies the leaf certificate against this root through the cross certificates
+// and against the foreign root directly. It returns the chains found for both trust anchors.
+func (a *Authority) VerifyCrossChains(leafRaw []byte, foreignRootRaw []byte, intermediatesRaw []byte) ([][]*x509.Certificate, [][]*x509.Certificate, error) {
+	leafs, err := parseCertificatesOrDer(leafRaw)
+	if err != nil || len(leafs) == 0 {
+		return nil, nil, fmt.Errorf("could not read leaf certificate: %v", err)
+	}
+	foreign, err := parseCertificatesOrDer(foreignRootRaw)
+	if err != nil || len(foreign) == 0 {
+		return nil, nil, fmt.Errorf("could not read foreign root certificate: %v", err)
+	}
+	extra, err := parseCertificatesOrDer(intermediatesRaw)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read intermediate certificates: %v", err)
+	}
+
+	cert := a.getCaCertificate()
+	if len(cert.Raw) == 0 {
+		return nil, nil, errors.New("no ca certificate found")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range extra {
+		intermediates.AddCert(&c)
+	}
+	for _, c := range leafs[1:] {
+		intermediates.AddCert(&c)
+	}
+
+	subs, err := a.store.ReadSubCaRegistry()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, sub := range subs {
+		if !sub.Cross || sub.IsRevoked() {
+			continue
+		}
+		raw, err := a.store.ReadIssuedCertificate(sub.File)
+		if err != nil {
+			continue
+		}
+		cross, err := parseCertificate(raw)
+		if err != nil {
+			continue
+		}
+		intermediates.AddCert(&cross)
+	}
+
+	own := x509.NewCertPool()
+	own.AddCert(&cert)
+	foreignRoots := x509.NewCertPool()
+	foreignRoots.AddCert(&foreign[0])
+
+	opts := x509.VerifyOptions{
+		Intermediates: intermediates,
+		CurrentTime:   a.now(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+
+	leaf := leafs[0]
+
+	opts.Roots = own
+	ownChains, ownErr := leaf.Verify(opts)
+
+	opts.Roots = foreignRoots
+	foreignChains, foreignErr := leaf.Verify(opts)
+
+	if ownErr != nil || foreignErr != nil {
+		return ownChains, foreignChains, errors.Join(wrapVerifyError(cert.Subject.CommonName, ownErr), wrapVerifyError(foreign[0].Subject.CommonName, foreignErr))
+	}
+	return ownChains, foreignChains, nil
+}
+
+func wrapVerifyError(anchor string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("no valid chain to %s: %w", anchor, err)
+}
+
+// parseCertificatesOrDer parses a PEM bundle or a single DER encoded certificate.
+func parseCertificatesOrDer(raw []byte) ([]x509.Certificate, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	if block, _ := pem.Decode(raw); block != nil {
+		return parseCertificates(raw)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		return nil, err
+	}
+	return []x509.Certificate{*cert}, nil
+}
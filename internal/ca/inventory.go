@@ -0,0 +1,80 @@
+package ca
+
+import (
+	"crypto/x509"
+	"fmt"
+	"math/big"
+
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// Certificates returns the certificates issued by the CA with their status.
+func (a *Authority) Certificates() ([]model.IssuedCertificate, error) {
+	revoked, err := a.revokedSerials()
+	if err != nil {
+		return nil, err
+	}
+	files, err := a.store.GetIssuedCertificatesFromCaStore()
+	if err != nil {
+		return nil, err
+	}
+
+	now := a.now()
+	var result []model.IssuedCertificate
+	for _, f := range files {
+		cert, err := parseCertificate(f.Data)
+		if err != nil {
+			a.log.Warning("Skipped %s: %v", f.Name, err)
+			continue
+		}
+
+		status := model.CertificateValid
+		switch {
+		case revoked[cert.SerialNumber.String()]:
+			status = model.CertificateRevoked
+		case now.After(cert.NotAfter):
+			status = model.CertificateExpired
+		}
+		result = append(result, model.IssuedCertificate{
+			Serial:    cert.SerialNumber,
+			Subject:   cert.Subject.String(),
+			DNSNames:  cert.DNSNames,
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+			Status:    status,
+			File:      f.Name,
+		})
+	}
+	return result, nil
+}
+
+// IssuedCertificate returns the certificate with the serial from the issued folder.
+func (a *Authority) IssuedCertificate(serial *big.Int) (*x509.Certificate, error) {
+	files, err := a.store.GetIssuedCertificatesFromCaStore()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		cert, err := parseCertificate(f.Data)
+		if err == nil && cert.SerialNumber.Cmp(serial) == 0 {
+			return &cert, nil
+		}
+	}
+	return nil, fmt.Errorf("no issued certificate with serial %s found", serial)
+}
+
+// revokedSerials returns the serials in the revoked folder of the store.
+func (a *Authority) revokedSerials() (map[string]bool, error) {
+	files, err := a.store.GetRevokedCertificatesFromCaStore()
+	if err != nil {
+		return nil, err
+	}
+	serials := map[string]bool{}
+	for _, f := range files {
+		cert, err := parseCertificate(f.Data)
+		if err == nil {
+			serials[cert.SerialNumber.String()] = true
+		}
+	}
+	return serials, nil
+}
@@ -1,240 +1,371 @@
 package ca
 
 import (
+	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/hex"
-	"encoding/pem"
-	"math/big"
-	"net/url"
-	"time"
-
-	"deleteonerror.com/tyinypki/internal/data"
-	"deleteonerror.com/tyinypki/internal/logger"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"deleteonerror.com/tyinypki/internal/lint"
+	"deleteonerror.com/tyinypki/internal/model"
 )
 
-func IssuePendingCaRequests() error {
-	requests, err := data.GetCaCertificateRequests()
+func (a *Authority) IssuePendingCaRequests() error {
+	requests, err := a.store.GetCaCertificateRequests()
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return err
 	}
 
 	if len(requests) == 0 {
-		logger.Debug("no pending requests found")
+		a.log.Debug("no pending requests found")
 		return nil
 	}
 
-	for _, req := range requests {
+	if !a.CanIssueCaCertificates() {
+		a.log.Warning("The path length of this CA does not allow to issue CA certificates, %d requests skipped.", len(requests))
+		return nil
+	}
+
+	pub, err := a.publicKey()
+	if err != nil {
+		return err
+	}
 
-		block, _ := pem.Decode(req.Data)
+	for _, req := range requests {
 
-		x509Req, err := x509.ParseCertificateRequest(block.Bytes)
+		x509Req, err := x509.ParseCertificateRequest(derBytes(req.Data))
 		if err != nil {
-			logger.Error("Failed to parse request %s: %v", req.Name, err)
+			a.log.Error("Failed to parse request %s: %v", req.Name, err)
+			continue
+		}
+
+		ecdsaPubKey, ok := x509Req.PublicKey.(*ecdsa.PublicKey)
+		if ok && arePublicKeysEqual(ecdsaPubKey, pub) {
+			a.log.Debug("Skipped %s, request of this CA", req.Name)
 			continue
 		}
 		//check if request key usage contains cer and crl sign
-		err = createIntermediateCertificate(x509Req)
-		if err != nil {
-			logger.Error("Failed to Issue request %s: %v", req.Name, err)
+		record := model.RequestRecord{Profile: ProfileCA, Requester: ProfileCA, Source: filepath.Join(req.Path, req.Name)}
+		_, err = a.issueOrQueue(x509Req, record, false)
+		var pending *PendingError
+		if err != nil && !errors.As(err, &pending) {
+			a.log.Error("Failed to Issue request %s: %v", req.Name, err)
 			continue
 		}
-		data.ArchiveRequest(req.Path, req.Name)
+		a.store.ArchiveRequest(requestSource(req))
 	}
 
 	return nil
 }
 
-func IssuePendingRequests() error {
-	requests := data.GetCertificateRequests()
+func (a *Authority) IssuePendingRequests() error {
+	requests := a.store.GetCertificateRequests()
 
 	if len(requests) == 0 {
 		return nil
 	}
 
 	for _, req := range requests {
-
-		block, _ := pem.Decode(req.Data)
-		if block == nil {
-			logger.Debug("Skipped %s, no pem encoded file", req.Path)
+		profile := requestProfile(req.RequestType)
+		source := filepath.Join(req.Path, req.Name)
+		status, err := a.receiveRequest(req, profile)
+		if err != nil {
+			a.log.Error("%v", err)
 			continue
 		}
-		logger.Debug("%s\n", req.Name)
-		x509Req, err := x509.ParseCertificateRequest(block.Bytes)
+		a.updateRequestStatus(source, status)
+
+		a.log.Debug("%s\n", req.Name)
+		x509Req, err := x509.ParseCertificateRequest(derBytes(req.Data))
+		if err == nil {
+			err = x509Req.CheckSignature()
+		}
 		if err != nil {
-			logger.Error("Failed to parse request %s: %v", req.Name, err)
+			a.log.Error("Failed to parse request %s: %v", req.Name, err)
+			a.updateRequestStatus(source, rejectedStatus(status, err.Error()))
+			a.store.ArchiveRequest(requestSource(req))
 			continue
 		}
 
-		switch req.RequestType {
-		case "webserver-requests":
-			err = createWebServerCertificate(x509Req)
-		case "client-requests":
-			err = createClientCertificate(x509Req)
-		case "code-requests":
-			err = createCodeCertificate(x509Req)
-		case "server-requests":
-			err = createServerCertificate(x509Req)
-		case "ocsp-requests":
-			err = createOcspCertificate(x509Req)
-		case "requests":
-			err = createCertificateFromRequest(x509Req)
-		default:
-			err = createCertificateFromRequest(x509Req)
+		record := model.RequestRecord{ID: status.ID, Profile: profile, Requester: req.RequestType, Source: source}
+		cert, err := a.issueOrQueue(x509Req, record, false)
+		var pending *PendingError
+		if errors.As(err, &pending) {
+			status.Status = model.RequestPending
+			a.updateRequestStatus(source, status)
+			a.store.ArchiveRequest(requestSource(req))
+			continue
+		}
+		var lintErr *LintError
+		if errors.As(err, &lintErr) {
+			a.log.Error("Rejected request %s: %v", req.Name, err)
+			status = rejectedStatus(status, err.Error())
+			status.Lint = lintErr.Findings
+			a.updateRequestStatus(source, status)
+			a.store.ArchiveRequest(requestSource(req))
+			continue
 		}
-
 		if err != nil {
-			logger.Error("Failed to Issue request %s: %v", req.Name, err)
+			a.log.Error("Failed to Issue request %s: %v", req.Name, err)
+			status.Status = model.RequestFailed
+			status.Reason = err.Error()
+			a.updateRequestStatus(source, status)
 			continue
 		}
-		data.ArchiveRequest(req.Path, req.Name)
+		a.updateRequestStatus(source, a.issuedStatus(status, cert))
+		a.store.ArchiveRequest(requestSource(req))
 	}
 
 	return nil
 }
 
-func createCertificateFromRequest(csr *x509.CertificateRequest) error {
+// Issue signs the request with the key usages of the given profile and returns the certificate.
+// The certificate is kept in the store and placed with its chain in the issued folder. If approval
+// is required the request is queued instead and a *PendingError is returned, see ApproveRequest.
+func (a *Authority) Issue(csr *x509.CertificateRequest, profile string) (*x509.Certificate, error) {
+	return a.IssueRequest(csr, profile, model.RequestRecord{}, false)
+}
 
-	publicKey, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
-	if err != nil {
-		logger.Error("%v", err)
-		return err
+// IssueRequest is Issue for a request of the requester of the record, the record is kept with the
+// request if it is queued. With hold the request is queued even if approval is not required.
+func (a *Authority) IssueRequest(csr *x509.CertificateRequest, profile string, record model.RequestRecord, hold bool) (*x509.Certificate, error) {
+	if profile == "" {
+		profile = "request"
 	}
-	ski := sha256.Sum256(publicKey)
+	if !slices.Contains(Profiles, profile) {
+		return nil, fmt.Errorf("unknown profile %s", profile)
+	}
+	record.Profile = profile
+	return a.issueOrQueue(csr, record, hold)
+}
 
-	ku, err := getKeyUsage(*csr)
-	if err != nil {
-		logger.Error("No keyusage in Request! Key usage set to Digital signature only")
+// issue signs the request with the profile, source is the path of a request of the request folders.
+func (a *Authority) issue(csr *x509.CertificateRequest, profile string, source string) (*x509.Certificate, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid request signature: %w", err)
+	}
+	a.getConfiguration()
+	if len(a.getCaCertificate().Raw) == 0 {
+		return nil, errors.New("no ca certificate found")
 	}
 
-	eku, err := getExtKeyUsage(*csr)
+	cert, err := a.issueProfile(csr, profile, source)
 	if err != nil {
-		logger.Error("No EKU in Request! Extended Key usage not set.")
+		return nil, err
+	}
+	// the certificate is issued, a journal left behind is completed by the next run
+	if err := a.finishOperation(); err != nil {
+		a.log.Error("Could not clear the journal after issuing serial %s: %v", cert.SerialNumber, err)
 	}
+	return cert, nil
+}
 
-	cdp, err := url.JoinPath(cfg.Config.BaseUrl, url.PathEscape(cfg.Config.Name+".crl"))
-	if err != nil {
-		logger.Error("%v", err)
-		return err
+// Profiles are the certificate types accepted by Issue, the names match the request folders.
+var Profiles = []string{"request", "server", "webserver", "client", "code", "ocsp"}
+
+// profileExtKeyUsages are the extended key usages of the profiles, the request profile takes them from the request.
+var profileExtKeyUsages = map[string][]x509.ExtKeyUsage{
+	"server":    {x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	"webserver": {x509.ExtKeyUsageServerAuth},
+	"client":    {x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageEmailProtection},
+	"code":      {x509.ExtKeyUsageCodeSigning},
+	"ocsp":      {x509.ExtKeyUsageOCSPSigning},
+}
+
+// CertificateProfile returns the profile whose extended key usages the certificate has,
+// empty if it matches none of them.
+func CertificateProfile(cert *x509.Certificate) string {
+	for _, profile := range Profiles {
+		if eku, ok := profileExtKeyUsages[profile]; ok && slices.Equal(eku, cert.ExtKeyUsage) {
+			return profile
+		}
 	}
+	return ""
+}
 
-	aia, err := url.JoinPath(cfg.Config.BaseUrl, url.PathEscape(cfg.Config.Name+".cer"))
-	if err != nil {
-		logger.Error("%v", err)
-		return err
+// ParseProfiles parses comma separated profile names.
+func ParseProfiles(list string) ([]string, error) {
+	var profiles []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if !slices.Contains(Profiles, p) {
+			return nil, fmt.Errorf("unknown profile %s", p)
+		}
+		profiles = append(profiles, p)
 	}
-	srl := cfg.Config.LastIssuedSerial
+	return profiles, nil
+}
 
-	srl.Add(srl, big.NewInt(1))
+// requestProfile returns the profile for the folder a request was placed in.
+func requestProfile(requestType string) string {
+	profile := strings.TrimSuffix(requestType, "-requests")
+	if slices.Contains(Profiles, profile) {
+		return profile
+	}
+	return "request"
+}
 
-	template := &x509.Certificate{
-		SerialNumber:          srl,
-		Subject:               csr.Subject,
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(1, 0, 0),
-		IsCA:                  false,
-		BasicConstraintsValid: false,
-		MaxPathLen:            0,
-		SubjectKeyId:          ski[:],
-		AuthorityKeyId:        cfg.Certificate.SubjectKeyId,
-		DNSNames:              csr.DNSNames,
-		EmailAddresses:        csr.EmailAddresses,
-		IPAddresses:           csr.IPAddresses,
-		URIs:                  csr.URIs,
-		KeyUsage:              ku,
-		ExtKeyUsage:           eku,
-		IssuingCertificateURL: []string{aia},
-		CRLDistributionPoints: []string{cdp},
+func (a *Authority) issueProfile(csr *x509.CertificateRequest, profile string, request string) (*x509.Certificate, error) {
+	switch profile {
+	case "webserver":
+		return a.createWebServerCertificate(csr, request)
+	case "client":
+		return a.createClientCertificate(csr, request)
+	case "code":
+		return a.createCodeCertificate(csr, request)
+	case "server":
+		return a.createServerCertificate(csr, request)
+	case "ocsp":
+		return a.createOcspCertificate(csr, request)
+	case "request", "":
+		return a.createCertificateFromRequest(csr, request)
+	case ProfileCA:
+		return a.createIntermediateCertificate(csr, request)
+	default:
+		return nil, fmt.Errorf("unknown profile %s", profile)
 	}
+}
 
-	cert := getCaCertificate()
-	key := getPrivateKey()
+// createCertificateFromRequest takes the key usages from the request.
+func (a *Authority) createCertificateFromRequest(csr *x509.CertificateRequest, request string) (*x509.Certificate, error) {
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, &cert, csr.PublicKey, &key)
+	ku, err := getKeyUsage(*csr)
 	if err != nil {
-		logger.Error("%v", err)
-		return err
+		a.log.Error("No keyusage in Request! Key usage set to Digital signature only")
 	}
 
-	file, err := data.WriteRawIssuedCertificate(certBytes, csr.Subject.CommonName+"_"+hex.EncodeToString(ski[:]))
+	eku, err := getExtKeyUsage(*csr)
 	if err != nil {
-		logger.Error("%v", err)
-		return err
+		a.log.Error("No EKU in Request! Extended Key usage not set.")
 	}
 
-	updateLastSerial(srl)
-	data.Issued(file)
-
-	return nil
+	return a.createCertificate(csr, request, ku, nil, eku)
 }
 
-func createIntermediateCertificate(csr *x509.CertificateRequest) error {
+func (a *Authority) createIntermediateCertificate(csr *x509.CertificateRequest, request string) (*x509.Certificate, error) {
 
 	publicKey, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
 	if err != nil {
-		logger.Error("%v", err)
-		return err
+		a.log.Error("%v", err)
+		return nil, err
 	}
 	ski := sha256.Sum256(publicKey)
 
-	cdp, err := url.JoinPath(cfg.Config.BaseUrl, url.PathEscape(cfg.Config.Name+".crl"))
+	cert := a.getCaCertificate()
+	urls, err := a.certificateURLs(cert.SubjectKeyId)
 	if err != nil {
-		logger.Error("%v", err)
-		return err
+		a.log.Error("%v", err)
+		return nil, err
 	}
 
-	aia, err := url.JoinPath(cfg.Config.BaseUrl, url.PathEscape(cfg.Config.Name+".cer"))
-	if err != nil {
-		logger.Error("%v", err)
-		return err
+	maxPathLen, ok := allowedPathLen(cert)
+	if !ok {
+		return nil, errors.New("the path length of this ca does not allow to issue ca certificates")
 	}
-	srl := cfg.Config.LastIssuedSerial
 
-	logger.Debug("srl is %d\n", srl)
-	srl.Add(srl, big.NewInt(1))
+	pathLen, requested := getRequestedPathLen(*csr)
+	if !requested {
+		pathLen = 0
+	}
+	if maxPathLen >= 0 && pathLen > maxPathLen {
+		a.log.Warning("Requested path length %d exceeds the allowed path length, limited to %d", pathLen, maxPathLen)
+		pathLen = maxPathLen
+	}
+
+	filename := csr.Subject.CommonName + "_" + hex.EncodeToString(ski[:])
+	srl, err := a.reserveSerial(model.JournalEntry{
+		Operation: model.JournalIssueCa,
+		File:      a.store.StoreFileName("ca-issued", filename+".cer"),
+		Request:   request,
+		Publish:   csr.Subject.CommonName + ".cer",
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.log.Debug("srl is %d\n", srl)
 
 	template := &x509.Certificate{
 		SerialNumber:          srl,
 		Subject:               csr.Subject,
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(6, 0, 0),
+		NotBefore:             a.now(),
+		NotAfter:              a.now().AddDate(6, 0, 0),
 		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		IsCA:                  true,
 		BasicConstraintsValid: true,
-		MaxPathLen:            0,
-		MaxPathLenZero:        true,
+		MaxPathLen:            pathLen,
+		MaxPathLenZero:        pathLen == 0,
 		SubjectKeyId:          ski[:],
-		AuthorityKeyId:        cfg.Certificate.SubjectKeyId,
-		IssuingCertificateURL: []string{aia},
-		CRLDistributionPoints: []string{cdp},
+		AuthorityKeyId:        cert.SubjectKeyId,
+		IssuingCertificateURL: urls.aia,
+		CRLDistributionPoints: urls.cdp,
+		OCSPServer:            urls.ocsp,
+	}
+
+	signer, err := a.signingKey()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.checkLint(csr.Subject.CommonName, lint.Certificate(template, &cert, csr.PublicKey)); err != nil {
+		return nil, err
 	}
 
-	cert := getCaCertificate()
-	key := getPrivateKey()
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, &cert, csr.PublicKey, signer)
+	if err != nil {
+		a.log.Error("%v", err)
+		return nil, err
+	}
+
+	if err := a.storeCaCertificate(certBytes, filename, csr.Subject.CommonName+".cer", false); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(certBytes)
+}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, &cert, csr.PublicKey, &key)
+// storeCaCertificate keeps an issued CA certificate in the store and the registry,
+// places it with the issuer chain in the ca-cert-in folder and publishes it for the AIA.
+func (a *Authority) storeCaCertificate(certBytes []byte, filename string, publishName string, cross bool) error {
+	file, err := a.store.WriteRawIssuedCertificate(certBytes, filename)
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return err
 	}
 
-	file, err := data.WriteRawIssuedCaCertificate(certBytes, csr.Subject.CommonName+"_"+hex.EncodeToString(ski[:]))
+	_, err = a.store.WriteRawIssuedCaCertificate(certBytes, encodeChain(a.getCaChain(), true), filename)
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return err
 	}
 
-	updateLastSerial(srl)
-	data.Publish(file, csr.Subject.CommonName+".cer")
+	issued, err := x509.ParseCertificate(certBytes)
+	if err == nil {
+		err = a.registerSubAuthority(issued, file, cross)
+	}
+	if err != nil {
+		a.log.Error("Failed to register sub ca %s: %v", filename, err)
+	}
+
+	err = a.store.PublishRawCertificate(certBytes, publishName)
+	if err != nil {
+		a.log.Error("%v", err)
+		return fmt.Errorf("certificate issued but not published: %w", err)
+	}
 
 	return nil
 }
 
-func createServerCertificate(csr *x509.CertificateRequest) error {
+func (a *Authority) createServerCertificate(csr *x509.CertificateRequest, request string) (*x509.Certificate, error) {
 
 	keyUsage := x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement
 
@@ -251,12 +382,12 @@ func createServerCertificate(csr *x509.CertificateRequest) error {
 
 	ext := []pkix.Extension{commentExt}
 
-	eku := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	eku := profileExtKeyUsages["server"]
 
-	return createCertificate(csr, keyUsage, ext, eku)
+	return a.createCertificate(csr, request, keyUsage, ext, eku)
 }
 
-func createWebServerCertificate(csr *x509.CertificateRequest) error {
+func (a *Authority) createWebServerCertificate(csr *x509.CertificateRequest, request string) (*x509.Certificate, error) {
 
 	keyUsage := x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement
 
@@ -272,12 +403,12 @@ func createWebServerCertificate(csr *x509.CertificateRequest) error {
 	}
 
 	ext := []pkix.Extension{commentExt}
-	eku := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	eku := profileExtKeyUsages["webserver"]
 
-	return createCertificate(csr, keyUsage, ext, eku)
+	return a.createCertificate(csr, request, keyUsage, ext, eku)
 }
 
-func createClientCertificate(csr *x509.CertificateRequest) error {
+func (a *Authority) createClientCertificate(csr *x509.CertificateRequest, request string) (*x509.Certificate, error) {
 
 	// [ client_reqext ]
 	// keyUsage                = critical,digitalSignature,keyEncipherment,dataEncipherment
@@ -298,12 +429,12 @@ func createClientCertificate(csr *x509.CertificateRequest) error {
 
 	ext := []pkix.Extension{commentExt}
 
-	eku := []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageEmailProtection}
+	eku := profileExtKeyUsages["client"]
 
-	return createCertificate(csr, keyUsage, ext, eku)
+	return a.createCertificate(csr, request, keyUsage, ext, eku)
 }
 
-func createCodeCertificate(csr *x509.CertificateRequest) error {
+func (a *Authority) createCodeCertificate(csr *x509.CertificateRequest, request string) (*x509.Certificate, error) {
 
 	// [ codesign_reqext ]
 	// keyUsage                = critical,digitalSignature
@@ -323,13 +454,12 @@ func createCodeCertificate(csr *x509.CertificateRequest) error {
 	}
 
 	ext := []pkix.Extension{commentExt}
-	eku := []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+	eku := profileExtKeyUsages["code"]
 
-	return createCertificate(csr, keyUsage, ext, eku)
+	return a.createCertificate(csr, request, keyUsage, ext, eku)
 }
 
-
-func createOcspCertificate(csr *x509.CertificateRequest) error {
+func (a *Authority) createOcspCertificate(csr *x509.CertificateRequest, request string) (*x509.Certificate, error) {
 
 	// # Extension for OCSP signing certificates (`man ocsp`).
 	// keyUsage                = critical, digitalSignature
@@ -350,73 +480,84 @@ func createOcspCertificate(csr *x509.CertificateRequest) error {
 
 	ext := []pkix.Extension{commentExt}
 
-	eku := []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}
+	eku := profileExtKeyUsages["ocsp"]
 
-	return createCertificate(csr, keyUsage, ext, eku)
+	return a.createCertificate(csr, request, keyUsage, ext, eku)
 }
 
-func createCertificate(csr *x509.CertificateRequest, ku x509.KeyUsage, extensions []pkix.Extension, eku []x509.ExtKeyUsage) error {
+func (a *Authority) createCertificate(csr *x509.CertificateRequest, request string, ku x509.KeyUsage, extensions []pkix.Extension, eku []x509.ExtKeyUsage) (*x509.Certificate, error) {
 
 	publicKey, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
 	if err != nil {
-		logger.Error("%v", err)
-		return err
+		a.log.Error("%v", err)
+		return nil, err
 	}
 	ski := sha256.Sum256(publicKey)
 
-	cdp, err := url.JoinPath(cfg.Config.BaseUrl, url.PathEscape(cfg.Config.Name+".crl"))
+	cert := a.getCaCertificate()
+	urls, err := a.certificateURLs(cert.SubjectKeyId)
 	if err != nil {
-		logger.Error("%v", err)
-		return err
+		a.log.Error("%v", err)
+		return nil, err
 	}
-
-	aia, err := url.JoinPath(cfg.Config.BaseUrl, url.PathEscape(cfg.Config.Name+".cer"))
+	signer, err := a.signingKey()
 	if err != nil {
-		logger.Error("%v", err)
-		return err
+		return nil, err
 	}
-	srl := cfg.Config.LastIssuedSerial
 
-	srl.Add(srl, big.NewInt(1))
+	filename := csr.Subject.CommonName + "_" + hex.EncodeToString(ski[:])
+	srl, err := a.reserveSerial(model.JournalEntry{
+		Operation: model.JournalIssue,
+		File:      a.store.StoreFileName("ca-issued", filename+".cer"),
+		Request:   request,
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	template := &x509.Certificate{
 		SerialNumber:          srl,
 		Subject:               csr.Subject,
-		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(1, 0, 0),
+		NotBefore:             a.now(),
+		NotAfter:              a.now().AddDate(1, 0, 0),
 		IsCA:                  false,
 		BasicConstraintsValid: false,
 		MaxPathLen:            0,
 		SubjectKeyId:          ski[:],
-		AuthorityKeyId:        cfg.Certificate.SubjectKeyId,
+		AuthorityKeyId:        cert.SubjectKeyId,
 		DNSNames:              csr.DNSNames,
 		EmailAddresses:        csr.EmailAddresses,
 		IPAddresses:           csr.IPAddresses,
 		URIs:                  csr.URIs,
 		KeyUsage:              ku,
 		ExtKeyUsage:           eku,
-		IssuingCertificateURL: []string{aia},
-		CRLDistributionPoints: []string{cdp},
+		IssuingCertificateURL: urls.aia,
+		CRLDistributionPoints: urls.cdp,
+		OCSPServer:            urls.ocsp,
 		ExtraExtensions:       extensions,
 	}
 
-	cert := getCaCertificate()
-	key := getPrivateKey()
+	if err := a.checkLint(csr.Subject.CommonName, lint.Certificate(template, &cert, csr.PublicKey)); err != nil {
+		return nil, err
+	}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, template, &cert, csr.PublicKey, &key)
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, &cert, csr.PublicKey, signer)
 	if err != nil {
-		logger.Error("%v", err)
-		return err
+		a.log.Error("%v", err)
+		return nil, err
 	}
 
-	file, err := data.WriteRawIssuedCertificate(certBytes, csr.Subject.CommonName+"_"+hex.EncodeToString(ski[:]))
+	file, err := a.store.WriteRawIssuedCertificate(certBytes, filename)
 	if err != nil {
-		logger.Error("%v", err)
-		return err
+		a.log.Error("%v", err)
+		return nil, err
 	}
 
-	updateLastSerial(srl)
-	data.Issued(file)
+	err = a.store.Issued(file)
+	if err != nil {
+		return nil, err
+	}
+	a.writeIssuedChain(certBytes, filename)
 
-	return nil
+	return x509.ParseCertificate(certBytes)
 }
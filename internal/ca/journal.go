@@ -0,0 +1,177 @@
+package ca
+
+import (
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"path"
+	"strings"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// reserveSerial journals the operation and persists the next serial before anything is signed,
+// so a crash can never hand out a serial twice.
+func (a *Authority) reserveSerial(entry model.JournalEntry) (*big.Int, error) {
+	if err := a.recoverJournal(); err != nil {
+		return nil, err
+	}
+
+	entry.Number = new(big.Int).Add(a.getConfiguration().LastIssuedSerial, big.NewInt(1))
+	if err := a.beginOperation(entry); err != nil {
+		return nil, err
+	}
+
+	if err := a.updateLastSerial(new(big.Int).Set(entry.Number)); err != nil {
+		a.log.Error("Could not reserve serial %s: %v", entry.Number, err)
+		return nil, err
+	}
+	return entry.Number, nil
+}
+
+// reserveCrlNumber journals the CRL generation and persists the next CRL number before the CRL is signed.
+func (a *Authority) reserveCrlNumber() (*big.Int, error) {
+	if err := a.recoverJournal(); err != nil {
+		return nil, err
+	}
+
+	number := new(big.Int).Add(a.getConfiguration().LastCRLNumber, big.NewInt(1))
+	entry := model.JournalEntry{
+		Operation: model.JournalCrl,
+		Number:    number,
+		File:      a.store.StoreFileName("ca-crl", number.String()+".crl"),
+	}
+	if err := a.beginOperation(entry); err != nil {
+		return nil, err
+	}
+
+	if err := a.updateLastCrl(new(big.Int).Set(number)); err != nil {
+		a.log.Error("Could not reserve crl number %s: %v", number, err)
+		return nil, err
+	}
+	return number, nil
+}
+
+func (a *Authority) beginOperation(entry model.JournalEntry) error {
+	entry.Started = a.now().UTC()
+	if err := a.store.WriteJournal(entry); err != nil {
+		a.log.Error("Could not write journal: %v", err)
+		return err
+	}
+	a.log.Debug("Journal: %s %s started", entry.Operation, entry.Number)
+	return nil
+}
+
+// finishOperation removes the journal entry once all files of the operation are written.
+func (a *Authority) finishOperation() error {
+	return a.store.ClearJournal()
+}
+
+// recoverJournal finishes or rolls back the operation left by an interrupted run.
+// The reserved number is never handed out again. If the store file of the operation was written with
+// the reserved number, the remaining steps are repeated, otherwise the operation is dropped and its
+// request stays queued.
+func (a *Authority) recoverJournal() error {
+	entry, err := a.store.ReadJournal()
+	if err != nil {
+		return fmt.Errorf("could not read journal: %w", err)
+	}
+	if entry == nil {
+		return nil
+	}
+	if entry.Number == nil {
+		a.log.Warning("Dropped invalid journal entry for %s", entry.Operation)
+		return a.store.ClearJournal()
+	}
+
+	conf := a.getConfiguration()
+	if entry.Operation == model.JournalCrl {
+		if conf.LastCRLNumber.Cmp(entry.Number) < 0 {
+			err = a.updateLastCrl(new(big.Int).Set(entry.Number))
+		}
+	} else if conf.LastIssuedSerial.Cmp(entry.Number) < 0 {
+		err = a.updateLastSerial(new(big.Int).Set(entry.Number))
+	}
+	if err != nil {
+		return err
+	}
+
+	if removed, err := a.store.RemoveTempFiles(); err != nil {
+		a.log.Error("Could not remove temporary files: %v", err)
+	} else if removed > 0 {
+		a.log.Info("Removed %d temporary files of an interrupted write", removed)
+	}
+
+	if !a.operationWritten(*entry) {
+		a.log.Warning("Interrupted %s with number %s started at %s was rolled back, the number stays reserved.",
+			entry.Operation, entry.Number, entry.Started.Format(time.RFC3339))
+		return a.store.ClearJournal()
+	}
+
+	if err := a.completeOperation(*entry); err != nil {
+		return fmt.Errorf("could not finish interrupted %s with number %s: %w", entry.Operation, entry.Number, err)
+	}
+
+	if entry.Request != "" {
+		a.store.ArchiveRequest(entry.Request)
+	}
+
+	a.log.Warning("Interrupted %s with number %s started at %s was finished.",
+		entry.Operation, entry.Number, entry.Started.Format(time.RFC3339))
+	return a.store.ClearJournal()
+}
+
+// operationWritten reports if the store file of the operation exists and holds the reserved number.
+// Certificates are stored as <CN>_<key id>.cer, the file of an earlier certificate for the same key
+// is not the one of the interrupted operation. CRL files are named after their number.
+func (a *Authority) operationWritten(entry model.JournalEntry) bool {
+	if entry.File == "" || !a.store.StoreFileExists(entry.File) {
+		return false
+	}
+	if entry.Operation == model.JournalCrl {
+		return true
+	}
+	raw, err := a.store.ReadIssuedCertificate(path.Base(entry.File))
+	if err != nil {
+		a.log.Warning("Could not read %s of the interrupted %s: %v", entry.File, entry.Operation, err)
+		return false
+	}
+	cert, err := x509.ParseCertificate(derBytes(raw))
+	if err != nil {
+		a.log.Warning("Could not parse %s of the interrupted %s: %v", entry.File, entry.Operation, err)
+		return false
+	}
+	if cert.SerialNumber.Cmp(entry.Number) != 0 {
+		a.log.Info("%s holds serial %s of an earlier certificate, not the reserved serial %s", entry.File, cert.SerialNumber, entry.Number)
+		return false
+	}
+	return true
+}
+
+// completeOperation repeats the steps of an operation which follow the write of its store file.
+func (a *Authority) completeOperation(entry model.JournalEntry) error {
+	if entry.Operation == model.JournalCrl {
+		return a.publishCRL(entry.File)
+	}
+
+	raw, err := a.store.ReadIssuedCertificate(path.Base(entry.File))
+	if err != nil {
+		return err
+	}
+	der := derBytes(raw)
+	filename := strings.TrimSuffix(path.Base(entry.File), ".cer")
+
+	switch entry.Operation {
+	case model.JournalIssue:
+		if err := a.store.Issued(entry.File); err != nil {
+			return err
+		}
+		a.writeIssuedChain(der, filename)
+		return nil
+	case model.JournalIssueCa, model.JournalCross:
+		return a.storeCaCertificate(der, filename, entry.Publish, entry.Operation == model.JournalCross)
+	default:
+		return fmt.Errorf("unknown operation %s", entry.Operation)
+	}
+}
@@ -1,14 +1,14 @@
 package ca
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
-	"os"
+	"errors"
 
-	"deleteonerror.com/tyinypki/internal/data"
 	"deleteonerror.com/tyinypki/internal/logger"
 	"golang.org/x/crypto/chacha20poly1305"
 )
@@ -25,42 +25,75 @@ func arePublicKeysEqual(key1, key2 *ecdsa.PublicKey) bool {
 		key1.Y.Cmp(key2.Y) == 0
 }
 
-func getPrivateKey() ecdsa.PrivateKey {
-	if cfg.PrivateKey.D == nil {
-		raw, err := getRawPrivateKey(PassPhrase)
-		if err != nil {
-			logger.Error("Cold not read Private Key, wrong passphrase or corupted key file.")
-			os.Exit(1)
+// signingKey returns the signer of the CA, the key in the store is decrypted on first use.
+func (a *Authority) signingKey() (crypto.Signer, error) {
+	if a.signer == nil {
+		if _, err := a.loadPrivateKey(); err != nil {
+			return nil, err
 		}
-		key, err := x509.ParseECPrivateKey(raw)
-		if err != nil {
-			logger.Error("Cold not parse Private Key file: %v", err)
-			os.Exit(1)
-		}
-		cfg.PrivateKey = *key
-		logger.Debug("Private Key loaded.")
 	}
+	return a.signer, nil
+}
 
-	return cfg.PrivateKey
+// Unlock decrypts the CA key with the passphrase, otherwise the key is decrypted when it is first used.
+func (a *Authority) Unlock() error {
+	_, err := a.signingKey()
+	return err
 }
 
-func getRawPrivateKey(pass []byte) ([]byte, error) {
+// publicKey returns the public key of the CA signer.
+func (a *Authority) publicKey() (*ecdsa.PublicKey, error) {
+	signer, err := a.signingKey()
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := signer.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("the ca signer has no ecdsa key")
+	}
+	return pub, nil
+}
 
-	encKey := sha256.Sum256([]byte(pass))
+// loadPrivateKey decrypts the CA key with the passphrase and keeps it as signer.
+func (a *Authority) loadPrivateKey() (*ecdsa.PrivateKey, error) {
+	raw, err := a.getRawPrivateKey()
+	if err != nil {
+		a.log.Error("Cold not read Private Key, wrong passphrase or corupted key file.")
+		return nil, err
+	}
+	key, err := x509.ParseECPrivateKey(raw)
+	if err != nil {
+		a.log.Error("Cold not parse Private Key file: %v", err)
+		return nil, err
+	}
+	a.signer = key
+	a.log.Debug("Private Key loaded.")
+	return key, nil
+}
 
-	aead, err := chacha20poly1305.NewX(encKey[:])
+func (a *Authority) getRawPrivateKey() ([]byte, error) {
+
+	nonce, err := a.store.ReadKeyNonce()
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return nil, err
 	}
 
-	nonce, err := data.ReadKeyNonce()
+	encryptedKey, err := a.store.ReadKey()
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return nil, err
 	}
 
-	encryptedKey, err := data.ReadKey()
+	return decryptPrivateKey(a.pass, nonce, encryptedKey)
+}
+
+// decryptPrivateKey returns the DER encoded private key from the encrypted key file content.
+func decryptPrivateKey(pass []byte, nonce []byte, encryptedKey []byte) ([]byte, error) {
+
+	encKey := sha256.Sum256([]byte(pass))
+
+	aead, err := chacha20poly1305.NewX(encKey[:])
 	if err != nil {
 		logger.Error("%v", err)
 		return nil, err
@@ -91,39 +124,64 @@ func CreatePrivateKey() ([]byte, ecdsa.PrivateKey, error) {
 	return x509DerEncoded, *ecKey, nil
 }
 
-func createEncryptedPrivateKey(pass []byte) (*ecdsa.PrivateKey, error) {
+// setupKey returns the signer given in the options, without one a new key is created in the store.
+func (a *Authority) setupKey() (crypto.Signer, error) {
+	if a.signer != nil {
+		return a.signer, nil
+	}
+	if _, err := a.createEncryptedPrivateKey(); err != nil {
+		a.log.Error("%v", err)
+		return nil, err
+	}
+	return a.signer, nil
+}
+
+// createEncryptedPrivateKey creates the CA key, stores it encrypted with the passphrase and uses it as signer.
+func (a *Authority) createEncryptedPrivateKey() (*ecdsa.PrivateKey, error) {
 	curve := elliptic.P384()
 	ecKey, err := ecdsa.GenerateKey(curve, rand.Reader)
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return nil, err
 	}
 
 	x509DerEncoded, err := x509.MarshalECPrivateKey(ecKey)
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
+		return nil, err
+	}
+
+	nonce, ciphertext, err := encryptPrivateKey(a.pass, x509DerEncoded)
+	if err != nil {
+		a.log.Error("%v", err)
+		return nil, err
+	}
+	if err := a.store.WriteKey(ciphertext); err != nil {
+		return nil, err
+	}
+	if err := a.store.WriteKeyNonce(nonce); err != nil {
 		return nil, err
 	}
 
+	a.signer = ecKey
+	return ecKey, nil
+}
+
+// encryptPrivateKey encrypts the DER encoded private key with the passphrase, it returns the nonce and the encrypted key.
+func encryptPrivateKey(pass []byte, x509DerEncoded []byte) ([]byte, []byte, error) {
 	encKey := sha256.Sum256([]byte(pass))
 	aead, err := chacha20poly1305.NewX(encKey[:])
 	if err != nil {
-		logger.Error("%v", err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(x509DerEncoded)+aead.Overhead())
 
 	_, err = rand.Read(nonce)
 	if err != nil {
-		logger.Error("%v", err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	encrypted := aead.Seal(nonce, nonce, x509DerEncoded, nil)
-	nonce, ciphertext := encrypted[:aead.NonceSize()], encrypted[aead.NonceSize():]
-	data.WriteKey(ciphertext)
-	data.WriteKeyNonce(nonce)
-
-	return ecKey, nil
+	return encrypted[:aead.NonceSize()], encrypted[aead.NonceSize():], nil
 }
@@ -0,0 +1,54 @@
+package ca
+
+import (
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// The OpenSSH key revocation list format, see PROTOCOL.krl of OpenSSH.
+const (
+	krlMagic                 = "SSHKRL\n\x00"
+	krlFormatVersion         = 1
+	krlSectionCertificates   = 1
+	krlSectionCertSerialList = 0x20
+)
+
+// marshalKRL returns an unsigned KRL revoking the certificates with the serials issued by the CA key.
+func marshalKRL(caKey ssh.PublicKey, serials []uint64, version uint64, generated time.Time, comment string) []byte {
+	b := []byte(krlMagic)
+	b = binary.BigEndian.AppendUint32(b, krlFormatVersion)
+	b = binary.BigEndian.AppendUint64(b, version)
+	b = binary.BigEndian.AppendUint64(b, uint64(generated.Unix()))
+	b = binary.BigEndian.AppendUint64(b, 0) // flags
+	b = appendSSHString(b, nil)             // reserved
+	b = appendSSHString(b, []byte(comment))
+
+	if len(serials) == 0 {
+		return b
+	}
+
+	sorted := append([]uint64(nil), serials...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var list []byte
+	for _, serial := range sorted {
+		list = binary.BigEndian.AppendUint64(list, serial)
+	}
+
+	section := appendSSHString(nil, caKey.Marshal())
+	section = appendSSHString(section, nil) // reserved
+	section = append(section, krlSectionCertSerialList)
+	section = appendSSHString(section, list)
+
+	b = append(b, krlSectionCertificates)
+	return appendSSHString(b, section)
+}
+
+// appendSSHString appends the data as SSH string, prefixed with its length.
+func appendSSHString(b []byte, data []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
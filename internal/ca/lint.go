@@ -0,0 +1,77 @@
+package ca
+
+import (
+	"crypto/x509"
+	"fmt"
+	"slices"
+
+	"deleteonerror.com/tyinypki/internal/lint"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// LintError is returned when lint findings with a blocking severity stop a certificate or CRL from being signed.
+type LintError struct {
+	Findings []model.LintFinding
+}
+
+func (e *LintError) Error() string {
+	if len(e.Findings) == 1 {
+		return fmt.Sprintf("lint %s: %s", e.Findings[0].Lint, e.Findings[0].Message)
+	}
+	return fmt.Sprintf("lint %s: %s (and %d more findings)", e.Findings[0].Lint, e.Findings[0].Message, len(e.Findings)-1)
+}
+
+// checkLint logs the findings for the certificate or CRL with the name and returns a LintError
+// if a finding has a blocking severity. The reserved number of a blocked operation stays unused.
+func (a *Authority) checkLint(name string, findings []model.LintFinding) error {
+	for _, f := range findings {
+		switch f.Severity {
+		case model.LintError:
+			a.log.Error("Lint %s of %s: %s", f.Lint, name, f.Message)
+		case model.LintWarning:
+			a.log.Warning("Lint %s of %s: %s", f.Lint, name, f.Message)
+		default:
+			a.log.Info("Lint %s of %s: %s", f.Lint, name, f.Message)
+		}
+	}
+
+	blocking := a.BlockingLint(findings)
+	if len(blocking) == 0 {
+		return nil
+	}
+	if err := a.finishOperation(); err != nil {
+		a.log.Error("%v", err)
+	}
+	return &LintError{Findings: blocking}
+}
+
+// BlockingLint returns the findings with a severity which stops signing.
+func (a *Authority) BlockingLint(findings []model.LintFinding) []model.LintFinding {
+	return lint.Blocking(findings, a.getConfiguration().LintBlocking)
+}
+
+// LintCertificate checks a certificate issued by this CA with the rules applied before signing.
+func (a *Authority) LintCertificate(cert *x509.Certificate) []model.LintFinding {
+	issuer := a.getCaCertificate()
+	if cert.Equal(&issuer) {
+		return lint.Certificate(cert, cert, cert.PublicKey)
+	}
+	return lint.Certificate(cert, &issuer, cert.PublicKey)
+}
+
+// SetLintBlocking sets the lowest severity of lint findings which stops signing, none signs despite all findings.
+func (a *Authority) SetLintBlocking(level string) error {
+	if level != "none" && !slices.Contains(model.LintSeverities, level) {
+		return fmt.Errorf("unknown lint severity %s", level)
+	}
+	conf := a.getConfiguration()
+	if len(conf.Name) == 0 {
+		return fmt.Errorf("no ca configuration found")
+	}
+	conf.LintBlocking = level
+	if err := a.updateConfiguration(conf); err != nil {
+		return err
+	}
+	a.log.Info("Lint findings with severity %s and above block signing", level)
+	return nil
+}
@@ -0,0 +1,131 @@
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// registerSubAuthority adds an issued CA certificate to the registry of subordinate CAs.
+func (a *Authority) registerSubAuthority(cert *x509.Certificate, file string, cross bool) error {
+	subs, err := a.store.ReadSubCaRegistry()
+	if err != nil {
+		a.log.Error("%v", err)
+		return err
+	}
+
+	for _, sub := range subs {
+		if sub.Serial != nil && sub.Serial.Cmp(cert.SerialNumber) == 0 {
+			a.log.Debug("%s with serial %s is already registered", sub.Name, sub.Serial)
+			return nil
+		}
+	}
+
+	subs = append(subs, model.SubCa{
+		Name:         cert.Subject.CommonName,
+		Serial:       new(big.Int).Set(cert.SerialNumber),
+		SubjectKeyId: hex.EncodeToString(cert.SubjectKeyId),
+		NotBefore:    cert.NotBefore,
+		NotAfter:     cert.NotAfter,
+		File:         filepath.Base(file),
+		Cross:        cross,
+	})
+
+	return a.store.WriteSubCaRegistry(subs)
+}
+
+// ListSubAuthorities returns all CA certificates issued by this CA.
+func (a *Authority) ListSubAuthorities() ([]model.SubCa, error) {
+	return a.store.ReadSubCaRegistry()
+}
+
+// RevokeSubAuthority revokes the CA certificate with the given common name or serial number
+// and publishes a new revocation list with the given reason.
+func (a *Authority) RevokeSubAuthority(nameOrSerial string, reason string) error {
+	reasonCode, err := ParseRevocationReason(reason)
+	if err != nil {
+		return err
+	}
+
+	subs, err := a.store.ReadSubCaRegistry()
+	if err != nil {
+		return err
+	}
+
+	index, err := findSubAuthority(subs, nameOrSerial)
+	if err != nil {
+		return err
+	}
+	sub := subs[index]
+	if sub.IsRevoked() {
+		return fmt.Errorf("%s (serial %s) is already revoked", sub.Name, sub.Serial)
+	}
+
+	return a.Revoke(sub.Serial, reasonCode)
+}
+
+// markSubAuthorityRevoked records the revocation in the registry if the serial belongs to a sub CA.
+func (a *Authority) markSubAuthorityRevoked(serial *big.Int, reason int, revokedAt time.Time) error {
+	subs, err := a.store.ReadSubCaRegistry()
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for i, sub := range subs {
+		if sub.Serial != nil && sub.Serial.Cmp(serial) == 0 && !sub.IsRevoked() {
+			subs[i].RevokedAt = &revokedAt
+			subs[i].Reason = RevocationReasonName(reason)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return a.store.WriteSubCaRegistry(subs)
+}
+
+func findSubAuthority(subs []model.SubCa, nameOrSerial string) (int, error) {
+	serial, isSerial := new(big.Int).SetString(nameOrSerial, 0)
+
+	found := -1
+	for i, sub := range subs {
+		if (isSerial && sub.Serial != nil && sub.Serial.Cmp(serial) == 0) || strings.EqualFold(sub.Name, nameOrSerial) {
+			if found >= 0 && !subs[found].IsRevoked() && !sub.IsRevoked() {
+				return -1, fmt.Errorf("%s is ambiguous, use the serial number", nameOrSerial)
+			}
+			if found < 0 || subs[found].IsRevoked() {
+				found = i
+			}
+		}
+	}
+	if found < 0 {
+		return -1, fmt.Errorf("no sub ca %s found", nameOrSerial)
+	}
+	return found, nil
+}
+
+// checkSubAuthorities warns about sub CA certificates which expire within the given number of days.
+func (a *Authority) checkSubAuthorities(days int) {
+	subs, err := a.store.ReadSubCaRegistry()
+	if err != nil {
+		a.log.Error("Unable to read sub ca registry: %v", err)
+		return
+	}
+
+	limit := a.now().AddDate(0, 0, days)
+	for _, sub := range subs {
+		if sub.IsRevoked() || sub.NotAfter.Before(a.now()) {
+			continue
+		}
+		if sub.NotAfter.Before(limit) {
+			a.log.Warning("Sub Ca %s (serial %s) will expire in less than %d days.", sub.Name, sub.Serial, days)
+		}
+	}
+}
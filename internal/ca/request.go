@@ -4,10 +4,10 @@ import (
 	"crypto/x509"
 	"encoding/asn1"
 	"errors"
-	"fmt"
 
 	"deleteonerror.com/tyinypki/internal/data"
 	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/request"
 	"deleteonerror.com/tyinypki/internal/terminal"
 )
 
@@ -50,6 +50,28 @@ func getKeyUsage(csr x509.CertificateRequest) (x509.KeyUsage, error) {
 	return x509.KeyUsageDigitalSignature, errors.New("no keyusage found")
 }
 
+// getRequestedPathLen returns the path length asked for in the basic constraints of a CA request.
+func getRequestedPathLen(csr x509.CertificateRequest) (int, bool) {
+	for _, ext := range csr.Extensions {
+
+		if ext.Id.String() == "2.5.29.19" {
+
+			var bc request.BasicConstraints
+			_, err := asn1.Unmarshal(ext.Value, &bc)
+			if err != nil {
+				logger.Error("Error unmarshaling basic constraints: %v", err)
+				return 0, false
+			}
+			if !bc.IsCA || bc.MaxPathLen < 0 {
+				return 0, false
+			}
+
+			return bc.MaxPathLen, true
+		}
+	}
+	return 0, false
+}
+
 func getExtKeyUsage(csr x509.CertificateRequest) ([]x509.ExtKeyUsage, error) {
 	for _, ext := range csr.Extensions {
 
@@ -58,14 +80,14 @@ func getExtKeyUsage(csr x509.CertificateRequest) ([]x509.ExtKeyUsage, error) {
 			var keyUsages []asn1.ObjectIdentifier
 			_, err := asn1.Unmarshal(ext.Value, &keyUsages)
 			if err != nil {
-				fmt.Println("Error decoding extKeyUsage: ", err)
+				logger.Error("Error decoding extKeyUsage: %v", err)
 				return nil, err
 			}
 
 			//ref: https://www.iana.org/assignments/smi-numbers/smi-numbers.xhtml#smi-numbers-1.3.6.1.5.5.7.3
 			extKeyUsages := []x509.ExtKeyUsage{}
 			for _, ku := range keyUsages {
-				fmt.Printf("%v\n", ku)
+				logger.Debug("Requested extended key usage %s", ku)
 				switch {
 				case ku.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 1}):
 					extKeyUsages = append(extKeyUsages, x509.ExtKeyUsageServerAuth)
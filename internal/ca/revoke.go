@@ -2,39 +2,99 @@ package ca
 
 import (
 	"bytes"
-	"time"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
 
-	"deleteonerror.com/tyinypki/internal/data"
-	"deleteonerror.com/tyinypki/internal/logger"
 	"deleteonerror.com/tyinypki/internal/model"
 )
 
-func RevokeCertificates() {
+// Revoke revokes the issued certificate with the given serial number and publishes a new CRL.
+// reason is a RFC 5280 reason code, see ParseRevocationReason.
+func (a *Authority) Revoke(serial *big.Int, reason int) error {
+	if RevocationReasonName(reason) == "unspecified" && reason != 0 {
+		return fmt.Errorf("unknown revocation reason %d", reason)
+	}
+	if _, err := a.signingKey(); err != nil {
+		return err
+	}
+	a.getConfiguration()
+	ca := a.getCaCertificate()
+	if len(ca.Raw) == 0 {
+		return errors.New("no ca certificate found")
+	}
 
-	rawCerts, err := data.GetNewRevokations()
+	revoked, err := a.isRevoked(serial)
 	if err != nil {
-		logger.Error("%v", err)
+		return err
+	}
+	if revoked {
+		return fmt.Errorf("serial %s is already revoked", serial)
 	}
 
-	count, err := importRevocations(rawCerts)
+	issued, err := a.store.GetIssuedCertificatesFromCaStore()
 	if err != nil {
-		logger.Error("%v", err)
+		return err
+	}
+	for _, f := range issued {
+		cert, err := parseCertificate(f.Data)
+		if err != nil || cert.SerialNumber.Cmp(serial) != 0 {
+			continue
+		}
+		if cert.Equal(&ca) {
+			return errors.New("the ca certificate can not be revoked by the ca itself")
+		}
+
+		now := a.now().UTC()
+		_, err = a.store.WriteRawRevokedCertificate(cert.Raw, now.Format("2006-01-02_15-04-05_")+strings.TrimSuffix(f.Name, ".cer"))
+		if err != nil {
+			return err
+		}
+
+		err = a.addRevocation(model.Revocation{Serial: cert.SerialNumber, Reason: reason, RevokedAt: now})
+		if err != nil {
+			return err
+		}
+
+		err = a.markSubAuthorityRevoked(cert.SerialNumber, reason, now)
+		if err != nil {
+			return err
+		}
+		a.log.Info("Revoked %s (serial %s), reason %s", cert.Subject.CommonName, serial, RevocationReasonName(reason))
+
+		return a.publishRevocationList()
+	}
+
+	return fmt.Errorf("no issued certificate with serial %s found", serial)
+}
+
+func (a *Authority) RevokeCertificates() {
+
+	rawCerts, err := a.store.GetNewRevokations()
+	if err != nil {
+		a.log.Error("%v", err)
+	}
+
+	count, err := a.importRevocations(rawCerts)
+	if err != nil {
+		a.log.Error("%v", err)
 	}
 	if count > 0 {
-		err = PublishRevocationList()
+		err = a.publishRevocationList()
 		if err != nil {
-			logger.Error("%v", err)
+			a.log.Error("%v", err)
 		}
 	}
 }
 
-func importRevocations(certificates []model.FileContentWithPath) (int, error) {
-	ca := getCaCertificate()
+func (a *Authority) importRevocations(certificates []model.FileContentWithPath) (int, error) {
+	ca := a.getCaCertificate()
 	count := 0
 
 	for _, cert := range certificates {
 		certData, err := parseCertificate(cert.Data)
-		if err != nil || len(certData.Raw) == 0 || certData.NotAfter.Before(time.Now()) {
+		if err != nil || len(certData.Raw) == 0 || certData.NotAfter.Before(a.now()) {
 			continue
 		}
 
@@ -42,7 +102,7 @@ func importRevocations(certificates []model.FileContentWithPath) (int, error) {
 			continue
 		}
 
-		err = data.ImportRevokedCertificate(cert)
+		err = a.store.ImportRevokedCertificate(cert)
 		if err != nil {
 			continue
 		}
@@ -51,3 +111,50 @@ func importRevocations(certificates []model.FileContentWithPath) (int, error) {
 
 	return count, nil
 }
+
+// revocationReasons maps the RFC 5280 CRLReason names to their codes, 7 is not used.
+var revocationReasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"cACompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"removeFromCRL":        8,
+	"privilegeWithdrawn":   9,
+	"aACompromise":         10,
+}
+
+// ParseRevocationReason returns the reason code for a RFC 5280 reason name, an empty name is unspecified.
+func ParseRevocationReason(name string) (int, error) {
+	if name == "" {
+		return 0, nil
+	}
+	for reason, code := range revocationReasons {
+		if strings.EqualFold(reason, name) {
+			return code, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown revocation reason %s", name)
+}
+
+// RevocationReasonName returns the RFC 5280 name of a reason code.
+func RevocationReasonName(code int) string {
+	for reason, c := range revocationReasons {
+		if c == code {
+			return reason
+		}
+	}
+	return "unspecified"
+}
+
+func (a *Authority) addRevocation(revocation model.Revocation) error {
+	revocations, err := a.store.ReadRevocations()
+	if err != nil {
+		a.log.Error("%v", err)
+		return err
+	}
+
+	return a.store.WriteRevocations(append(revocations, revocation))
+}
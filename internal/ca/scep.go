@@ -0,0 +1,218 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// SCEPProfiles are the profiles a SCEP challenge may issue.
+var SCEPProfiles = []string{"client", "server"}
+
+// scepRA is the RA key and certificate SCEP clients encrypt their requests to, the CA key
+// is an ECDSA key and can not decrypt.
+type scepRA struct {
+	cert *x509.Certificate
+	key  *rsa.PrivateKey
+}
+
+// SetupSCEPAuthority creates a RSA key for SCEP, encrypted with the passphrase of the CA, and issues
+// the RA certificate for it. An existing RA key and certificate are replaced, so it also renews the RA.
+func (a *Authority) SetupSCEPAuthority() error {
+	if len(a.pass) == 0 {
+		return errors.New("the scep ra key needs a passphrase")
+	}
+	if _, err := a.signingKey(); err != nil {
+		return err
+	}
+	conf := a.getConfiguration()
+	if len(a.getCaCertificate().Raw) == 0 {
+		return errors.New("no ca certificate found")
+	}
+
+	// 2048 bit, many SCEP clients do not support larger keys
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+
+	csr := &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName:         conf.Name + " SCEP RA",
+			Country:            []string{conf.Country},
+			Organization:       []string{conf.Organization},
+			OrganizationalUnit: []string{conf.OrganizationalUnit},
+		},
+		PublicKey: &key.PublicKey,
+	}
+	cert, err := a.createCertificate(csr, "", x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := a.finishOperation(); err != nil {
+		return err
+	}
+
+	nonce, encrypted, err := encryptPrivateKey(a.pass, x509.MarshalPKCS1PrivateKey(key))
+	if err != nil {
+		return err
+	}
+	if err := a.store.WriteSCEPKey(encrypted); err != nil {
+		return err
+	}
+	if err := a.store.WriteSCEPKeyNonce(nonce); err != nil {
+		return err
+	}
+	if err := a.store.WriteSCEPCertificate(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})); err != nil {
+		return err
+	}
+	a.scep = scepRA{cert: cert, key: key}
+
+	a.log.Info("SCEP RA certificate issued (serial %s), valid until %s", cert.SerialNumber, cert.NotAfter.UTC().Format(time.RFC3339))
+	return nil
+}
+
+// SCEPRecipient returns the RA certificate and its key, the key is decrypted on first use.
+func (a *Authority) SCEPRecipient() (*x509.Certificate, *rsa.PrivateKey, error) {
+	raw, err := a.store.ReadSCEPCertificate()
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := parseCertificate(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	// another process may have renewed the RA
+	if a.scep.cert != nil && a.scep.cert.Equal(&cert) {
+		return a.scep.cert, a.scep.key, nil
+	}
+
+	nonce, err := a.store.ReadSCEPKeyNonce()
+	if err != nil {
+		return nil, nil, err
+	}
+	encrypted, err := a.store.ReadSCEPKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := decryptPrivateKey(a.pass, nonce, encrypted)
+	if err != nil {
+		return nil, nil, errors.New("could not decrypt the scep ra key, wrong passphrase or corrupted key file")
+	}
+	key, err := x509.ParsePKCS1PrivateKey(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		return nil, nil, errors.New("the scep ra key does not match the ra certificate")
+	}
+
+	a.scep = scepRA{cert: &cert, key: key}
+	return a.scep.cert, a.scep.key, nil
+}
+
+// NewSCEPChallenge creates a one time challenge password for a request with the profile.
+// With approval the request waits for operators like every request, see ApproveRequest.
+func (a *Authority) NewSCEPChallenge(profile string, validity time.Duration, approval bool) (string, error) {
+	if !slices.Contains(SCEPProfiles, profile) {
+		return "", fmt.Errorf("profile %s can not be used with scep", profile)
+	}
+	if validity <= 0 {
+		return "", errors.New("the validity of a challenge must be positive")
+	}
+
+	secret := make([]byte, 12)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	password := hex.EncodeToString(secret)
+
+	challenges, err := a.activeSCEPChallenges()
+	if err != nil {
+		return "", err
+	}
+	now := a.now().UTC()
+	challenges = append(challenges, model.SCEPChallenge{
+		Hash:     hashChallenge(password),
+		Profile:  profile,
+		Approval: approval,
+		Created:  now,
+		Expires:  now.Add(validity),
+	})
+	if err := a.store.WriteSCEPChallenges(challenges); err != nil {
+		return "", err
+	}
+
+	a.log.Info("SCEP challenge for profile %s created, valid until %s", profile, now.Add(validity).Format(time.RFC3339))
+	return password, nil
+}
+
+// SCEPChallenge returns the challenge of the password. It stays valid until it is consumed with
+// UseSCEPChallenge, a request which fails gets it back with RestoreSCEPChallenge.
+func (a *Authority) SCEPChallenge(password string) (model.SCEPChallenge, error) {
+	challenges, err := a.activeSCEPChallenges()
+	if err != nil {
+		return model.SCEPChallenge{}, err
+	}
+
+	hash := hashChallenge(password)
+	for _, c := range challenges {
+		if subtle.ConstantTimeCompare([]byte(c.Hash), []byte(hash)) == 1 {
+			return c, nil
+		}
+	}
+	return model.SCEPChallenge{}, errors.New("unknown or expired challenge password")
+}
+
+// UseSCEPChallenge consumes the challenge before its request is issued or queued, a challenge is valid for one request.
+// It fails if the challenge is already used or expired.
+func (a *Authority) UseSCEPChallenge(challenge model.SCEPChallenge) error {
+	challenges, err := a.activeSCEPChallenges()
+	if err != nil {
+		return err
+	}
+	unused := slices.DeleteFunc(slices.Clone(challenges), func(c model.SCEPChallenge) bool { return c.Hash == challenge.Hash })
+	if len(unused) == len(challenges) {
+		return errors.New("the challenge password is already used or expired")
+	}
+	return a.store.WriteSCEPChallenges(unused)
+}
+
+// RestoreSCEPChallenge makes a challenge consumed by UseSCEPChallenge valid again after its request failed,
+// the client may send a corrected request. An expired challenge is not restored.
+func (a *Authority) RestoreSCEPChallenge(challenge model.SCEPChallenge) error {
+	challenges, err := a.activeSCEPChallenges()
+	if err != nil {
+		return err
+	}
+	if a.now().After(challenge.Expires) || slices.ContainsFunc(challenges, func(c model.SCEPChallenge) bool { return c.Hash == challenge.Hash }) {
+		return nil
+	}
+	return a.store.WriteSCEPChallenges(append(challenges, challenge))
+}
+
+// activeSCEPChallenges returns the challenges which are not expired.
+func (a *Authority) activeSCEPChallenges() ([]model.SCEPChallenge, error) {
+	challenges, err := a.store.ReadSCEPChallenges()
+	if err != nil {
+		return nil, err
+	}
+	now := a.now()
+	return slices.DeleteFunc(challenges, func(c model.SCEPChallenge) bool { return now.After(c.Expires) }), nil
+}
+
+func hashChallenge(password string) string {
+	hash := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,60 @@
+package ca
+
+import (
+	"testing"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/data"
+)
+
+func TestSCEPChallengeUse(t *testing.T) {
+	a := newTestAuthority(t, data.NewMemoryStore())
+	password, err := a.NewSCEPChallenge("server", time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// looking the challenge up, e.g. for a request which fails, does not use it up
+	for i := 0; i < 2; i++ {
+		challenge, err := a.SCEPChallenge(password)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if challenge.Profile != "server" {
+			t.Errorf("profile = %s, want server", challenge.Profile)
+		}
+	}
+
+	challenge, _ := a.SCEPChallenge(password)
+	if err := a.UseSCEPChallenge(challenge); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.SCEPChallenge(password); err == nil {
+		t.Error("the challenge is valid after its use")
+	}
+	// a second request with the challenge, e.g. sent at the same time, is not issued
+	if err := a.UseSCEPChallenge(challenge); err == nil {
+		t.Error("the challenge is consumed twice")
+	}
+
+	// the request failed, the challenge is valid again
+	if err := a.RestoreSCEPChallenge(challenge); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.SCEPChallenge(password); err != nil {
+		t.Errorf("the restored challenge is not valid: %v", err)
+	}
+	if err := a.UseSCEPChallenge(challenge); err != nil {
+		t.Fatal(err)
+	}
+
+	// a challenge which expired meanwhile stays used
+	a.now = func() time.Time { return challenge.Expires.Add(time.Second) }
+	if err := a.RestoreSCEPChallenge(challenge); err != nil {
+		t.Fatal(err)
+	}
+	a.now = time.Now
+	if _, err := a.SCEPChallenge(password); err == nil {
+		t.Error("an expired challenge is restored")
+	}
+}
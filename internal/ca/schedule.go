@@ -0,0 +1,67 @@
+package ca
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// crlValidity is the time from this update to the next update of a CRL.
+const crlValidity = 120 * 24 * time.Hour
+
+// defaultCRLRefreshDays is the refresh margin of CAs without crl_refresh_days in the configuration.
+const defaultCRLRefreshDays = 30
+
+// CRLRefreshMargin returns the time before the next update of the last CRL at which a new CRL is published.
+func (a *Authority) CRLRefreshMargin() time.Duration {
+	margin := time.Duration(a.getConfiguration().CRLRefreshDays) * 24 * time.Hour
+	if margin <= 0 || margin >= crlValidity {
+		return defaultCRLRefreshDays * 24 * time.Hour
+	}
+	return margin
+}
+
+// CRLDue returns the time at which the last CRL is due for republication and if this time has come.
+// Without a published CRL a CRL is due at once.
+func (a *Authority) CRLDue() (time.Time, bool, error) {
+	crl, err := a.getLatestCRL()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if crl == nil {
+		return a.now(), true, nil
+	}
+	due := crl.NextUpdate.Add(-a.CRLRefreshMargin())
+	return due, !a.now().Before(due), nil
+}
+
+// RepublishCRLIfDue publishes a new CRL if the last one is due and reports if it did.
+func (a *Authority) RepublishCRLIfDue() (bool, error) {
+	due, isDue, err := a.CRLDue()
+	if err != nil || !isDue {
+		return false, err
+	}
+	a.log.Warning("CRL is due for republication since %s", due.UTC().Format(time.RFC3339))
+	if _, err := a.GenerateCRL(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetCRLRefreshDays sets how many days before the next update of the last CRL a new CRL is published.
+func (a *Authority) SetCRLRefreshDays(days int) error {
+	maxDays := int(crlValidity/(24*time.Hour)) - 1
+	if days < 1 || days > maxDays {
+		return fmt.Errorf("the refresh margin must be between 1 and %d days", maxDays)
+	}
+	conf := a.getConfiguration()
+	if len(conf.Name) == 0 {
+		return errors.New("no ca configuration found")
+	}
+	conf.CRLRefreshDays = days
+	if err := a.updateConfiguration(conf); err != nil {
+		return err
+	}
+	a.log.Info("CRLs are republished %d days before their next update", days)
+	return nil
+}
@@ -0,0 +1,388 @@
+package ca
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/model"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultSSHProfiles are written with the setup of the SSH CA, they are changed in ssh/ssh.json of the store.
+var defaultSSHProfiles = map[string]model.SSHProfile{
+	model.SSHUser: {
+		Validity:   "24h",
+		Principals: []string{"{name}"},
+		Extensions: map[string]string{
+			"permit-X11-forwarding":   "",
+			"permit-agent-forwarding": "",
+			"permit-port-forwarding":  "",
+			"permit-pty":              "",
+			"permit-user-rc":          "",
+		},
+	},
+	model.SSHHost: {
+		Validity:   "8760h",
+		Principals: []string{"{name}"},
+	},
+}
+
+// SetupSSHAuthority creates the SSH CA key, encrypted with the passphrase of the CA, writes the
+// default profiles and publishes the public key of the SSH CA and an empty KRL.
+func (a *Authority) SetupSSHAuthority() error {
+	if a.store.IsSSHConfigured() {
+		return errors.New("the ssh ca is already configured")
+	}
+	if len(a.pass) == 0 {
+		return errors.New("the ssh ca key needs a passphrase")
+	}
+	if _, err := a.signingKey(); err != nil {
+		return err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	nonce, encrypted, err := encryptPrivateKey(a.pass, der)
+	if err != nil {
+		return err
+	}
+	if err := a.store.WriteSSHKey(encrypted); err != nil {
+		return err
+	}
+	if err := a.store.WriteSSHKeyNonce(nonce); err != nil {
+		return err
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return err
+	}
+	a.sshSigner = signer
+
+	conf, err := a.store.ReadSSHConfiguration()
+	if err != nil {
+		return err
+	}
+	if conf.Profiles == nil {
+		conf.Profiles = defaultSSHProfiles
+	}
+	if err := a.store.WriteSSHConfiguration(conf); err != nil {
+		return err
+	}
+
+	file, err := a.store.WriteSSHPublicKey(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	if err != nil {
+		return err
+	}
+	if err := a.store.Publish(file, a.getConfiguration().Name+".ssh.pub"); err != nil {
+		return err
+	}
+
+	a.log.Info("SSH CA created, fingerprint %s", ssh.FingerprintSHA256(signer.PublicKey()))
+	return a.publishKRL()
+}
+
+// SSHPublicKey returns the public key of the SSH CA.
+func (a *Authority) SSHPublicKey() (ssh.PublicKey, error) {
+	signer, err := a.sshSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	return signer.PublicKey(), nil
+}
+
+// sshSigningKey returns the SSH CA key, it is decrypted on first use.
+func (a *Authority) sshSigningKey() (ssh.Signer, error) {
+	if a.sshSigner != nil {
+		return a.sshSigner, nil
+	}
+
+	nonce, err := a.store.ReadSSHKeyNonce()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := a.store.ReadSSHKey()
+	if err != nil {
+		return nil, err
+	}
+	der, err := decryptPrivateKey(a.pass, nonce, encrypted)
+	if err != nil {
+		return nil, errors.New("could not decrypt the ssh ca key, wrong passphrase or corrupted key file")
+	}
+	key, err := x509.ParseECPrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	a.sshSigner, err = ssh.NewSignerFromKey(key)
+	return a.sshSigner, err
+}
+
+// SignSSHKey signs a user or host certificate for the public key with the profile of the certificate type.
+// The name is the key id of the certificate and replaces {name} in the principals of the profile.
+// If approval is required the key is queued instead and a *PendingError is returned, see ApproveRequest.
+func (a *Authority) SignSSHKey(pub ssh.PublicKey, certType string, name string) (*ssh.Certificate, error) {
+	if _, ok := pub.(*ssh.Certificate); ok {
+		return nil, errors.New("the key is already a certificate")
+	}
+	if !a.ApprovalRequired() {
+		return a.signSSHKey(pub, certType, name)
+	}
+
+	queued, err := a.queueRequest(model.RequestRecord{
+		Profile:   sshProfile(certType),
+		Subject:   name,
+		Requester: sshProfile(certType),
+		Request:   ssh.MarshalAuthorizedKey(pub),
+	})
+	if err != nil {
+		return nil, err
+	}
+	a.log.Info("Request %s for ssh %s certificate %s waits for %d approval(s)", queued.ID, certType, name, a.RequiredApprovals(queued))
+	return nil, &PendingError{Record: queued}
+}
+
+// sshProfile returns the profile of a queued SSH public key of the certificate type, the name of its request folder.
+func sshProfile(certType string) string {
+	return "ssh-" + certType
+}
+
+func (a *Authority) signSSHKey(pub ssh.PublicKey, certType string, name string) (*ssh.Certificate, error) {
+	if _, ok := pub.(*ssh.Certificate); ok {
+		return nil, errors.New("the key is already a certificate")
+	}
+
+	conf, err := a.store.ReadSSHConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	profile, ok := conf.Profiles[certType]
+	if !ok {
+		return nil, fmt.Errorf("unknown ssh certificate type %s", certType)
+	}
+	validity, err := time.ParseDuration(profile.Validity)
+	if err != nil || validity <= 0 {
+		return nil, fmt.Errorf("invalid validity %q in the ssh %s profile", profile.Validity, certType)
+	}
+	principals := profile.ExpandPrincipals(name)
+	if len(principals) == 0 {
+		return nil, fmt.Errorf("the ssh %s profile has no principals", certType)
+	}
+	// OpenSSH defines no critical options or extensions for host certificates
+	if certType == model.SSHHost && (len(profile.CriticalOptions) > 0 || len(profile.Extensions) > 0) {
+		return nil, fmt.Errorf("the ssh %s profile has critical options or extensions, host certificates have none", certType)
+	}
+
+	signer, err := a.sshSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// the serial is reserved before signing, it is never used twice
+	conf.LastSerial++
+	if err := a.store.WriteSSHConfiguration(conf); err != nil {
+		return nil, err
+	}
+
+	now := a.now()
+	cert := &ssh.Certificate{
+		Key:             pub,
+		Serial:          conf.LastSerial,
+		CertType:        ssh.UserCert,
+		KeyId:           name,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Unix()),
+		ValidBefore:     uint64(now.Add(validity).Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: profile.CriticalOptions,
+			Extensions:      profile.Extensions,
+		},
+	}
+	if certType == model.SSHHost {
+		cert.CertType = ssh.HostCert
+	}
+
+	if err := cert.SignCert(rand.Reader, signer); err != nil {
+		return nil, err
+	}
+
+	filename := fmt.Sprintf("%s_%d", strings.ReplaceAll(name, string(filepath.Separator), "_"), cert.Serial)
+	file, err := a.store.WriteSSHCertificate(ssh.MarshalAuthorizedKey(cert), filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.store.Issued(file); err != nil {
+		return nil, err
+	}
+
+	a.log.Info("Signed ssh %s certificate %s (serial %d) for %s, valid until %s",
+		certType, name, cert.Serial, strings.Join(principals, ", "), now.Add(validity).UTC().Format(time.RFC3339))
+	return cert, nil
+}
+
+// IssuePendingSSHRequests revokes the SSH certificates placed in the revoke folder and signs
+// the public keys in the ssh-user and ssh-host request folders.
+func (a *Authority) IssuePendingSSHRequests() error {
+	if err := a.revokeSSHCertificates(); err != nil {
+		a.log.Error("%v", err)
+	}
+
+	for _, req := range a.store.GetSSHRequests() {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(req.Data)
+		if err != nil {
+			a.log.Debug("Skipped %s, no ssh public key", req.Name)
+			continue
+		}
+
+		_, err = a.SignSSHKey(pub, req.RequestType, strings.TrimSuffix(req.Name, ".pub"))
+		var pending *PendingError
+		if err != nil && !errors.As(err, &pending) {
+			a.log.Error("Failed to sign %s: %v", req.Name, err)
+			continue
+		}
+		a.store.ArchiveRequest(requestSource(req))
+	}
+	return nil
+}
+
+// RevokeSSH revokes the SSH certificate with the serial number and publishes a new KRL.
+// The reason is a RFC 5280 reason code, it is recorded but not part of the KRL.
+func (a *Authority) RevokeSSH(serial uint64, reason int) error {
+	if RevocationReasonName(reason) == "unspecified" && reason != 0 {
+		return fmt.Errorf("unknown revocation reason %d", reason)
+	}
+
+	revocations, err := a.store.ReadSSHRevocations()
+	if err != nil {
+		return err
+	}
+	for _, r := range revocations {
+		if r.Serial == serial {
+			return fmt.Errorf("ssh serial %d is already revoked", serial)
+		}
+	}
+
+	cert, err := a.findSSHCertificate(serial)
+	if err != nil {
+		return err
+	}
+
+	revocations = append(revocations, model.SSHRevocation{Serial: serial, KeyId: cert.KeyId, Reason: reason, RevokedAt: a.now().UTC()})
+	if err := a.store.WriteSSHRevocations(revocations); err != nil {
+		return err
+	}
+	a.log.Info("Revoked ssh certificate %s (serial %d), reason %s", cert.KeyId, serial, RevocationReasonName(reason))
+
+	return a.publishKRL()
+}
+
+// revokeSSHCertificates revokes the SSH certificates of this CA in the revoke folder, the files are removed.
+func (a *Authority) revokeSSHCertificates() error {
+	files, err := a.store.GetNewRevokations()
+	if err != nil {
+		return err
+	}
+	pub, err := a.SSHPublicKey()
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		key, _, _, _, err := ssh.ParseAuthorizedKey(f.Data)
+		if err != nil {
+			continue
+		}
+		cert, ok := key.(*ssh.Certificate)
+		if !ok || !bytes.Equal(cert.SignatureKey.Marshal(), pub.Marshal()) {
+			continue
+		}
+
+		if err := a.RevokeSSH(cert.Serial, 0); err != nil {
+			a.log.Warning("%s: %v", f.Name, err)
+		}
+		if err := a.store.Delete(filepath.Join(f.Path, f.Name)); err != nil {
+			a.log.Error("%v", err)
+		}
+	}
+	return nil
+}
+
+// findSSHCertificate returns the certificate with the serial signed by this SSH CA.
+func (a *Authority) findSSHCertificate(serial uint64) (*ssh.Certificate, error) {
+	pub, err := a.SSHPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	files, err := a.store.GetSSHCertificatesFromCaStore()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		key, _, _, _, err := ssh.ParseAuthorizedKey(f.Data)
+		if err != nil {
+			continue
+		}
+		cert, ok := key.(*ssh.Certificate)
+		if ok && cert.Serial == serial && bytes.Equal(cert.SignatureKey.Marshal(), pub.Marshal()) {
+			return cert, nil
+		}
+	}
+	return nil, fmt.Errorf("no ssh certificate with serial %d found", serial)
+}
+
+// GenerateKRL writes a new key revocation list with all revoked SSH certificates and publishes it.
+func (a *Authority) GenerateKRL() ([]byte, error) {
+	pub, err := a.SSHPublicKey()
+	if err != nil {
+		return nil, err
+	}
+	conf, err := a.store.ReadSSHConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	revocations, err := a.store.ReadSSHRevocations()
+	if err != nil {
+		return nil, err
+	}
+
+	var serials []uint64
+	for _, r := range revocations {
+		serials = append(serials, r.Serial)
+	}
+
+	conf.LastKRLVersion++
+	if err := a.store.WriteSSHConfiguration(conf); err != nil {
+		return nil, err
+	}
+
+	name := a.getConfiguration().Name
+	krl := marshalKRL(pub, serials, conf.LastKRLVersion, a.now(), name)
+	file, err := a.store.WriteKRL(krl)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.store.Publish(file, name+".krl"); err != nil {
+		return nil, err
+	}
+	return krl, nil
+}
+
+func (a *Authority) publishKRL() error {
+	_, err := a.GenerateKRL()
+	return err
+}
@@ -0,0 +1,52 @@
+package ca
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"deleteonerror.com/tyinypki/internal/data"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+func TestSignSSHHostKey(t *testing.T) {
+	a := newTestAuthority(t, data.NewMemoryStore())
+	if err := a.SetupSSHAuthority(); err != nil {
+		t.Fatal(err)
+	}
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := a.SignSSHKey(key, model.SSHHost, "web01.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.CertType != ssh.HostCert || len(cert.CriticalOptions) != 0 || len(cert.Extensions) != 0 {
+		t.Errorf("host certificate has type %d, %v and %v", cert.CertType, cert.CriticalOptions, cert.Extensions)
+	}
+
+	// a host profile with user permissions is refused instead of signing without them
+	conf, err := a.store.ReadSSHConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := conf.Profiles[model.SSHHost]
+	profile.Extensions = map[string]string{"permit-pty": ""}
+	conf.Profiles[model.SSHHost] = profile
+	if err := a.store.WriteSSHConfiguration(conf); err != nil {
+		t.Fatal(err)
+	}
+	_, err = a.SignSSHKey(key, model.SSHHost, "web01.example.com")
+	if err == nil || !strings.Contains(err.Error(), "host certificates have none") {
+		t.Fatalf("SignSSHKey error = %v, want the host profile refused", err)
+	}
+}
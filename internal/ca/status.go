@@ -0,0 +1,89 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"path/filepath"
+	"slices"
+
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// receiveRequest returns the status of a request file of the request folders. The id of the last
+// status is kept if the same request was received before and is not finished.
+func (a *Authority) receiveRequest(req model.FileContentWithPath, profile string) (model.RequestStatus, error) {
+	hash := sha256.Sum256(req.Data)
+	status := model.RequestStatus{
+		Request:     req.Name,
+		Fingerprint: hex.EncodeToString(hash[:]),
+		Profile:     profile,
+		Status:      model.RequestReceived,
+	}
+
+	last, err := a.store.ReadRequestStatus(requestSource(req))
+	if err != nil {
+		a.log.Warning("Unreadable status of request %s: %v", req.Name, err)
+	}
+	unfinished := []string{model.RequestReceived, model.RequestPending, model.RequestFailed}
+	if last.ID != "" && last.Fingerprint == status.Fingerprint && slices.Contains(unfinished, last.Status) {
+		status.ID = last.ID
+		return status, nil
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return status, err
+	}
+	status.ID = hex.EncodeToString(id)
+	return status, nil
+}
+
+// updateRequestStatus writes the status file of a request of the request folders, errors are only logged
+// as the status file is informational.
+func (a *Authority) updateRequestStatus(source string, status model.RequestStatus) {
+	status.Updated = a.now().UTC()
+	if err := a.store.WriteRequestStatus(source, status); err != nil {
+		a.log.Error("Failed to write the status of request %s: %v", status.Request, err)
+	}
+}
+
+// issuedStatus adds the serial and the files of the issued certificate to the status, the store writes
+// their paths in the out folder.
+func (a *Authority) issuedStatus(status model.RequestStatus, cert *x509.Certificate) model.RequestStatus {
+	filename := cert.Subject.CommonName + "_" + hex.EncodeToString(cert.SubjectKeyId)
+	status.Status = model.RequestIssued
+	status.Reason = ""
+	status.Serial = cert.SerialNumber
+	status.Certificate = filename + ".cer"
+	status.Chain = filename + ".chain.pem"
+	status.Lint = a.LintCertificate(cert)
+	return status
+}
+
+// queuedRequestStatus returns the status of a request of the request folders which was queued for approval,
+// false if the status file belongs to a newer request with the same name.
+func (a *Authority) queuedRequestStatus(record model.RequestRecord) (model.RequestStatus, bool) {
+	if record.Source == "" {
+		return model.RequestStatus{}, false
+	}
+	status, err := a.store.ReadRequestStatus(record.Source)
+	if err != nil {
+		a.log.Warning("Unreadable status of request %s: %v", record.ID, err)
+		return status, false
+	}
+	return status, status.ID == record.ID
+}
+
+// rejectedStatus marks the status as rejected with the reason.
+func rejectedStatus(status model.RequestStatus, reason string) model.RequestStatus {
+	status.Status = model.RequestRejected
+	status.Reason = reason
+	return status
+}
+
+// requestSource returns the reference of a request file in its store, the status file is placed next to it.
+func requestSource(req model.FileContentWithPath) string {
+	return filepath.Join(req.Path, req.Name)
+}
@@ -2,104 +2,138 @@ package ca
 
 import (
 	"crypto/ecdsa"
-	"os"
-	"time"
+	"errors"
+	"path/filepath"
 
-	"deleteonerror.com/tyinypki/internal/data"
-	"deleteonerror.com/tyinypki/internal/logger"
 	"deleteonerror.com/tyinypki/internal/model"
 	"deleteonerror.com/tyinypki/internal/request"
 )
 
-func SetupSubAuthority(initConfig model.Config, pass []byte) error {
-	PassPhrase = pass
+var errKeyMismatch = errors.New("certificate does not match the ca key")
 
-	updateConfiguration(initConfig)
+// SetupSubAuthority creates the sub CA key and places the CA request in the ca-req folder.
+func (a *Authority) SetupSubAuthority(initConfig model.Config) error {
+	a.updateConfiguration(initConfig)
 
-	privateKey, err := createEncryptedPrivateKey(pass)
+	signer, err := a.setupKey()
 	if err != nil {
-		logger.Error("%v", err)
 		return err
 	}
 
-	rawRequest := request.CreateSubCaRequest(cfg.Config, *privateKey)
+	rawRequest := request.CreateSubCaRequest(a.cfg.Config, signer)
 
-	reqFile, err := data.WriteRawRequest(rawRequest, cfg.Config.Name)
+	reqFile, err := a.store.WriteRawRequest(rawRequest, a.cfg.Config.Name)
 	if err != nil {
-		logger.Error("%v", err)
+		a.log.Error("%v", err)
 		return err
 	}
 
-	caIn := data.GetPathByName("ca-cert-in")
-	logger.Info("IMPORTANT: Request create at %s. Place the Issued certificate in %s", reqFile, caIn)
+	caIn := a.store.GetPathByName("ca-cert-in")
+	a.log.Info("IMPORTANT: Request create at %s. Place the Issued certificate in %s", reqFile, caIn)
 	return nil
 }
 
-func VerifySubAuthority(pass []byte) {
-	PassPhrase = pass
-
-	key := getPrivateKey()
-	cert := getCaCertificate()
-	getConfiguration()
-	data.SetupFolders()
+// VerifySubAuthority opens the sub CA and imports its certificate from the ca-cert-in folder if it has none yet.
+// It finishes an interrupted operation, imports new revocations and publishes the first CRL.
+func (a *Authority) VerifySubAuthority() error {
+	if _, err := a.signingKey(); err != nil {
+		return err
+	}
+	cert := a.getCaCertificate()
+	a.getConfiguration()
+	a.store.SetupFolders()
 	if len(cert.Raw) == 0 {
-		certs, err := data.GetIncommingSubCer()
+		certs, err := a.store.GetIncommingSubCer()
 		if err != nil {
-			logger.Warning("No Sub Ca Certificate found.")
-			os.Exit(1)
+			a.log.Warning("No Sub Ca Certificate found.")
+			return err
 		}
 		for _, cer := range certs {
-			xCert, err := parseCertificate(cer.Data)
-			if err != nil || len(xCert.Raw) == 0 {
+			err := a.ImportSubCACertificate(cer.Data)
+			if errors.Is(err, errKeyMismatch) {
+				a.log.Debug("found certificate but public key does not match")
 				continue
 			}
-			ecdsaPubKey, ok := xCert.PublicKey.(*ecdsa.PublicKey)
-			if !ok {
+			if err != nil {
+				a.log.Debug("Skipped %s: %v", cer.Name, err)
 				continue
 			}
-
-			if arePublicKeysEqual(ecdsaPubKey, &key.PublicKey) {
-				logger.Debug("matching certificate found > import")
-
-				_, err := data.WritePemCaCertificate(cer.Data)
-				if err != nil {
-					logger.Error("%v", err)
-				}
-				cert = xCert
-
-				data.Delete(cer.Path)
-				break
-
-			} else {
-				logger.Debug("found certificate but public key does not match")
-			}
+			a.store.Delete(filepath.Join(cer.Path, cer.Name))
+			break
 		}
+		cert = a.getCaCertificate()
 		if len(cert.Raw) == 0 {
-			os.Exit(1)
+			return errors.New("no sub ca certificate found")
 		}
 	}
 
-	if cert.NotAfter.Before(time.Now().AddDate(0, 0, 90)) {
-		logger.Warning("Sub Ca cert will expire in less than 90 days.")
+	if err := a.recoverJournal(); err != nil {
+		a.log.Error("%v", err)
+		return err
+	}
+
+	if err := a.verifyCaChain(); err != nil {
+		a.log.Warning("%v", err)
+	}
+
+	if cert.NotAfter.Before(a.now().AddDate(0, 0, 90)) {
+		a.log.Warning("Sub Ca cert will expire in less than 90 days.")
 	} else {
-		logger.Info("Sub Ca certificate is valid.")
+		a.log.Info("Sub Ca certificate is valid.")
 	}
 
-	crl, err := getLatestCRL()
+	a.RevokeCertificates()
+
+	published, err := a.RepublishCRLIfDue()
 	if err != nil {
-		logger.Error("Unable not read last CRL: %v", err)
+		a.log.Error("%v", err)
+		return err
 	}
-	if crl == nil {
-		logger.Info("No published crls found.")
-		PublishRevocationList()
-		return
+	if !published {
+		a.log.Info("last published crl is valid.")
 	}
+	return nil
+}
 
-	if crl.NextUpdate.Before(time.Now().AddDate(0, 0, 30)) {
-		logger.Warning("CRL will expire in less than 30 days")
-	} else {
-		logger.Info("last published crl is valid.")
+// ImportSubCACertificate imports the certificate issued for this sub CA. raw holds the PEM encoded
+// certificate, optionally followed by its issuer chain. The certificate must match the key of the CA.
+func (a *Authority) ImportSubCACertificate(raw []byte) error {
+	bundle, err := parseCertificates(raw)
+	if err != nil {
+		return err
+	}
+	if len(bundle) == 0 {
+		return errors.New("no certificate found")
+	}
+
+	pub, err := a.publicKey()
+	if err != nil {
+		return err
+	}
+	xCert := bundle[0]
+	ecdsaPubKey, ok := xCert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || !arePublicKeysEqual(ecdsaPubKey, pub) {
+		return errKeyMismatch
+	}
+	a.log.Debug("matching certificate found > import")
+
+	conf := a.getConfiguration()
+	_, err = a.store.WriteRawCaCertificate(xCert.Raw)
+	if err != nil {
+		a.log.Error("%v", err)
+		return err
+	}
+	err = a.store.WriteCaChain(encodeChain(bundle[1:], true))
+	if err != nil {
+		a.log.Error("%v", err)
 	}
+	a.cfg.Certificate = xCert
 
-	RevokeCertificates()
+	err = a.publishCaCertificate(&xCert)
+	if err != nil {
+		a.log.Error("%v", err)
+	}
+
+	a.store.ArchiveRequest(filepath.Join(a.store.GetPathByName("ca-req"), conf.Name+".csr"))
+	return nil
 }
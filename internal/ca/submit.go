@@ -0,0 +1,102 @@
+package ca
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// SubmitRequest issues the request with the profile and records it with a new request id.
+// A request the CA refuses is recorded as rejected with the reason, the error is only set
+// if the record could not be written. If approval is required the request is queued instead.
+func (a *Authority) SubmitRequest(csr *x509.CertificateRequest, profile string, requester string) (model.RequestRecord, error) {
+	record, err := a.newRequestRecord(csr, profile, requester)
+	if err != nil {
+		return model.RequestRecord{}, err
+	}
+
+	cert, err := a.IssueRequest(csr, profile, record, false)
+	var pending *PendingError
+	if errors.As(err, &pending) {
+		return pending.Record, nil
+	}
+	if err == nil {
+		a.log.Info("Issued %s (serial %s) with profile %s for %s, request %s", cert.Subject.CommonName, cert.SerialNumber, profile, requester, record.ID)
+		record.Status = model.RequestIssued
+		record.Serial = cert.SerialNumber
+		record.Lint = a.LintCertificate(cert)
+	} else {
+		a.log.Warning("Request %s of %s rejected: %v", record.ID, requester, err)
+		record.Status = model.RequestRejected
+		record.Reason = err.Error()
+		var lintErr *LintError
+		if errors.As(err, &lintErr) {
+			record.Lint = lintErr.Findings
+		}
+	}
+
+	if err := a.appendRequestRecord(record); err != nil {
+		return model.RequestRecord{}, err
+	}
+	return record, nil
+}
+
+// newRequestRecord returns a record with a new random request id.
+func (a *Authority) newRequestRecord(csr *x509.CertificateRequest, profile string, requester string) (model.RequestRecord, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return model.RequestRecord{}, err
+	}
+
+	return model.RequestRecord{
+		ID:        hex.EncodeToString(id),
+		Profile:   profile,
+		Subject:   csr.Subject.String(),
+		Requester: requester,
+		Received:  a.now().UTC(),
+	}, nil
+}
+
+func (a *Authority) appendRequestRecord(record model.RequestRecord) error {
+	requests, err := a.store.ReadRequestRegistry()
+	if err != nil {
+		return err
+	}
+	return a.store.WriteRequestRegistry(append(requests, record))
+}
+
+// RequestRecord returns the record of a submitted request, false if the id is unknown.
+func (a *Authority) RequestRecord(id string) (model.RequestRecord, bool, error) {
+	requests, err := a.store.ReadRequestRegistry()
+	if err != nil {
+		return model.RequestRecord{}, false, err
+	}
+	for _, r := range requests {
+		if r.ID == id {
+			return r, true, nil
+		}
+	}
+	return model.RequestRecord{}, false, nil
+}
+
+// TransactionRecord returns the last record of a request of the requester with the transaction, false if there is none.
+func (a *Authority) TransactionRecord(requester string, transaction string) (model.RequestRecord, bool, error) {
+	requests, err := a.store.ReadRequestRegistry()
+	if err != nil {
+		return model.RequestRecord{}, false, err
+	}
+	for i := len(requests) - 1; i >= 0; i-- {
+		if requests[i].Requester == requester && requests[i].Transaction == transaction {
+			return requests[i], true, nil
+		}
+	}
+	return model.RequestRecord{}, false, nil
+}
+
+// RequestRecords returns the records of all submitted requests.
+func (a *Authority) RequestRecords() ([]model.RequestRecord, error) {
+	return a.store.ReadRequestRegistry()
+}
@@ -0,0 +1,204 @@
+package ca
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"deleteonerror.com/tyinypki/internal/data"
+)
+
+// The URL templates used if the configuration has none, the names of the published files are the
+// name of the CA.
+const (
+	defaultCdpTemplate = "{base}/{ca_name}.crl"
+	defaultAiaTemplate = "{base}/{ca_name}.cer"
+)
+
+var urlPlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// certificateURLs are the CDP, AIA and OCSP URLs of the certificates issued by the CA.
+type certificateURLs struct {
+	cdp  []string
+	aia  []string
+	ocsp []string
+}
+
+// certificateURLs expands the URL templates of the configuration for the CA key with the subject key identifier.
+func (a *Authority) certificateURLs(keyId []byte) (certificateURLs, error) {
+	conf := a.cfg.Config
+	var urls certificateURLs
+	var err error
+
+	cdp := conf.CdpUrls
+	if len(cdp) == 0 {
+		cdp = []string{defaultCdpTemplate}
+	}
+	if conf.LdapCdp != "" {
+		cdp = append(slices.Clone(cdp), conf.LdapCdp)
+	}
+	if urls.cdp, err = a.expandURLs(cdp, keyId, ".crl"); err != nil {
+		return urls, err
+	}
+
+	aia := conf.AiaUrls
+	if len(aia) == 0 {
+		aia = []string{defaultAiaTemplate}
+	}
+	if urls.aia, err = a.expandURLs(aia, keyId, ".cer", ".crt"); err != nil {
+		return urls, err
+	}
+
+	if urls.ocsp, err = a.expandURLs(conf.OcspUrls, keyId); err != nil {
+		return urls, err
+	}
+	for _, u := range urls.ocsp {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return urls, fmt.Errorf("ocsp url %s is no http URL", u)
+		}
+	}
+	return urls, nil
+}
+
+// crlPublishNames returns the names the CRL is published under, the file names of the http CDP URLs
+// of the configuration and of the valid certificates issued with an earlier configuration.
+func (a *Authority) crlPublishNames() ([]string, error) {
+	urls, err := a.certificateURLs(a.getCaCertificate().SubjectKeyId)
+	if err != nil {
+		return nil, err
+	}
+	issued, err := a.issuedURLs()
+	if err != nil {
+		return nil, err
+	}
+	return publishNames(append(urls.cdp, issued.cdp...), a.cfg.Config.Name+".crl"), nil
+}
+
+// caCertificatePublishNames returns the names the CA certificate with the subject key identifier
+// is published under, the file names of the http AIA URLs of the configuration and of the valid
+// certificates issued with an earlier configuration.
+func (a *Authority) caCertificatePublishNames(keyId []byte) ([]string, error) {
+	urls, err := a.certificateURLs(keyId)
+	if err != nil {
+		return nil, err
+	}
+	issued, err := a.issuedURLs()
+	if err != nil {
+		return nil, err
+	}
+	return publishNames(append(urls.aia, issued.aia...), a.cfg.Config.Name+".cer"), nil
+}
+
+// issuedURLs returns the CDP and AIA URLs of the issued certificates which are not expired, clients
+// follow them until the certificates expire even if the templates of the configuration changed.
+func (a *Authority) issuedURLs() (certificateURLs, error) {
+	var urls certificateURLs
+	files, err := a.store.GetIssuedCertificatesFromCaStore()
+	if err != nil {
+		return urls, err
+	}
+	now := a.now()
+	for _, f := range files {
+		cert, err := parseCertificate(f.Data)
+		if err != nil || now.After(cert.NotAfter) {
+			continue
+		}
+		urls.cdp = append(urls.cdp, cert.CRLDistributionPoints...)
+		urls.aia = append(urls.aia, cert.IssuingCertificateURL...)
+	}
+	return urls, nil
+}
+
+// expandURLs expands the templates, the path of http URLs must end with one of the extensions.
+func (a *Authority) expandURLs(templates []string, keyId []byte, exts ...string) ([]string, error) {
+	var urls []string
+	for _, t := range templates {
+		expanded, err := a.expandURL(t, keyId)
+		if err != nil {
+			return nil, err
+		}
+		u, err := url.Parse(expanded)
+		if err != nil {
+			return nil, err
+		}
+		switch u.Scheme {
+		case "http", "https":
+			if len(exts) > 0 && !slices.Contains(exts, path.Ext(u.Path)) {
+				return nil, fmt.Errorf("url %s does not end with %s", expanded, strings.Join(exts, " or "))
+			}
+		case "ldap":
+		default:
+			return nil, fmt.Errorf("url %s is no http or ldap URL", expanded)
+		}
+		urls = append(urls, expanded)
+	}
+	return urls, nil
+}
+
+// expandURL replaces the placeholders of a URL template. {base} is the base URL, {ca} the name of the CA
+// in lower case with dashes for spaces, {ca_name} the path escaped name, in ldap URLs escaped for a DN
+// first like the DNs of ldap publish targets. {key_id} is the hex encoded key identifier. The CA signs
+// a single full CRL with one key, {partition} and {generation} are 0.
+func (a *Authority) expandURL(template string, keyId []byte) (string, error) {
+	name := a.cfg.Config.Name
+	if strings.HasPrefix(template, "ldap:") {
+		name = data.LDAPEscapeDNValue(name)
+	}
+	values := map[string]string{
+		"{base}":       strings.TrimSuffix(a.cfg.Config.BaseUrl, "/"),
+		"{ca}":         urlSlug(a.cfg.Config.Name),
+		"{ca_name}":    url.PathEscape(name),
+		"{key_id}":     hex.EncodeToString(keyId),
+		"{partition}":  "0",
+		"{generation}": "0",
+	}
+	var unknown []string
+	expanded := urlPlaceholder.ReplaceAllStringFunc(template, func(p string) string {
+		v, ok := values[p]
+		if !ok {
+			unknown = append(unknown, p)
+		}
+		return v
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown placeholder %s in url template %s", strings.Join(unknown, ", "), template)
+	}
+	return expanded, nil
+}
+
+// publishNames returns the distinct file names of the http URLs, the fallback if there are none.
+func publishNames(urls []string, fallback string) []string {
+	var names []string
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		if name := path.Base(u.Path); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return []string{fallback}
+	}
+	return names
+}
+
+// urlSlug returns the name in lower case, spaces become dashes and other characters not allowed
+// unescaped in a URL path are dropped.
+func urlSlug(name string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			sb.WriteRune(r)
+		case r == ' ':
+			sb.WriteRune('-')
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,99 @@
+package ca
+
+import (
+	"bytes"
+	"math/big"
+	"net/url"
+	"strings"
+	"testing"
+
+	"deleteonerror.com/tyinypki/internal/data"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+func TestPublishNamesAfterTemplateChange(t *testing.T) {
+	store := data.NewMemoryStore()
+	a := newTestAuthority(t, store)
+	if _, err := a.Issue(testRequest(t, "host.example.com"), "webserver"); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := store.ReadCaConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.CdpUrls = []string{"{base}/crl/{ca}.crl"}
+	conf.AiaUrls = []string{"{base}/{ca}.crt"}
+	if err := store.WriteCaConfiguration(conf); err != nil {
+		t.Fatal(err)
+	}
+	a, err = New(Options{Store: store, Passphrase: []byte("passphrase123")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.VerifyAuthority(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.GenerateCRL(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the issued certificate still points to the names of the default templates
+	for _, name := range []string{"Test Root.cer", "test-root.crt"} {
+		if _, _, err := store.ReadPublished(name); err != nil {
+			t.Errorf("%s is not published: %v", name, err)
+		}
+	}
+	current, _, err := store.ReadPublished("test-root.crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _, err := store.ReadPublished("Test Root.crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(old, current) {
+		t.Error("the CRL is not published under the name of the earlier template")
+	}
+}
+
+func TestLDAPCDPMatchesPublishedDN(t *testing.T) {
+	const name = "Tiny PKI, Test CA"
+	store := data.NewMemoryStore()
+	store.SetupFolders()
+	a, err := New(Options{Store: store, Passphrase: []byte("passphrase123")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.SetupAuthority(model.Config{
+		Name:             name,
+		BaseUrl:          "http://pki.example.com",
+		LdapCdp:          "ldap:///CN={ca_name},CN=CDP,DC=example,DC=com?certificateRevocationList",
+		LastIssuedSerial: big.NewInt(0),
+		LastCRLNumber:    big.NewInt(0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := a.Issue(testRequest(t, "host.example.com"), "webserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the crl_dn of a ldap publish target with the same template is the entry the certificate names
+	written := data.LDAPEntryDN("CN={ca_name},CN=CDP,DC=example,DC=com", name)
+	for _, raw := range cert.CRLDistributionPoints {
+		u, err := url.Parse(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.Scheme != "ldap" {
+			continue
+		}
+		if dn := strings.TrimPrefix(u.Path, "/"); dn != written {
+			t.Errorf("certificate names the CRL entry %q, the CRL is written to %q", dn, written)
+		}
+		return
+	}
+	t.Fatalf("certificate has no ldap CDP: %v", cert.CRLDistributionPoints)
+}
@@ -0,0 +1,48 @@
+package ca
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// VerifyIssued checks that the certificate was issued by this CA, is valid now and is not revoked.
+func (a *Authority) VerifyIssued(cert *x509.Certificate) error {
+	ca := a.getCaCertificate()
+	if len(ca.Raw) == 0 {
+		return errors.New("no ca certificate found")
+	}
+	if err := cert.CheckSignatureFrom(&ca); err != nil {
+		return fmt.Errorf("not issued by this ca: %w", err)
+	}
+
+	now := a.now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return fmt.Errorf("certificate %s is not valid at %s", cert.SerialNumber, now.UTC().Format("2006-01-02 15:04:05"))
+	}
+
+	revoked, err := a.isRevoked(cert.SerialNumber)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return fmt.Errorf("certificate %s is revoked", cert.SerialNumber)
+	}
+	return nil
+}
+
+// isRevoked reports if a certificate with the serial is in the revoked folder of the store.
+func (a *Authority) isRevoked(serial *big.Int) (bool, error) {
+	revoked, err := a.store.GetRevokedCertificatesFromCaStore()
+	if err != nil {
+		return false, err
+	}
+	for _, f := range revoked {
+		cert, err := parseCertificate(f.Data)
+		if err == nil && cert.SerialNumber.Cmp(serial) == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,187 @@
+package data
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+)
+
+// fileSystem is the storage below the folder layout of a FileStore. Paths are absolute and
+// use the separators of the platform, the errors match those of the os package.
+type fileSystem interface {
+	ReadFile(name string) ([]byte, error)
+	// WriteFile replaces the file at once, readers see either the old or the new content.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	Rename(oldpath string, newpath string) error
+	Remove(name string) error
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	MkdirAll(name string, perm fs.FileMode) error
+	Chmod(name string, mode fs.FileMode) error
+	// Lock takes the advisory lock named by the path, it returns ErrLocked if another holder conflicts.
+	Lock(name string, exclusive bool) (func(), error)
+}
+
+// osFS keeps the files on the disk, every change is synced before it returns.
+type osFS struct{}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return writeFileAtomic(name, data, perm)
+}
+
+func (osFS) Rename(oldpath string, newpath string) error {
+	return renameDurable(oldpath, newpath)
+}
+
+func (osFS) Remove(name string) error {
+	return removeDurable(name)
+}
+
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) MkdirAll(name string, perm fs.FileMode) error {
+	oldUmask := syscall.Umask(0)
+	defer syscall.Umask(oldUmask)
+	return os.MkdirAll(name, perm)
+}
+
+func (osFS) Chmod(name string, mode fs.FileMode) error {
+	return os.Chmod(name, mode)
+}
+
+func (osFS) Lock(name string, exclusive bool) (func(), error) {
+	file, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := tryLock(file, exclusive); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() {
+		unlock(file)
+		file.Close()
+	}, nil
+}
+
+// fs returns the file system holding the path, the store and the work folders may live on different ones.
+func (s *FileStore) fs(path string) fileSystem {
+	if isBelow(path, s.StorePath) {
+		return s.storeFS
+	}
+	return s.workFS
+}
+
+func isBelow(path string, root string) bool {
+	path, root = filepath.Clean(path), filepath.Clean(root)
+	return path == root || strings.HasPrefix(path, root+string(filepath.Separator))
+}
+
+func (s *FileStore) readFile(path string) ([]byte, error) {
+	data, err := s.fs(path).ReadFile(path)
+	if err != nil {
+		logger.Error("%v", err)
+		return nil, err
+	}
+	return data, nil
+}
+
+func (s *FileStore) writeFile(path string, data []byte, perm fs.FileMode) error {
+	return s.fs(path).WriteFile(path, data, perm)
+}
+
+func (s *FileStore) exists(path string) (bool, error) {
+	_, err := s.fs(path).Stat(path)
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// rename moves the file, between different file systems it is copied and removed.
+func (s *FileStore) rename(src string, dst string) error {
+	from, to := s.fs(src), s.fs(dst)
+	if from == to {
+		return from.Rename(src, dst)
+	}
+
+	info, err := from.Stat(src)
+	if err != nil {
+		return err
+	}
+	content, err := from.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := to.WriteFile(dst, content, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return from.Remove(src)
+}
+
+func (s *FileStore) remove(path string) error {
+	return s.fs(path).Remove(path)
+}
+
+// walkDir walks the tree below root like filepath.WalkDir, on the file system holding root.
+func (s *FileStore) walkDir(root string, fn fs.WalkDirFunc) error {
+	fsys := s.fs(root)
+
+	info, err := fsys.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDir(fsys, root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkDir(fsys fileSystem, path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == filepath.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		err = fn(path, d, err)
+		if err != nil {
+			if err == filepath.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	for _, e := range entries {
+		if err := walkDir(fsys, filepath.Join(path, e.Name()), e, fn); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
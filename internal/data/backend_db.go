@@ -0,0 +1,253 @@
+package data
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+)
+
+// dbFS keeps the CA store in a single tar file. The files are held in memory, every change is
+// appended to the file as a tar entry and synced, removals as an entry with the removed record.
+// Later entries replace earlier ones, the file is compacted once the replaced entries outweigh the
+// current files. The database is read again when the store is locked.
+type dbFS struct {
+	*memFS
+	// The database file, it is also the root of the store paths.
+	path string
+	// size is the length of the file, compacted its length after the last rewrite.
+	size      int64
+	compacted int64
+}
+
+const (
+	// dbRemovedRecord marks the entry of a removed file.
+	dbRemovedRecord = "TINYPKI.removed"
+	// dbTrailer is the length of the end of archive marker, two zero blocks.
+	dbTrailer = 1024
+	// dbCompactMin is the growth below which the file is never compacted.
+	dbCompactMin = 1 << 20
+)
+
+// OpenDBStore returns a store which keeps the CA files in the single database file at path,
+// the work folders stay on the file system below workPath. A missing database is created on the first write.
+// The store lock is held on the file path + ".lock".
+func OpenDBStore(path string, workPath string) (*FileStore, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db := &dbFS{memFS: newMemFS(), path: path}
+	if err := db.load(); err != nil {
+		return nil, err
+	}
+	db.changed = db.save
+
+	s := &FileStore{StorePath: path, WorkPath: workPath, storeFS: db, workFS: osFS{}}
+	s.initFolders()
+	return s, nil
+}
+
+// Lock takes the lock on the lock file next to the database and reads the database again,
+// another process may have changed it.
+func (db *dbFS) Lock(name string, exclusive bool) (func(), error) {
+	release, err := osFS{}.Lock(db.path+lockFile, exclusive)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.load(); err != nil {
+		release()
+		return nil, err
+	}
+	return release, nil
+}
+
+// load replaces the files in memory with the content of the database file. An entry cut off by a
+// crash was never acknowledged, it is dropped and the file is rewritten with the next change.
+func (db *dbFS) load() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	raw, err := os.ReadFile(db.path)
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error("%v", err)
+		return err
+	}
+
+	db.files = map[string]*memFile{}
+	for dir := db.path; !db.isRoot(dir); dir = filepath.Dir(dir) {
+		db.files[dir] = &memFile{mode: fs.ModeDir | 0700, modTime: time.Now()}
+	}
+	db.size, db.compacted = int64(len(raw)), int64(len(raw))
+	if len(raw) == 0 {
+		return nil
+	}
+
+	tr := tar.NewReader(bytes.NewReader(raw))
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			logger.Warning("Database %s ends with an incomplete write, it is dropped", db.path)
+			db.size = -1
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid database %s: %w", db.path, err)
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("invalid file name %s in database %s", header.Name, db.path)
+		}
+		name = filepath.Join(db.path, filepath.FromSlash(name))
+		if header.PAXRecords[dbRemovedRecord] != "" {
+			delete(db.files, name)
+			continue
+		}
+
+		f := &memFile{mode: fs.FileMode(header.Mode).Perm(), modTime: header.ModTime}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			f.mode |= fs.ModeDir
+		case tar.TypeReg:
+			if f.data, err = io.ReadAll(tr); err != nil {
+				if errors.Is(err, io.ErrUnexpectedEOF) {
+					logger.Warning("Database %s ends with an incomplete write, it is dropped", db.path)
+					db.size = -1
+					return nil
+				}
+				return fmt.Errorf("invalid database %s: %w", db.path, err)
+			}
+		default:
+			continue
+		}
+		db.files[name] = f
+	}
+	return nil
+}
+
+// save appends the change to the database file, the lock records are not saved.
+func (db *dbFS) save(changed string) error {
+	if !db.saved(changed) {
+		return nil
+	}
+	if db.size < dbTrailer || db.size > 2*db.compacted+dbCompactMin {
+		return db.rewrite()
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := db.writeEntry(tw, changed); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	if err := db.append(buf.Bytes()); err != nil {
+		logger.Error("Could not write database %s: %v", db.path, err)
+		// the end of the file is unknown, the next change rewrites it
+		db.size = -1
+		return err
+	}
+	db.size += int64(buf.Len()) - dbTrailer
+	return nil
+}
+
+// append replaces the end of archive marker with the entries, which end with a new marker.
+func (db *dbFS) append(entries []byte) error {
+	f, err := os.OpenFile(db.path, os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	trailer := make([]byte, dbTrailer)
+	if _, err := f.ReadAt(trailer, db.size-dbTrailer); err != nil {
+		return err
+	}
+	if !bytes.Equal(trailer, make([]byte, dbTrailer)) {
+		return fmt.Errorf("database %s does not end with the end of archive marker", db.path)
+	}
+	if _, err := f.WriteAt(entries, db.size-dbTrailer); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// rewrite writes all files below the store root to a new database file which replaces the old one.
+func (db *dbFS) rewrite() error {
+	var names []string
+	for name := range db.files {
+		if db.saved(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		if err := db.writeEntry(tw, name); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(db.path, buf.Bytes(), 0600); err != nil {
+		logger.Error("Could not write database %s: %v", db.path, err)
+		return err
+	}
+	db.size, db.compacted = int64(buf.Len()), int64(buf.Len())
+	return nil
+}
+
+// writeEntry writes the file or folder at name, the removed record if it does not exist.
+func (db *dbFS) writeEntry(tw *tar.Writer, name string) error {
+	rel, err := filepath.Rel(db.path, name)
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{Name: filepath.ToSlash(rel), Typeflag: tar.TypeReg, Format: tar.FormatPAX}
+
+	f, ok := db.files[name]
+	switch {
+	case !ok:
+		header.ModTime = time.Now()
+		header.PAXRecords = map[string]string{dbRemovedRecord: "1"}
+	case f.mode.IsDir():
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+		header.Mode, header.ModTime = int64(f.mode.Perm()), f.modTime
+	default:
+		header.Mode, header.ModTime, header.Size = int64(f.mode.Perm()), f.modTime, int64(len(f.data))
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if ok {
+		_, err = tw.Write(f.data)
+	}
+	return err
+}
+
+// saved reports if the path is kept in the database, the lock records below the store are not.
+func (db *dbFS) saved(name string) bool {
+	return name != db.path && isBelow(name, db.path) && !isBelow(name, filepath.Join(db.path, lockFile)) &&
+		!isBelow(name, filepath.Join(db.path, holdersPath))
+}
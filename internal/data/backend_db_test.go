@@ -0,0 +1,120 @@
+package data
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDB opens the database at path and returns its file system.
+func openTestDB(t *testing.T, path string) *dbFS {
+	t.Helper()
+	s, err := OpenDBStore(path, filepath.Join(filepath.Dir(path), "work"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.storeFS.(*dbFS)
+}
+
+func writeTestFile(t *testing.T, db *dbFS, name string, data []byte) {
+	t.Helper()
+	if err := db.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkTestFile(t *testing.T, db *dbFS, name string, want []byte) {
+	t.Helper()
+	got, err := db.ReadFile(name)
+	if want == nil {
+		if !os.IsNotExist(err) {
+			t.Errorf("%s exists, want it removed", name)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s = %q, want %q", name, got, want)
+	}
+}
+
+func TestDBAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.db")
+	db := openTestDB(t, path)
+	a, b := filepath.Join(path, "ca", "a.cer"), filepath.Join(path, "ca", "b.cer")
+
+	writeTestFile(t, db, a, []byte("first"))
+	compacted := db.compacted
+	writeTestFile(t, db, b, []byte("second"))
+	writeTestFile(t, db, a, []byte("replaced"))
+	if err := db.Rename(b, b+".old"); err != nil {
+		t.Fatal(err)
+	}
+	if db.compacted != compacted {
+		t.Errorf("database was rewritten, want the changes appended")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != db.size {
+		t.Errorf("database has %d bytes, want %d", info.Size(), db.size)
+	}
+
+	db = openTestDB(t, path)
+	checkTestFile(t, db, a, []byte("replaced"))
+	checkTestFile(t, db, b, nil)
+	checkTestFile(t, db, b+".old", []byte("second"))
+}
+
+func TestDBCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.db")
+	db := openTestDB(t, path)
+	name := filepath.Join(path, "ca", "big.crl")
+
+	data := bytes.Repeat([]byte{1}, dbCompactMin/4)
+	for i := 0; i < 8; i++ {
+		data[0] = byte(i)
+		writeTestFile(t, db, name, data)
+	}
+	// eight versions of a quarter of the minimum grow the file beyond it once
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 2*dbCompactMin {
+		t.Errorf("database has %d bytes, want it compacted", info.Size())
+	}
+
+	db = openTestDB(t, path)
+	checkTestFile(t, db, name, data)
+}
+
+func TestDBIncompleteWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.db")
+	db := openTestDB(t, path)
+	a, b := filepath.Join(path, "ca", "a.cer"), filepath.Join(path, "ca", "b.cer")
+	writeTestFile(t, db, a, []byte("kept"))
+	size := db.size
+	writeTestFile(t, db, b, bytes.Repeat([]byte{2}, 4096))
+
+	// a crash while appending b leaves the file without its end and without the end of archive marker
+	if err := os.Truncate(path, size+1024); err != nil {
+		t.Fatal(err)
+	}
+	db = openTestDB(t, path)
+	checkTestFile(t, db, a, []byte("kept"))
+	checkTestFile(t, db, b, nil)
+
+	// the next change rewrites the file
+	writeTestFile(t, db, b, []byte("again"))
+	db = openTestDB(t, path)
+	checkTestFile(t, db, a, []byte("kept"))
+	checkTestFile(t, db, b, []byte("again"))
+}
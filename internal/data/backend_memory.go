@@ -0,0 +1,266 @@
+package data
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memFS keeps the files in memory, the store is gone with the process.
+type memFS struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+	locks map[string]*sync.RWMutex
+	// changed is called with the path of every change while mu is held.
+	changed func(name string) error
+}
+
+type memFile struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func newMemFS() *memFS {
+	return &memFS{files: map[string]*memFile{}, locks: map[string]*sync.RWMutex{}}
+}
+
+// NewMemoryStore returns a store which keeps the CA files and the work folders in memory,
+// e.g. for tests of issuance and revocation. The folders are below /store and /work.
+func NewMemoryStore() *FileStore {
+	mem := newMemFS()
+	s := &FileStore{
+		StorePath: filepath.FromSlash("/store"),
+		WorkPath:  filepath.FromSlash("/work"),
+		storeFS:   mem,
+		workFS:    mem,
+	}
+	s.initFolders()
+	return s
+}
+
+func (m *memFS) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if f.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	return append([]byte(nil), f.data...), nil
+}
+
+func (m *memFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = filepath.Clean(name)
+	if err := m.checkParent("open", name); err != nil {
+		return err
+	}
+	if f, ok := m.files[name]; ok && f.mode.IsDir() {
+		return &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+
+	m.files[name] = &memFile{data: append([]byte(nil), data...), mode: perm.Perm(), modTime: time.Now()}
+	return m.change(name)
+}
+
+func (m *memFS) Rename(oldpath string, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	f, err := m.lookup("rename", oldpath)
+	if err != nil {
+		return err
+	}
+	if f.mode.IsDir() {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: errors.New("renaming folders is not supported")}
+	}
+	if err := m.checkParent("rename", newpath); err != nil {
+		return err
+	}
+
+	m.files[newpath] = f
+	delete(m.files, oldpath)
+	// the new path is reported first, a crash in between leaves the file at both paths and not at none
+	if err := m.change(newpath); err != nil {
+		return err
+	}
+	return m.change(oldpath)
+}
+
+func (m *memFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = filepath.Clean(name)
+	f, err := m.lookup("remove", name)
+	if err != nil {
+		return err
+	}
+	if f.mode.IsDir() && len(m.children(name)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+	}
+
+	delete(m.files, name)
+	return m.change(name)
+}
+
+func (m *memFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = filepath.Clean(name)
+	f, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if !f.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdirent", Path: name, Err: errors.New("not a directory")}
+	}
+
+	var entries []fs.DirEntry
+	for _, child := range m.children(name) {
+		entries = append(entries, fs.FileInfoToDirEntry(memInfo{name: filepath.Base(child), file: m.files[child]}))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return memInfo{name: filepath.Base(name), file: f}, nil
+}
+
+func (m *memFS) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = filepath.Clean(name)
+	var missing []string
+	for dir := name; !m.isRoot(dir); dir = filepath.Dir(dir) {
+		f, ok := m.files[dir]
+		if ok {
+			if !f.mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: errors.New("not a directory")}
+			}
+			break
+		}
+		missing = append(missing, dir)
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		m.files[missing[i]] = &memFile{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+		if err := m.change(missing[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memFS) Chmod(name string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := m.lookup("chmod", name)
+	if err != nil {
+		return err
+	}
+	f.mode = f.mode.Type() | mode.Perm()
+	return m.change(filepath.Clean(name))
+}
+
+// Lock holds a lock of this process, the lock is not visible to other processes.
+func (m *memFS) Lock(name string, exclusive bool) (func(), error) {
+	m.mu.Lock()
+	lock, ok := m.locks[name]
+	if !ok {
+		lock = &sync.RWMutex{}
+		m.locks[name] = lock
+	}
+	m.mu.Unlock()
+
+	if exclusive {
+		if !lock.TryLock() {
+			return nil, ErrLocked
+		}
+		return lock.Unlock, nil
+	}
+	if !lock.TryRLock() {
+		return nil, ErrLocked
+	}
+	return lock.RUnlock, nil
+}
+
+// lookup returns the file or folder at the path, the root always exists.
+func (m *memFS) lookup(op string, name string) (*memFile, error) {
+	name = filepath.Clean(name)
+	if m.isRoot(name) {
+		return &memFile{mode: fs.ModeDir | 0755}, nil
+	}
+	f, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return f, nil
+}
+
+func (m *memFS) checkParent(op string, name string) error {
+	parent, err := m.lookup(op, filepath.Dir(name))
+	if err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if !parent.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: errors.New("not a directory")}
+	}
+	return nil
+}
+
+func (m *memFS) children(dir string) []string {
+	var result []string
+	for name := range m.files {
+		if name != dir && filepath.Dir(name) == dir {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func (m *memFS) isRoot(name string) bool {
+	return filepath.Dir(name) == name || strings.TrimSuffix(name, string(filepath.Separator)) == ""
+}
+
+func (m *memFS) change(name string) error {
+	if m.changed == nil {
+		return nil
+	}
+	return m.changed(name)
+}
+
+// memInfo describes a file of the memory file system.
+type memInfo struct {
+	name string
+	file *memFile
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return int64(len(i.file.data)) }
+func (i memInfo) Mode() fs.FileMode  { return i.file.mode }
+func (i memInfo) ModTime() time.Time { return i.file.modTime }
+func (i memInfo) IsDir() bool        { return i.file.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
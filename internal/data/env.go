@@ -4,54 +4,68 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"deleteonerror.com/tyinypki/internal/logger"
 )
 
-var StorePath string
-var WorkPath string
+// FileStore keeps the CA store and the work folders in the folder layout of tiny pki.
+// The folders are on the disk, in memory or in a database file, see NewMemoryStore and OpenDBStore.
+type FileStore struct {
+	StorePath string
+	WorkPath  string
+	// LockWait is the time to wait for a store lock held by another process, zero fails at once.
+	LockWait time.Duration
 
-func Initialize() {
+	folders []folder
+	storeFS fileSystem
+	workFS  fileSystem
+}
+
+// NewFileStore returns a store with the CA files below storePath and the work folders below workPath.
+func NewFileStore(storePath string, workPath string) *FileStore {
+	s := &FileStore{StorePath: storePath, WorkPath: workPath, storeFS: osFS{}, workFS: osFS{}}
+	s.initFolders()
+	return s
+}
+
+// FromEnvironment returns the store configured by `CONTAINER`, `TINY_ROOT_PATH` and `TINY_LOCK_WAIT`.
+func FromEnvironment() *FileStore {
+	var storePath, workPath string
 
 	container, hasContainerVar := os.LookupEnv("CONTAINER")
 	if hasContainerVar && strings.EqualFold(container, "true") {
 		logger.Debug("Using container path")
-		StorePath = "/var/lib/tinyPKI"
-		WorkPath = "/var/tinyPKI"
+		storePath = "/var/lib/tinyPKI"
+		workPath = "/var/tinyPKI"
 	}
 
 	rootPath, exists := os.LookupEnv("TINY_ROOT_PATH")
 	if !exists && !hasContainerVar {
 		rootPath = getAppPath()
-		WorkPath = filepath.Join(rootPath, "work")
-		StorePath = filepath.Join(rootPath, "store")
+		workPath = filepath.Join(rootPath, "work")
+		storePath = filepath.Join(rootPath, "store")
 	} else if exists {
 		_, err := os.Stat(rootPath)
 		if os.IsNotExist(err) {
 			logger.Warning("Environment variable `TINY_ROOT_PATH` set to `%s`, directory not accesible, fallback to default", rootPath)
 			rootPath = getAppPath()
 		}
-		WorkPath = filepath.Join(rootPath, "work")
-		StorePath = filepath.Join(rootPath, "store")
+		workPath = filepath.Join(rootPath, "work")
+		storePath = filepath.Join(rootPath, "store")
 	}
 
-	severity, exists := os.LookupEnv("TINY_LOG")
-	if !exists {
-		logger.LogSeverity = logger.INFO
-	} else {
-		switch strings.ToLower(severity) {
-		case "debug", "dev":
-			logger.LogSeverity = logger.DEBUG
-		case "warning":
-			logger.LogSeverity = logger.WARNING
-		case "error":
-			logger.LogSeverity = logger.ERROR
-		default:
-			logger.Warning("Environment variable `TINY_LOG` ignored, Loglevel is default `Info`")
-			logger.LogSeverity = logger.INFO
+	s := NewFileStore(storePath, workPath)
+
+	wait, exists := os.LookupEnv("TINY_LOCK_WAIT")
+	if exists {
+		duration, err := time.ParseDuration(wait)
+		if err != nil {
+			logger.Warning("Environment variable `TINY_LOCK_WAIT` ignored: %v", err)
+		} else {
+			s.LockWait = duration
 		}
 	}
-	logger.Info("loglevel is %d", logger.LogSeverity)
 
-	initFolders()
+	return s
 }
@@ -2,7 +2,6 @@ package data
 
 import (
 	"encoding/pem"
-	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -13,12 +12,12 @@ import (
 	"deleteonerror.com/tyinypki/internal/model"
 )
 
-func WriteRawIssuedCertificate(certBytes []byte, filename string) (string, error) {
+func (s *FileStore) WriteRawIssuedCertificate(certBytes []byte, filename string) (string, error) {
 
-	folder := getFolderByName("ca-issued")
+	folder := s.getFolderByName("ca-issued")
 	path := filepath.Join(folder.path, filename+".cer")
 
-	err := writeRawX509Cert(certBytes, path)
+	err := s.writeRawX509Cert(certBytes, path)
 	if err != nil {
 		return "", err
 	}
@@ -26,26 +25,75 @@ func WriteRawIssuedCertificate(certBytes []byte, filename string) (string, error
 	return path, nil
 }
 
-func WriteRawIssuedCaCertificate(certBytes []byte, filename string) (string, error) {
+// WriteRawIssuedCaCertificate writes the issued CA certificate followed by the PEM encoded issuer chain,
+// so the subordinate CA learns its chain on import.
+func (s *FileStore) WriteRawIssuedCaCertificate(certBytes []byte, chain []byte, filename string) (string, error) {
 
-	folder := getFolderByName("ca-cert-in")
+	folder := s.getFolderByName("ca-cert-in")
 	path := filepath.Join(folder.path, filename+".cer")
 
-	err := writeRawX509Cert(certBytes, path)
-	if err != nil {
+	certPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: certBytes,
+	})
+
+	if err := s.writeFile(path, append(certPEM, chain...), 0666); err != nil {
+		logger.Error("%v", err)
+		return "", err
+	}
+
+	return path, nil
+}
+
+// WriteIssuedChain writes the PEM encoded chain of an issued certificate to the out folder.
+func (s *FileStore) WriteIssuedChain(chain []byte, filename string) (string, error) {
+
+	folder := s.getFolderByName("issued")
+	filename = filename + ".chain.pem"
+	s.moveOld(*folder, filename)
+
+	path := filepath.Join(folder.path, filename)
+	if err := s.writeFile(path, chain, 0666); err != nil {
+		logger.Error("%v", err)
 		return "", err
 	}
 
 	return path, nil
 }
 
-func WriteRawCaCertificate(certBytes []byte) (string, error) {
+// WriteCaChain stores the PEM encoded issuer certificates of the CA certificate.
+func (s *FileStore) WriteCaChain(chain []byte) error {
+
+	folder := s.getFolderByName("ca-cer")
+	s.moveOld(*folder, "chain.cer")
+
+	if err := s.writeFile(filepath.Join(folder.path, "chain.cer"), chain, 0600); err != nil {
+		logger.Error("%v", err)
+		return err
+	}
+	return nil
+}
+
+// ReadCaChain returns the PEM encoded issuer certificates of the CA certificate, a root CA has none.
+func (s *FileStore) ReadCaChain() ([]byte, error) {
+	src := s.getFolderByName("ca-cer")
+	path := filepath.Join(src.path, "chain.cer")
 
-	folder := getFolderByName("ca-cer")
+	_, err := s.fs(path).Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	return s.readFile(path)
+}
+
+func (s *FileStore) WriteRawCaCertificate(certBytes []byte) (string, error) {
+
+	folder := s.getFolderByName("ca-cer")
 	path := filepath.Join(folder.path, "ca.cer")
-	moveOld(*folder, "ca.cer")
+	s.moveOld(*folder, "ca.cer")
 
-	err := writeRawX509Cert(certBytes, path)
+	err := s.writeRawX509Cert(certBytes, path)
 	if err != nil {
 		return "", err
 	}
@@ -53,21 +101,21 @@ func WriteRawCaCertificate(certBytes []byte) (string, error) {
 	return path, nil
 }
 
-func WritePemCaCertificate(certBytes []byte) (string, error) {
+func (s *FileStore) WritePemCaCertificate(certBytes []byte) (string, error) {
 
-	folder := getFolderByName("ca-cer")
+	folder := s.getFolderByName("ca-cer")
 	path := filepath.Join(folder.path, "ca.cer")
-	moveOld(*folder, "ca.cer")
+	s.moveOld(*folder, "ca.cer")
 
 	dest := filepath.Join(path)
-	if err := os.WriteFile(dest, certBytes, 0666); err != nil {
+	if err := s.writeFile(dest, certBytes, 0666); err != nil {
 		logger.Error("%v", err)
 		return "", err
 	}
 	return path, nil
 }
 
-func writeRawX509Cert(x509Bytes []byte, path string) error {
+func (s *FileStore) writeRawX509Cert(x509Bytes []byte, path string) error {
 
 	certPEM := pem.EncodeToMemory(&pem.Block{
 		Type:  "CERTIFICATE",
@@ -75,14 +123,14 @@ func writeRawX509Cert(x509Bytes []byte, path string) error {
 	})
 
 	dest := filepath.Join(path)
-	if err := os.WriteFile(dest, certPEM, 0666); err != nil {
+	if err := s.writeFile(dest, certPEM, 0666); err != nil {
 		logger.Error("%v", err)
 		return err
 	}
 	return nil
 }
 
-func WriteRawRequest(csrBytes []byte, filename string) (string, error) {
+func (s *FileStore) WriteRawRequest(csrBytes []byte, filename string) (string, error) {
 
 	csrPEM := pem.EncodeToMemory(&pem.Block{
 		Type:  "CERTIFICATE REQUEST",
@@ -90,10 +138,10 @@ func WriteRawRequest(csrBytes []byte, filename string) (string, error) {
 	})
 
 	filename = filename + ".csr"
-	folder := getFolderByName("ca-req")
+	folder := s.getFolderByName("ca-req")
 
 	dest := filepath.Join(folder.path, filename)
-	if err := os.WriteFile(dest, csrPEM, 0666); err != nil {
+	if err := s.writeFile(dest, csrPEM, 0666); err != nil {
 		logger.Error("%v", err)
 		return "", err
 	}
@@ -111,36 +159,24 @@ func WriteRawRequestHere(csrBytes []byte, filename string) (string, error) {
 	path := getAppPath()
 
 	dest := filepath.Join(path, filename)
-	if err := os.WriteFile(dest, csrPEM, 0666); err != nil {
+	if err := writeFileAtomic(dest, csrPEM, 0666); err != nil {
 		logger.Error("%v", err)
 		return "", err
 	}
 	return dest, nil
 }
 
-func WriteKey(encryptedKey []byte) error {
-
-	src := getFolderByName("ca-key")
-	moveOld(*src, "ca.key")
+func (s *FileStore) WriteKey(encryptedKey []byte) error {
 
-	file, err := os.OpenFile(
-		filepath.Join(src.path, "ca.key"),
-		os.O_WRONLY|os.O_TRUNC|os.O_CREATE,
-		0600,
-	)
-	if err != nil {
-		logger.Error("Failed to write ca file %v", err)
-
-		return err
-	}
-	defer file.Close()
+	src := s.getFolderByName("ca-key")
+	s.moveOld(*src, "ca.key")
 
-	bytesWritten, err := file.Write(encryptedKey)
+	err := s.writeFile(filepath.Join(src.path, "ca.key"), encryptedKey, 0600)
 	if err != nil {
 		logger.Error("Failed to write ca file %v", err)
 		return err
 	}
-	logger.Info("Wrote %d bytes encrypted key.", bytesWritten)
+	logger.Info("Wrote %d bytes encrypted key.", len(encryptedKey))
 	return nil
 }
 
@@ -155,30 +191,19 @@ func WriteRawPrivateKey(privateKey []byte, filename string) (string, error) {
 	path := getAppPath()
 
 	dest := filepath.Join(path, filename)
-	if err := os.WriteFile(dest, pemBlock, 0600); err != nil {
+	if err := writeFileAtomic(dest, pemBlock, 0600); err != nil {
 		logger.Error("%v", err)
 		return "", err
 	}
 	return dest, nil
 }
 
-func WriteKeyNonce(nonce []byte) error {
+func (s *FileStore) WriteKeyNonce(nonce []byte) error {
 
-	src := getFolderByName("ca-key")
-	moveOld(*src, "ca.key.nonce")
+	src := s.getFolderByName("ca-key")
+	s.moveOld(*src, "ca.key.nonce")
 
-	file, err := os.OpenFile(
-		filepath.Join(src.path, "ca.key.nonce"),
-		os.O_WRONLY|os.O_TRUNC|os.O_CREATE,
-		0600,
-	)
-	if err != nil {
-		logger.Error("%v", err)
-		return err
-	}
-	defer file.Close()
-
-	_, err = file.Write(nonce)
+	err := s.writeFile(filepath.Join(src.path, "ca.key.nonce"), nonce, 0600)
 	if err != nil {
 		logger.Error("%v", err)
 		return err
@@ -186,29 +211,29 @@ func WriteKeyNonce(nonce []byte) error {
 	return nil
 }
 
-func ReadKeyNonce() ([]byte, error) {
+func (s *FileStore) ReadKeyNonce() ([]byte, error) {
 
-	src := getFolderByName("ca-key")
+	src := s.getFolderByName("ca-key")
 	path := filepath.Join(src.path, "ca.key.nonce")
 
-	return readFile(path)
+	return s.readFile(path)
 }
 
-func ReadKey() ([]byte, error) {
+func (s *FileStore) ReadKey() ([]byte, error) {
 
-	src := getFolderByName("ca-key")
+	src := s.getFolderByName("ca-key")
 	path := filepath.Join(src.path, "ca.key")
 
-	return readFile(path)
+	return s.readFile(path)
 }
 
-func GetLatestCRL() ([]byte, error) {
-	src := getFolderByName("ca-crl")
+func (s *FileStore) GetLatestCRL() ([]byte, error) {
+	src := s.getFolderByName("ca-crl")
 
 	var maxNum int
 	var maxFile string
 
-	err := filepath.WalkDir(src.path,
+	err := s.walkDir(src.path,
 		func(path string, d fs.DirEntry, err error) error {
 			if err != nil {
 				logger.Error("%v", err)
@@ -237,44 +262,20 @@ func GetLatestCRL() ([]byte, error) {
 	}
 
 	if maxFile != "" {
-		file, err := os.Open(maxFile)
-		if err != nil {
-			logger.Error("%v", err)
-			return nil, err
-		}
-		defer file.Close()
-
-		content, err := io.ReadAll(file)
-		if err != nil {
-			logger.Error("%v", err)
-			return nil, err
-		}
-
-		return content, nil
+		return s.readFile(maxFile)
 	}
 	return nil, nil
 }
 
-func WriteCRL(data []byte, id string) (string, error) {
+func (s *FileStore) WriteCRL(data []byte, id string) (string, error) {
 
 	filename := id + ".crl"
-	src := getFolderByName("ca-crl")
-	moveOld(*src, filename)
+	src := s.getFolderByName("ca-crl")
+	s.moveOld(*src, filename)
 
 	path := filepath.Join(src.path, filename)
 
-	file, err := os.OpenFile(
-		path,
-		os.O_WRONLY|os.O_TRUNC|os.O_CREATE,
-		0600,
-	)
-	if err != nil {
-		logger.Error("%v", err)
-		return "", err
-	}
-	defer file.Close()
-
-	_, err = file.Write(data)
+	err := s.writeFile(path, data, 0600)
 	if err != nil {
 		logger.Error("%v", err)
 		return "", err
@@ -282,10 +283,10 @@ func WriteCRL(data []byte, id string) (string, error) {
 	return path, nil
 }
 
-func GetRevokedCertificatesFromCaStore() ([]model.FileContentWithPath, error) {
-	src := getFolderByName("ca-revoked")
+func (s *FileStore) GetRevokedCertificatesFromCaStore() ([]model.FileContentWithPath, error) {
+	src := s.getFolderByName("ca-revoked")
 
-	files, err := getFilesInFolder(src.path)
+	files, err := s.getFilesInFolder(src.path)
 	if err != nil {
 		logger.Error("%v", err)
 		return nil, err
@@ -297,10 +298,10 @@ func GetRevokedCertificatesFromCaStore() ([]model.FileContentWithPath, error) {
 	return files, nil
 }
 
-func GetNewRevokations() ([]model.FileContentWithPath, error) {
-	src := getFolderByName("revoke")
+func (s *FileStore) GetNewRevokations() ([]model.FileContentWithPath, error) {
+	src := s.getFolderByName("revoke")
 
-	files, err := getFilesInFolder(src.path)
+	files, err := s.getFilesInFolder(src.path)
 	if err != nil {
 		logger.Error("%v", err)
 		return nil, err
@@ -312,12 +313,12 @@ func GetNewRevokations() ([]model.FileContentWithPath, error) {
 	return files, nil
 }
 
-func ImportRevokedCertificate(in model.FileContentWithPath) error {
+func (s *FileStore) ImportRevokedCertificate(in model.FileContentWithPath) error {
 
 	src := in.Path
-	destDir := getFolderByName("ca-revoked")
+	destDir := s.getFolderByName("ca-revoked")
 
-	_, err := os.Stat(src)
+	_, err := s.fs(src).Stat(src)
 	if err == nil {
 		sourcePath := filepath.Join(in.Path, in.Name)
 		targetPath := filepath.Join(destDir.path, in.GetPrefixedFileName())
@@ -327,10 +328,10 @@ func ImportRevokedCertificate(in model.FileContentWithPath) error {
 			return nil
 		}
 
-		if err := os.Rename(sourcePath, targetPath); err != nil {
+		if err := s.rename(sourcePath, targetPath); err != nil {
 
 			if strings.Contains(err.Error(), "cross-device") {
-				if err := moveHard(sourcePath, targetPath); err != nil {
+				if err := s.moveHard(sourcePath, targetPath); err != nil {
 					return err
 				}
 			}
@@ -348,16 +349,16 @@ func ImportRevokedCertificate(in model.FileContentWithPath) error {
 
 }
 
-func ReadCaCertificate() ([]byte, error) {
-	src := getFolderByName("ca-cer")
+func (s *FileStore) ReadCaCertificate() ([]byte, error) {
+	src := s.getFolderByName("ca-cer")
 	path := filepath.Join(src.path, "ca.cer")
 
-	return readFile(path)
+	return s.readFile(path)
 }
 
-func GetIncommingSubCer() ([]model.FileContentWithPath, error) {
-	src := getFolderByName("ca-cert-in")
-	files, err := getFilesInFolder(src.path)
+func (s *FileStore) GetIncommingSubCer() ([]model.FileContentWithPath, error) {
+	src := s.getFolderByName("ca-cert-in")
+	files, err := s.getFilesInFolder(src.path)
 	if err != nil {
 		logger.Error("%v", err)
 		return nil, err
@@ -369,21 +370,24 @@ func GetIncommingSubCer() ([]model.FileContentWithPath, error) {
 	return files, nil
 }
 
+// GetX509CertificateRequest returns the DER encoding of the PEM or DER encoded request at path.
 func GetX509CertificateRequest(path string) ([]byte, error) {
 	raw, err := readFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	block, _ := pem.Decode(raw)
-	return block.Bytes, nil
+	if block, _ := pem.Decode(raw); block != nil {
+		return block.Bytes, nil
+	}
+	return raw, nil
 
 }
 
-func GetCaCertificateRequests() ([]model.FileContentWithPath, error) {
-	src := getFolderByName("ca-req")
+func (s *FileStore) GetCaCertificateRequests() ([]model.FileContentWithPath, error) {
+	src := s.getFolderByName("ca-req")
 
-	files, err := getFilesInFolder(src.path)
+	files, err := s.getFilesInFolder(src.path)
 	if err != nil {
 		logger.Error("%v", err)
 		return nil, err
@@ -395,21 +399,24 @@ func GetCaCertificateRequests() ([]model.FileContentWithPath, error) {
 	return files, nil
 }
 
-func GetCertificateRequests() []model.FileContentWithPath {
+func (s *FileStore) GetCertificateRequests() []model.FileContentWithPath {
 
 	directories := []string{"webserver-requests", "client-requests", "code-requests", "server-requests", "ocsp-requests", "requests"}
 	var result []model.FileContentWithPath
 
 	for _, dir := range directories {
 
-		src := getFolderByName(dir)
+		src := s.getFolderByName(dir)
 
-		files, err := getFilesInFolder(src.path)
+		files, err := s.getFilesInFolder(src.path)
 		if err != nil {
 			logger.Error("%v", err)
 		}
 
 		for _, f := range files {
+			if isStatusFile(f.Name) {
+				continue
+			}
 			f.RequestType = dir
 			result = append(result, f)
 		}
@@ -423,67 +430,19 @@ func GetCertificateRequests() []model.FileContentWithPath {
 	return result
 }
 
-func Publish(src string, destName string) error {
-	destName = filepath.Clean(destName)
-
-	destFolder := getFolderByName("ca-publish")
-	moveOld(*destFolder, destName)
-
-	srcFile, err := os.Open(src)
-	if err != nil {
-		logger.Error("%v", err)
-		return err
-	}
-	defer srcFile.Close()
-
-	dstFile, err := os.Create(filepath.Join(destFolder.path, destName))
-	if err != nil {
-		logger.Error("%v", err)
-		return err
-	}
-	defer dstFile.Close()
-
-	_, err = io.Copy(dstFile, srcFile)
-	if err != nil {
-		logger.Error("%v", err)
-		return err
-	}
-
-	err = dstFile.Sync()
-	if err != nil {
-		logger.Error("%v", err)
-		return err
-	}
-	return nil
-}
-
-func Issued(src string) error {
-	destFolder := getFolderByName("issued")
+func (s *FileStore) Issued(src string) error {
+	src = s.resolve(src)
+	destFolder := s.getFolderByName("issued")
 	fileName := filepath.Base(src)
 
-	moveOld(*destFolder, fileName)
+	s.moveOld(*destFolder, fileName)
 
-	srcFile, err := os.Open(src)
+	content, err := s.readFile(src)
 	if err != nil {
-		logger.Error("%v", err)
 		return err
 	}
-	defer srcFile.Close()
 
-	dstFile, err := os.Create(filepath.Join(destFolder.path, fileName))
-	if err != nil {
-		logger.Error("%v", err)
-		return err
-	}
-	defer dstFile.Close()
-
-	_, err = io.Copy(dstFile, srcFile)
-	if err != nil {
-		logger.Error("%v", err)
-		return err
-	}
-
-	err = dstFile.Sync()
+	err = s.writeFile(filepath.Join(destFolder.path, fileName), content, 0666)
 	if err != nil {
 		logger.Error("%v", err)
 		return err
@@ -491,38 +450,34 @@ func Issued(src string) error {
 	return nil
 }
 
-func ArchiveRequest(path, file string) {
-	srcFolder := folder{path: path, name: file}
+// ArchiveRequest moves a processed request to the archive of its folder.
+func (s *FileStore) ArchiveRequest(request string) {
+	request = s.resolve(request)
+	file := filepath.Base(request)
+	srcFolder := folder{path: filepath.Dir(request), name: file}
 	logger.Debug("Archiving request %s", file)
-	moveOld(srcFolder, file)
+	s.moveOld(srcFolder, file)
 }
 
-func Delete(path string) error {
-	err := os.Remove(path)
+// Delete removes a file of the store or the work folders.
+func (s *FileStore) Delete(path string) error {
+	err := s.remove(s.resolve(path))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func moveHard(src string, dst string) error {
-	fin, err := os.Open(src)
-	if err != nil {
-		return err
-	}
-	defer fin.Close()
-
-	fout, err := os.Create(dst)
+func (s *FileStore) moveHard(src string, dst string) error {
+	content, err := s.readFile(src)
 	if err != nil {
 		return err
 	}
-	defer fout.Close()
 
-	_, err = io.Copy(fout, fin)
+	err = s.writeFile(dst, content, 0666)
 	if err != nil {
 		return err
 	}
 
-	err = os.Remove(src)
-	return err
+	return s.remove(src)
 }
@@ -7,9 +7,10 @@ import (
 	"deleteonerror.com/tyinypki/internal/logger"
 )
 
-func IsCaConfigured() bool {
+func (s *FileStore) IsCaConfigured() bool {
 
-	_, err := os.Stat(filepath.Join(getFolderByName("ca-key").path, "ca.key"))
+	key := filepath.Join(s.getFolderByName("ca-key").path, "ca.key")
+	_, err := s.fs(key).Stat(key)
 	if err == nil {
 		return true
 	} else if os.IsNotExist(err) {
@@ -18,7 +19,8 @@ func IsCaConfigured() bool {
 		logger.Warning("Could not read private key file: %v", err)
 	}
 
-	_, err = os.Stat(filepath.Join(getFolderByName("ca-cer").path, "ca.cer"))
+	cert := filepath.Join(s.getFolderByName("ca-cer").path, "ca.cer")
+	_, err = s.fs(cert).Stat(cert)
 	if err == nil {
 		return true
 	} else if os.IsNotExist(err) {
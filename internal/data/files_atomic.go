@@ -0,0 +1,111 @@
+package data
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+)
+
+const tempSuffix = ".tmp"
+
+// writeFileAtomic writes data to a temporary file next to path, syncs it and renames it over path.
+// Readers see either the old or the new content, never a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*"+tempSuffix)
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	ok := false
+	defer func() {
+		if !ok {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+	ok = true
+
+	return syncDir(dir)
+}
+
+// renameDurable renames src to dst and syncs both parent folders, so the move survives a crash.
+func renameDurable(src string, dst string) error {
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	if filepath.Dir(src) != filepath.Dir(dst) {
+		return syncDir(filepath.Dir(src))
+	}
+	return nil
+}
+
+// removeDurable removes the file and syncs its folder.
+func removeDurable(path string) error {
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		logger.Debug("Could not sync folder %s: %v", dir, err)
+	}
+	return nil
+}
+
+// isTempFile reports if the name belongs to a temporary file left by an interrupted write.
+func isTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, tempSuffix)
+}
+
+// RemoveTempFiles deletes temporary files left by interrupted writes in the store.
+func (s *FileStore) RemoveTempFiles() (int, error) {
+	removed := 0
+	err := s.walkDir(s.StorePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !isTempFile(d.Name()) {
+			return nil
+		}
+		if err := s.remove(path); err != nil {
+			return err
+		}
+		logger.Debug("Removed temporary file %s", path)
+		removed++
+		return nil
+	})
+	return removed, err
+}
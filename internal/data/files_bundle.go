@@ -0,0 +1,150 @@
+package data
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// bundleFolders maps the kinds of bundle files to the folders they are exported from and imported to.
+var bundleFolders = map[string]string{
+	"csr":     "ca-req",
+	"ca-cert": "ca-cert-in",
+	"publish": "ca-publish",
+}
+
+// GetBundleSources returns the files of the given kind which can be exported in a transfer bundle.
+func (s *FileStore) GetBundleSources(kind string) ([]model.FileContentWithPath, error) {
+	name, ok := bundleFolders[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown bundle file kind %s", kind)
+	}
+	src := s.getFolderByName(name)
+
+	files, err := s.getFilesInFolder(src.path)
+	if err != nil {
+		logger.Error("%v", err)
+		return nil, err
+	}
+	return files, nil
+}
+
+// ImportBundleFile places a file of a verified transfer bundle in the folder of its kind.
+func (s *FileStore) ImportBundleFile(kind string, name string, content []byte) (string, error) {
+	folderName, ok := bundleFolders[kind]
+	if !ok {
+		return "", fmt.Errorf("unknown bundle file kind %s", kind)
+	}
+	dest := s.getFolderByName(folderName)
+
+	name = filepath.Base(filepath.Clean(name))
+	if name == "." || name == string(filepath.Separator) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid file name %s", name)
+	}
+	s.moveOld(*dest, name)
+
+	path := filepath.Join(dest.path, name)
+	if err := s.writeFile(path, content, 0666); err != nil {
+		logger.Error("%v", err)
+		return "", err
+	}
+	return path, nil
+}
+
+// WriteBundle writes the files as tar archive, the default location is the bundles folder.
+func (s *FileStore) WriteBundle(dest string, filename string, files []model.BundleFile) (string, error) {
+	fsys := fileSystem(osFS{})
+	if dest == "" {
+		folder := s.getFolderByName("bundles")
+		s.createAndLogDir(*folder)
+		dest = filepath.Join(folder.path, filename)
+		fsys = s.fs(dest)
+	}
+
+	raw, err := EncodeArchive(files)
+	if err != nil {
+		return "", err
+	}
+
+	if err := fsys.WriteFile(dest, raw, 0664); err != nil {
+		logger.Error("%v", err)
+		return "", err
+	}
+	return dest, nil
+}
+
+// ReadBundle reads all regular files of a tar archive.
+func ReadBundle(src string) ([]model.BundleFile, error) {
+	raw, err := readFile(src)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeArchive(raw)
+}
+
+// EncodeArchive returns the files as tar archive.
+func EncodeArchive(files []model.BundleFile) ([]byte, error) {
+	var buf bytes.Buffer
+
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		header := &tar.Header{
+			Name:    f.Name,
+			Mode:    0600,
+			Size:    int64(len(f.Data)),
+			ModTime: time.Now().UTC(),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			logger.Error("%v", err)
+			return nil, err
+		}
+		if _, err := tw.Write(f.Data); err != nil {
+			logger.Error("%v", err)
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		logger.Error("%v", err)
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeArchive returns all regular files of a tar archive, names leaving the archive are rejected.
+func DecodeArchive(raw []byte) ([]model.BundleFile, error) {
+	var files []model.BundleFile
+	tr := tar.NewReader(bytes.NewReader(raw))
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || strings.HasPrefix(name, "..") {
+			return nil, fmt.Errorf("invalid file name %s in archive", header.Name)
+		}
+
+		content, err := io.ReadAll(io.LimitReader(tr, 16<<20))
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %w", err)
+		}
+		files = append(files, model.BundleFile{Name: name, Data: content})
+	}
+
+	return files, nil
+}
@@ -19,19 +19,19 @@ func readFile(path string) ([]byte, error) {
 	return data, nil
 }
 
-func getFilesInFolder(path string) ([]model.FileContentWithPath, error) {
+func (s *FileStore) getFilesInFolder(path string) ([]model.FileContentWithPath, error) {
 	var filePaths []model.FileContentWithPath
 
-	files, err := os.ReadDir(path)
+	files, err := s.fs(path).ReadDir(path)
 	if err != nil {
 		logger.Error("%v", err)
 		return nil, err
 	}
 
 	for _, file := range files {
-		if !file.IsDir() {
+		if !file.IsDir() && !isTempFile(file.Name()) {
 			filePath := filepath.Join(path, file.Name())
-			content, err := readFile(filePath)
+			content, err := s.readFile(filePath)
 			if err != nil {
 				logger.Error("could not read %s: %v", filePath, err)
 				continue
@@ -54,21 +54,21 @@ func getFilesInFolder(path string) ([]model.FileContentWithPath, error) {
 	return filePaths, nil
 }
 
-func moveOld(f folder, filename string) {
+func (s *FileStore) moveOld(f folder, filename string) {
 	src := filepath.Join(f.path, filename)
 
-	err := ensureArchiveFolderExists(f.path)
+	err := s.ensureArchiveFolderExists(f.path)
 	if err != nil {
 		logger.Error("%v\n", err)
 	}
 
-	_, err = os.Stat(src)
+	_, err = s.fs(src).Stat(src)
 	if err == nil {
 
 		prefix := time.Now().UTC().Format("2006-01-02_15-04-05_")
 		targetPath := filepath.Join(f.path, ".old", prefix+filename)
 
-		if err := os.Rename(src, targetPath); err != nil {
+		if err := s.rename(src, targetPath); err != nil {
 			logger.Error("Failed to move file %v", err)
 		} else {
 			logger.Debug("Moved file to %s", targetPath)
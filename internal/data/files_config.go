@@ -2,32 +2,29 @@ package data
 
 import (
 	"encoding/json"
-	"os"
 	"path/filepath"
 
 	"deleteonerror.com/tyinypki/internal/logger"
 	"deleteonerror.com/tyinypki/internal/model"
 )
 
-func ReadSetupConfiguration(root bool) (model.Config, error) {
+func (s *FileStore) ReadSetupConfiguration(root bool) (model.Config, error) {
 	configFileName := "sub.config.json"
 
 	if root {
 		configFileName = "root.config.json"
 	}
 
-	p := filepath.Join(WorkPath, configFileName)
+	p := filepath.Join(s.WorkPath, configFileName)
 	logger.Debug("%s\n", p)
-	file, err := os.Open(p)
+	content, err := s.fs(p).ReadFile(p)
 	if err != nil {
 		logger.Error("%v\n", err)
 		return model.Config{}, err
 	}
-	defer file.Close()
 
 	var config model.Config
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&config); err != nil {
+	if err := json.Unmarshal(content, &config); err != nil {
 		logger.Error("%v\n", err)
 		return model.Config{}, err
 	}
@@ -35,11 +32,11 @@ func ReadSetupConfiguration(root bool) (model.Config, error) {
 	return config, nil
 }
 
-func ReadCaConfiguration() (model.Config, error) {
-	src := getFolderByName("ca-cer")
+func (s *FileStore) ReadCaConfiguration() (model.Config, error) {
+	src := s.getFolderByName("ca-cer")
 	path := filepath.Join(filepath.Join(src.path, "config.json"))
 
-	content, err := readFile(path)
+	content, err := s.readFile(path)
 	if err != nil {
 		return model.Config{}, err
 	}
@@ -52,19 +49,16 @@ func ReadCaConfiguration() (model.Config, error) {
 	return config, nil
 }
 
-func WriteCaConfiguration(config model.Config) error {
-	src := getFolderByName("ca-cer")
+func (s *FileStore) WriteCaConfiguration(config model.Config) error {
+	src := s.getFolderByName("ca-cer")
 
-	file, err := os.OpenFile(
-		filepath.Join(src.path, "config.json"), os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	content, err := json.Marshal(config)
 	if err != nil {
 		logger.Error("%v", err)
 		return err
 	}
-	defer file.Close()
 
-	encoder := json.NewEncoder(file)
-	err = encoder.Encode(config)
+	err = s.writeFile(filepath.Join(src.path, "config.json"), append(content, '\n'), 0600)
 	if err != nil {
 		logger.Error("%v", err)
 		return err
@@ -0,0 +1,54 @@
+package data
+
+import (
+	"os"
+	"path/filepath"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+const journalFile = "journal.json"
+
+// ReadJournal returns the operation left by an interrupted run, nil if there is none.
+func (s *FileStore) ReadJournal() (*model.JournalEntry, error) {
+	src := s.getFolderByName("ca-cer")
+	if found, err := s.exists(filepath.Join(src.path, journalFile)); err == nil && !found {
+		return nil, nil
+	}
+
+	var entry model.JournalEntry
+	if err := s.readJsonFromStore(journalFile, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (s *FileStore) WriteJournal(entry model.JournalEntry) error {
+	return s.writeJsonToStore(journalFile, entry)
+}
+
+func (s *FileStore) ClearJournal() error {
+	src := s.getFolderByName("ca-cer")
+	err := s.remove(filepath.Join(src.path, journalFile))
+	if err != nil && !os.IsNotExist(err) {
+		logger.Error("%v", err)
+		return err
+	}
+	return nil
+}
+
+// resolve returns the path of a file reference, the references returned by the store are paths and
+// the names of StoreFileName are relative to the store.
+func (s *FileStore) resolve(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.StorePath, filepath.FromSlash(name))
+}
+
+// StoreFileExists reports if the file relative to the store exists.
+func (s *FileStore) StoreFileExists(name string) bool {
+	found, _ := s.exists(s.resolve(name))
+	return found
+}
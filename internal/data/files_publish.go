@@ -0,0 +1,95 @@
+package data
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// pemSuffix is appended to the name of a published certificate or CRL for its PEM encoded copy.
+const pemSuffix = ".pem"
+
+// publishedPEMTypes are the PEM types of the files published DER encoded, by extension.
+var publishedPEMTypes = map[string]string{
+	".cer": "CERTIFICATE",
+	".crt": "CERTIFICATE",
+	".crl": "X509 CRL",
+}
+
+// Publish copies the store file src to the publish folder, the previous file is moved to .old.
+func (s *FileStore) Publish(src string, destName string) error {
+	content, err := s.readFile(s.resolve(src))
+	if err != nil {
+		return err
+	}
+	return s.publish(content, destName)
+}
+
+// PublishRawCertificate writes a single DER encoded certificate to the publish folder.
+func (s *FileStore) PublishRawCertificate(certBytes []byte, destName string) error {
+	return s.publish(certBytes, destName)
+}
+
+// publish writes a file to the publish folder and copies it to the publish targets. Certificates and CRLs
+// are written DER encoded, as clients of the AIA and CDP URLs expect, with a PEM encoded copy,
+// name.crl is copied to name.crl.pem.
+func (s *FileStore) publish(content []byte, destName string) error {
+	destName = filepath.Clean(destName)
+	destFolder := s.getFolderByName("ca-publish")
+
+	files := []model.BundleFile{{Name: destName, Data: content}}
+	if pemType, ok := publishedPEMTypes[strings.ToLower(filepath.Ext(destName))]; ok {
+		der := content
+		if block, _ := pem.Decode(content); block != nil {
+			der = block.Bytes
+		}
+		files = []model.BundleFile{
+			{Name: destName, Data: der},
+			{Name: destName + pemSuffix, Data: pem.EncodeToMemory(&pem.Block{Type: pemType, Bytes: der})},
+		}
+	}
+	for _, f := range files {
+		if err := s.writePublished(*destFolder, f.Name, f.Data); err != nil {
+			return err
+		}
+	}
+	s.pushPublished(files)
+	return nil
+}
+
+func (s *FileStore) writePublished(f folder, name string, content []byte) error {
+	s.moveOld(f, name)
+	err := s.writeFile(filepath.Join(f.path, name), content, 0666)
+	if err != nil {
+		logger.Error("%v", err)
+	}
+	return err
+}
+
+// ReadPublished returns the content and the modification time of a file in the publish folder.
+// Only files directly in the folder are returned, the error wraps fs.ErrNotExist for all other names.
+func (s *FileStore) ReadPublished(name string) ([]byte, time.Time, error) {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return nil, time.Time{}, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	path := filepath.Join(s.getFolderByName("ca-publish").path, name)
+
+	info, err := s.fs(path).Stat(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if info.IsDir() {
+		return nil, time.Time{}, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	content, err := s.fs(path).ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return content, info.ModTime(), nil
+}
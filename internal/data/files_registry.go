@@ -0,0 +1,110 @@
+package data
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// ReadSubCaRegistry returns the CA certificates issued by this CA, the registry is empty for a new CA.
+func (s *FileStore) ReadSubCaRegistry() ([]model.SubCa, error) {
+	var subs []model.SubCa
+	err := s.readJsonFromStore("subs.json", &subs)
+	return subs, err
+}
+
+func (s *FileStore) WriteSubCaRegistry(subs []model.SubCa) error {
+	return s.writeJsonToStore("subs.json", subs)
+}
+
+// ReadRevocations returns the revocation details like the reason of revoked certificates.
+func (s *FileStore) ReadRevocations() ([]model.Revocation, error) {
+	var revocations []model.Revocation
+	err := s.readJsonFromStore("revocations.json", &revocations)
+	return revocations, err
+}
+
+func (s *FileStore) WriteRevocations(revocations []model.Revocation) error {
+	return s.writeJsonToStore("revocations.json", revocations)
+}
+
+// ReadRequestRegistry returns the records of the requests submitted through the API.
+func (s *FileStore) ReadRequestRegistry() ([]model.RequestRecord, error) {
+	var requests []model.RequestRecord
+	err := s.readJsonFromStore("requests.json", &requests)
+	return requests, err
+}
+
+func (s *FileStore) WriteRequestRegistry(requests []model.RequestRecord) error {
+	return s.writeJsonToStore("requests.json", requests)
+}
+
+// WriteRawRevokedCertificate places a copy of an issued certificate in the revoked store.
+func (s *FileStore) WriteRawRevokedCertificate(certBytes []byte, filename string) (string, error) {
+
+	folder := s.getFolderByName("ca-revoked")
+	path := filepath.Join(folder.path, filename+".cer")
+
+	err := s.writeRawX509Cert(certBytes, path)
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// ReadIssuedCertificate returns the content of a certificate file in the issued store.
+func (s *FileStore) ReadIssuedCertificate(filename string) ([]byte, error) {
+	src := s.getFolderByName("ca-issued")
+	return s.readFile(filepath.Join(src.path, filepath.Base(filename)))
+}
+
+func (s *FileStore) readJsonFromStore(filename string, v any) error {
+	return s.readJsonFromFolder("ca-cer", filename, v)
+}
+
+func (s *FileStore) writeJsonToStore(filename string, v any) error {
+	return s.writeJsonToFolder("ca-cer", filename, v)
+}
+
+// readJsonFromFolder decodes the file in the store folder into v, v is left untouched if the file does not exist.
+func (s *FileStore) readJsonFromFolder(folderName string, filename string, v any) error {
+	src := s.getFolderByName(folderName)
+	path := filepath.Join(src.path, filename)
+
+	_, err := s.fs(path).Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	content, err := s.readFile(path)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(content, v); err != nil {
+		logger.Error("%v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) writeJsonToFolder(folderName string, filename string, v any) error {
+	src := s.getFolderByName(folderName)
+
+	content, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("%v", err)
+		return err
+	}
+
+	err = s.writeFile(filepath.Join(src.path, filename), append(content, '\n'), 0600)
+	if err != nil {
+		logger.Error("%v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package data
+
+import (
+	"path/filepath"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+const (
+	scepKeyFile        = "scep_ra.key"
+	scepCertFile       = "ra.cer"
+	scepChallengesFile = "challenges.json"
+)
+
+// IsSCEPConfigured reports if the store holds the key of a SCEP RA.
+func (s *FileStore) IsSCEPConfigured() bool {
+	found, err := s.exists(filepath.Join(s.getFolderByName("ca-key").path, scepKeyFile))
+	if err != nil {
+		logger.Warning("Could not read scep key file: %v", err)
+	}
+	return found
+}
+
+// WriteSCEPKey stores the encrypted RA key next to the CA key.
+func (s *FileStore) WriteSCEPKey(encryptedKey []byte) error {
+	src := s.getFolderByName("ca-key")
+	s.moveOld(*src, scepKeyFile)
+
+	err := s.writeFile(filepath.Join(src.path, scepKeyFile), encryptedKey, 0600)
+	if err != nil {
+		logger.Error("Failed to write scep key file %v", err)
+		return err
+	}
+	logger.Info("Wrote %d bytes encrypted scep key.", len(encryptedKey))
+	return nil
+}
+
+func (s *FileStore) WriteSCEPKeyNonce(nonce []byte) error {
+	src := s.getFolderByName("ca-key")
+	s.moveOld(*src, scepKeyFile+".nonce")
+
+	err := s.writeFile(filepath.Join(src.path, scepKeyFile+".nonce"), nonce, 0600)
+	if err != nil {
+		logger.Error("%v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) ReadSCEPKey() ([]byte, error) {
+	src := s.getFolderByName("ca-key")
+	return s.readFile(filepath.Join(src.path, scepKeyFile))
+}
+
+func (s *FileStore) ReadSCEPKeyNonce() ([]byte, error) {
+	src := s.getFolderByName("ca-key")
+	return s.readFile(filepath.Join(src.path, scepKeyFile+".nonce"))
+}
+
+// ReadSCEPCertificate returns the PEM encoded RA certificate.
+func (s *FileStore) ReadSCEPCertificate() ([]byte, error) {
+	return s.readFile(filepath.Join(s.getFolderByName("scep").path, scepCertFile))
+}
+
+func (s *FileStore) WriteSCEPCertificate(cert []byte) error {
+	folder := s.getFolderByName("scep")
+	s.moveOld(*folder, scepCertFile)
+
+	if err := s.writeFile(filepath.Join(folder.path, scepCertFile), cert, 0644); err != nil {
+		logger.Error("%v", err)
+		return err
+	}
+	return nil
+}
+
+// ReadSCEPChallenges returns the unused challenge passwords.
+func (s *FileStore) ReadSCEPChallenges() ([]model.SCEPChallenge, error) {
+	var challenges []model.SCEPChallenge
+	err := s.readJsonFromFolder("scep", scepChallengesFile, &challenges)
+	return challenges, err
+}
+
+func (s *FileStore) WriteSCEPChallenges(challenges []model.SCEPChallenge) error {
+	return s.writeJsonToFolder("scep", scepChallengesFile, challenges)
+}
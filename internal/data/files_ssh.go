@@ -0,0 +1,153 @@
+package data
+
+import (
+	"path/filepath"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+const (
+	sshKeyFile         = "ssh_ca.key"
+	sshConfigFile      = "ssh.json"
+	sshRevocationsFile = "revocations.json"
+)
+
+// IsSSHConfigured reports if the store holds the key of a SSH CA.
+func (s *FileStore) IsSSHConfigured() bool {
+	found, err := s.exists(filepath.Join(s.getFolderByName("ca-key").path, sshKeyFile))
+	if err != nil {
+		logger.Warning("Could not read ssh key file: %v", err)
+	}
+	return found
+}
+
+// WriteSSHKey stores the encrypted SSH CA key next to the X.509 CA key.
+func (s *FileStore) WriteSSHKey(encryptedKey []byte) error {
+	src := s.getFolderByName("ca-key")
+	s.moveOld(*src, sshKeyFile)
+
+	err := s.writeFile(filepath.Join(src.path, sshKeyFile), encryptedKey, 0600)
+	if err != nil {
+		logger.Error("Failed to write ssh key file %v", err)
+		return err
+	}
+	logger.Info("Wrote %d bytes encrypted ssh key.", len(encryptedKey))
+	return nil
+}
+
+func (s *FileStore) WriteSSHKeyNonce(nonce []byte) error {
+	src := s.getFolderByName("ca-key")
+	s.moveOld(*src, sshKeyFile+".nonce")
+
+	err := s.writeFile(filepath.Join(src.path, sshKeyFile+".nonce"), nonce, 0600)
+	if err != nil {
+		logger.Error("%v", err)
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) ReadSSHKey() ([]byte, error) {
+	src := s.getFolderByName("ca-key")
+	return s.readFile(filepath.Join(src.path, sshKeyFile))
+}
+
+func (s *FileStore) ReadSSHKeyNonce() ([]byte, error) {
+	src := s.getFolderByName("ca-key")
+	return s.readFile(filepath.Join(src.path, sshKeyFile+".nonce"))
+}
+
+// ReadSSHConfiguration returns the profiles and counters of the SSH CA, it is empty before the setup.
+func (s *FileStore) ReadSSHConfiguration() (model.SSHConfig, error) {
+	var conf model.SSHConfig
+	err := s.readJsonFromFolder("ssh", sshConfigFile, &conf)
+	return conf, err
+}
+
+func (s *FileStore) WriteSSHConfiguration(conf model.SSHConfig) error {
+	return s.writeJsonToFolder("ssh", sshConfigFile, conf)
+}
+
+// ReadSSHRevocations returns the revoked SSH certificates.
+func (s *FileStore) ReadSSHRevocations() ([]model.SSHRevocation, error) {
+	var revocations []model.SSHRevocation
+	err := s.readJsonFromFolder("ssh", sshRevocationsFile, &revocations)
+	return revocations, err
+}
+
+func (s *FileStore) WriteSSHRevocations(revocations []model.SSHRevocation) error {
+	return s.writeJsonToFolder("ssh", sshRevocationsFile, revocations)
+}
+
+// GetSSHRequests returns the public keys waiting in the ssh-user and ssh-host folders,
+// the request type is the certificate type.
+func (s *FileStore) GetSSHRequests() []model.FileContentWithPath {
+	var result []model.FileContentWithPath
+
+	for _, certType := range []string{model.SSHUser, model.SSHHost} {
+		src := s.getFolderByName("ssh-" + certType + "-requests")
+
+		files, err := s.getFilesInFolder(src.path)
+		if err != nil {
+			logger.Error("%v", err)
+		}
+
+		for _, f := range files {
+			f.RequestType = certType
+			result = append(result, f)
+		}
+	}
+
+	if len(result) == 0 {
+		logger.Debug("No new ssh keys found to sign")
+		return nil
+	}
+	return result
+}
+
+// WriteSSHCertificate places a signed SSH certificate in the store, the file is named like OpenSSH does.
+func (s *FileStore) WriteSSHCertificate(cert []byte, filename string) (string, error) {
+	folder := s.getFolderByName("ssh-issued")
+	path := filepath.Join(folder.path, filename+"-cert.pub")
+
+	if err := s.writeFile(path, cert, 0644); err != nil {
+		logger.Error("%v", err)
+		return "", err
+	}
+	return path, nil
+}
+
+// GetSSHCertificatesFromCaStore returns all signed SSH certificates.
+func (s *FileStore) GetSSHCertificatesFromCaStore() ([]model.FileContentWithPath, error) {
+	src := s.getFolderByName("ssh-issued")
+
+	files, err := s.getFilesInFolder(src.path)
+	if err != nil {
+		logger.Error("%v", err)
+		return nil, err
+	}
+	return files, nil
+}
+
+// WriteSSHPublicKey stores the public key of the SSH CA in authorized_keys format.
+func (s *FileStore) WriteSSHPublicKey(key []byte) (string, error) {
+	return s.writeSSHFile(key, "ssh_ca.pub")
+}
+
+// WriteKRL stores the key revocation list of the SSH CA.
+func (s *FileStore) WriteKRL(krl []byte) (string, error) {
+	return s.writeSSHFile(krl, "ssh.krl")
+}
+
+func (s *FileStore) writeSSHFile(content []byte, filename string) (string, error) {
+	folder := s.getFolderByName("ssh")
+	s.moveOld(*folder, filename)
+
+	path := filepath.Join(folder.path, filename)
+	if err := s.writeFile(path, content, 0644); err != nil {
+		logger.Error("%v", err)
+		return "", err
+	}
+	return path, nil
+}
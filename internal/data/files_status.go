@@ -0,0 +1,70 @@
+package data
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+const statusSuffix = ".status.json"
+
+// RequestStatusPath returns the path of the status file of a request, host.csr has the status file host.status.json.
+func RequestStatusPath(request string) string {
+	return strings.TrimSuffix(request, filepath.Ext(request)) + statusSuffix
+}
+
+// isStatusFile reports if the file is the status file of a request and no request itself.
+func isStatusFile(name string) bool {
+	return strings.HasSuffix(name, statusSuffix)
+}
+
+// ReadRequestStatusFile reads a status file, the path may also be the path of the request.
+func ReadRequestStatusFile(path string) (model.RequestStatus, error) {
+	if !isStatusFile(path) {
+		path = RequestStatusPath(path)
+	}
+	var status model.RequestStatus
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return status, err
+	}
+	err = json.Unmarshal(content, &status)
+	return status, err
+}
+
+// ReadRequestStatus returns the status of the request file, the status is empty if there is no status file.
+func (s *FileStore) ReadRequestStatus(request string) (model.RequestStatus, error) {
+	var status model.RequestStatus
+	path := RequestStatusPath(request)
+
+	_, err := s.fs(path).Stat(path)
+	if os.IsNotExist(err) {
+		return status, nil
+	}
+	content, err := s.readFile(path)
+	if err != nil {
+		return status, err
+	}
+	err = json.Unmarshal(content, &status)
+	return status, err
+}
+
+// WriteRequestStatus writes the status file next to the request file. The certificate and the chain
+// are named by their file names, the status file holds their paths in the out folder for issued certificates.
+func (s *FileStore) WriteRequestStatus(request string, status model.RequestStatus) error {
+	issued := s.getFolderByName("issued").path
+	if status.Certificate != "" && !filepath.IsAbs(status.Certificate) {
+		status.Certificate = filepath.Join(issued, status.Certificate)
+	}
+	if status.Chain != "" && !filepath.IsAbs(status.Chain) {
+		status.Chain = filepath.Join(issued, status.Chain)
+	}
+	content, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.writeFile(RequestStatusPath(request), content, 0666)
+}
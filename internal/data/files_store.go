@@ -0,0 +1,166 @@
+package data
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// GetStoreFiles returns all files of the CA store including archived files, the names are relative to the store.
+func (s *FileStore) GetStoreFiles() ([]model.BundleFile, error) {
+	var files []model.BundleFile
+
+	err := s.walkDir(s.StorePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if s.isLockPath(path) {
+			return skipLockPath(d)
+		}
+		if !d.Type().IsRegular() || isTempFile(d.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.StorePath, path)
+		if err != nil {
+			return err
+		}
+		content, err := s.readFile(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, model.BundleFile{Name: filepath.ToSlash(rel), Data: content})
+		return nil
+	})
+	if err != nil {
+		logger.Error("%v", err)
+		return nil, err
+	}
+	return files, nil
+}
+
+// IsStoreEmpty reports if the CA store holds no files, empty folders are ignored.
+func (s *FileStore) IsStoreEmpty() (bool, error) {
+	empty := true
+
+	err := s.walkDir(s.StorePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if s.isLockPath(path) {
+			return skipLockPath(d)
+		}
+		if !d.IsDir() {
+			empty = false
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return empty, err
+}
+
+// RestoreStoreFiles writes the files into the CA store, folders are created with the store permissions.
+func (s *FileStore) RestoreStoreFiles(files []model.BundleFile) error {
+	for _, f := range files {
+		rel := filepath.Clean(filepath.FromSlash(f.Name))
+		if filepath.IsAbs(rel) || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("invalid file name %s", f.Name)
+		}
+		path := filepath.Join(s.StorePath, rel)
+
+		s.createAndLogDir(folder{name: "restore", path: filepath.Dir(path), perms: 0700, dirType: "store"})
+		if err := s.writeFile(path, f.Data, 0600); err != nil {
+			logger.Error("%v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteBackup writes an encrypted backup, the default location is the backups folder.
+func (s *FileStore) WriteBackup(dest string, filename string, raw []byte) (string, error) {
+	fsys := fileSystem(osFS{})
+	if dest == "" {
+		folder := s.getFolderByName("backups")
+		s.createAndLogDir(*folder)
+		dest = filepath.Join(folder.path, filename)
+		fsys = s.fs(dest)
+	}
+
+	if err := fsys.WriteFile(dest, raw, 0600); err != nil {
+		logger.Error("%v", err)
+		return "", err
+	}
+	return dest, nil
+}
+
+func ReadBackup(src string) ([]byte, error) {
+	return readFile(src)
+}
+
+// StoreFileName returns the name of a file in a store folder relative to the store, as used by GetStoreFiles.
+func (s *FileStore) StoreFileName(folderName string, filename string) string {
+	folder := s.getFolderByName(folderName)
+	rel, err := filepath.Rel(s.StorePath, filepath.Join(folder.path, filename))
+	if err != nil {
+		return filename
+	}
+	return filepath.ToSlash(rel)
+}
+
+// GetFolderPermissions returns the expected and the actual permissions of all folders.
+func (s *FileStore) GetFolderPermissions() []model.FolderPermission {
+	var result []model.FolderPermission
+	for _, f := range s.folders {
+		p := model.FolderPermission{Name: f.name, Path: f.path, Expected: uint32(f.perms)}
+		info, err := s.fs(f.path).Stat(f.path)
+		if err == nil {
+			p.Exists = true
+			p.Actual = uint32(info.Mode().Perm())
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// RepairFolder creates a missing folder or resets its permissions to the expected ones.
+func (s *FileStore) RepairFolder(name string) error {
+	f := s.getFolderByName(name)
+	if f == nil {
+		return fmt.Errorf("unknown folder %s", name)
+	}
+	s.createAndLogDir(*f)
+	return s.fs(f.path).Chmod(f.path, f.perms)
+}
+
+// GetIssuedCertificatesFromCaStore returns all certificates in the issued store.
+func (s *FileStore) GetIssuedCertificatesFromCaStore() ([]model.FileContentWithPath, error) {
+	src := s.getFolderByName("ca-issued")
+
+	files, err := s.getFilesInFolder(src.path)
+	if err != nil {
+		logger.Error("%v", err)
+		return nil, err
+	}
+	return files, nil
+}
+
+// GetCRLs returns all CRL files in the store, the name of each file is its CRL number.
+func (s *FileStore) GetCRLs() ([]model.FileContentWithPath, error) {
+	src := s.getFolderByName("ca-crl")
+
+	files, err := s.getFilesInFolder(src.path)
+	if err != nil {
+		logger.Error("%v", err)
+		return nil, err
+	}
+	return files, nil
+}
@@ -3,7 +3,6 @@ package data
 import (
 	"os"
 	"path/filepath"
-	"syscall"
 
 	"deleteonerror.com/tyinypki/internal/logger"
 )
@@ -22,50 +21,55 @@ func getAppPath() string {
 	return exeDir
 }
 
-var folders []folder
-
-func initFolders() {
-	folders = []folder{
-		{"ca-cer", filepath.Join(StorePath), 0700, "store"},
-		{"ca-key", filepath.Join(StorePath, "private"), 0700, "store"},     // The folder for Private Keys
-		{"ca-revoked", filepath.Join(StorePath, "revoked"), 0700, "store"}, // The folder for revoked certificates
-		{"ca-issued", filepath.Join(StorePath, "issued"), 0700, "store"},   // The folder for issued certificates
-		{"ca-crl", filepath.Join(StorePath, "crl"), 0700, "store"},         // The folder for issued certificates
-		{"requests", filepath.Join(WorkPath, "reqests"), 0775, "in"},       // The folder for incoming Certificate Requests
-		{"issued", filepath.Join(WorkPath, "certificates"), 0775, "out"},   // Out folder for issued certificates including chains
-		{"revoke", filepath.Join(WorkPath, "revoke"), 0775, "in"},          // In folder for certificates which should be revoked
-		{"ca-publish", filepath.Join(WorkPath, "publish"), 0775, "out"},    // Out folder which contains ca certs and crl's for publishing to aia and cdp
-		{"ca-req", filepath.Join(WorkPath, "reqests", "ca"), 0775, "out"},
-		{"ca-cert-in", filepath.Join(WorkPath, "certificates", "ca"), 0775, "in"},
-		{"webserver-requests", filepath.Join(WorkPath, "reqests", "webserver"), 0775, "in"},
-		{"client-requests", filepath.Join(WorkPath, "reqests", "client"), 0775, "in"},
-		{"code-requests", filepath.Join(WorkPath, "reqests", "code"), 0775, "in"},
-		{"server-requests", filepath.Join(WorkPath, "reqests", "server"), 0775, "in"},
-		{"ocsp-requests", filepath.Join(WorkPath, "reqests", "ocsp"), 0775, "in"},
+func (s *FileStore) initFolders() {
+	s.folders = []folder{
+		{"ca-cer", filepath.Join(s.StorePath), 0700, "store"},
+		{"ca-key", filepath.Join(s.StorePath, "private"), 0700, "store"},     // The folder for Private Keys
+		{"ca-revoked", filepath.Join(s.StorePath, "revoked"), 0700, "store"}, // The folder for revoked certificates
+		{"ca-issued", filepath.Join(s.StorePath, "issued"), 0700, "store"},   // The folder for issued certificates
+		{"ca-crl", filepath.Join(s.StorePath, "crl"), 0700, "store"},         // The folder for issued certificates
+		{"requests", filepath.Join(s.WorkPath, "reqests"), 0775, "in"},       // The folder for incoming Certificate Requests
+		{"issued", filepath.Join(s.WorkPath, "certificates"), 0775, "out"},   // Out folder for issued certificates including chains
+		{"revoke", filepath.Join(s.WorkPath, "revoke"), 0775, "in"},          // In folder for certificates which should be revoked
+		{"ca-publish", filepath.Join(s.WorkPath, "publish"), 0775, "out"},    // Out folder which contains ca certs and crl's for publishing to aia and cdp
+		{"ca-req", filepath.Join(s.WorkPath, "reqests", "ca"), 0775, "out"},
+		{"ca-cert-in", filepath.Join(s.WorkPath, "certificates", "ca"), 0775, "in"},
+		{"bundles", filepath.Join(s.WorkPath, "bundles"), 0775, "out"}, // Out folder for signed transfer bundles
+		{"backups", filepath.Join(s.WorkPath, "backups"), 0700, "out"}, // Out folder for encrypted store backups
+		{"webserver-requests", filepath.Join(s.WorkPath, "reqests", "webserver"), 0775, "in"},
+		{"client-requests", filepath.Join(s.WorkPath, "reqests", "client"), 0775, "in"},
+		{"code-requests", filepath.Join(s.WorkPath, "reqests", "code"), 0775, "in"},
+		{"server-requests", filepath.Join(s.WorkPath, "reqests", "server"), 0775, "in"},
+		{"ocsp-requests", filepath.Join(s.WorkPath, "reqests", "ocsp"), 0775, "in"},
+		{"ssh-user-requests", filepath.Join(s.WorkPath, "reqests", "ssh-user"), 0775, "in"}, // The folder for public keys of users to sign
+		{"ssh-host-requests", filepath.Join(s.WorkPath, "reqests", "ssh-host"), 0775, "in"}, // The folder for public keys of hosts to sign
+		{"ssh", filepath.Join(s.StorePath, "ssh"), 0700, "store"},                           // The folder for the state and the KRL of the SSH CA
+		{"ssh-issued", filepath.Join(s.StorePath, "ssh", "issued"), 0700, "store"},          // The folder for issued SSH certificates
+		{"scep", filepath.Join(s.StorePath, "scep"), 0700, "store"},                         // The folder for the RA certificate, challenges and pending requests of SCEP
 	}
 }
 
-func SetupFolders() {
-	for _, f := range folders {
-		createAndLogDir(f)
+func (s *FileStore) SetupFolders() {
+	for _, f := range s.folders {
+		s.createAndLogDir(f)
 	}
 }
 
 // ensureArchiveFolderExists checks if the archive folder (named ".old") exists in the given path.
 // If the folder does not exist, it creates the folder and sets the permissions to match the parent directory.
 // If there are any issues in retrieving the directory info or creating the folder, an error will be returned.
-func ensureArchiveFolderExists(path string) error {
-	src, err := os.Stat(path)
+func (s *FileStore) ensureArchiveFolderExists(path string) error {
+	src, err := s.fs(path).Stat(path)
 	if err != nil {
 		logger.Error("Failed to get directory info: %v", err)
 		return err
 	}
 
 	archive := filepath.Join(path, ".old")
-	_, err = os.Stat(archive)
+	_, err = s.fs(archive).Stat(archive)
 	if err != nil {
 		if os.IsNotExist(err) {
-			createAndLogDir(folder{perms: src.Mode().Perm(), path: archive})
+			s.createAndLogDir(folder{perms: src.Mode().Perm(), path: archive})
 			return nil
 		}
 		logger.Error("Failed to get directory info: %v", err)
@@ -76,15 +80,13 @@ func ensureArchiveFolderExists(path string) error {
 
 // createAndLogDir creates a new directory specified by the given folder structure 'f'.
 // It ensures that the directory is created with the permissions defined in 'f'.
-func createAndLogDir(f folder) {
-	oldUmask := syscall.Umask(0)
-	err := os.MkdirAll(f.path, f.perms)
+func (s *FileStore) createAndLogDir(f folder) {
+	err := s.fs(f.path).MkdirAll(f.path, f.perms)
 	if err != nil {
 		logger.Error("Failed to create directory %v", err)
 	} else {
 		logger.Debug("Created %s as %s directory at %s with permissions %v", f.name, f.dirType, f.path, f.perms)
 	}
-	syscall.Umask(oldUmask)
 }
 
 // folder represents a directory structure within the filesystem.
@@ -99,13 +101,13 @@ type folder struct {
 	dirType string
 }
 
-func GetPathByName(name string) string {
-	folder := getFolderByName(name)
+func (s *FileStore) GetPathByName(name string) string {
+	folder := s.getFolderByName(name)
 	return folder.path
 }
 
-func getFolderByName(name string) *folder {
-	for _, f := range folders {
+func (s *FileStore) getFolderByName(name string) *folder {
+	for _, f := range s.folders {
 		if f.name == name {
 			return &f
 		}
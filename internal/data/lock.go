@@ -0,0 +1,209 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+const (
+	lockFile    = ".lock"
+	holdersPath = ".lock.d"
+)
+
+// ErrLocked is returned if another process holds a conflicting store lock.
+var ErrLocked = errors.New("store is locked")
+
+// StoreLock is an advisory lock on the CA store held by this process.
+type StoreLock struct {
+	release func()
+	holder  string
+	fsys    fileSystem
+}
+
+// LockStore takes a shared lock for reading or an exclusive lock for changing the store.
+// If another process holds a conflicting lock, it waits up to LockWait and names the holders on failure.
+func (s *FileStore) LockStore(exclusive bool) (*StoreLock, error) {
+	if err := s.storeFS.MkdirAll(s.StorePath, 0700); err != nil {
+		logger.Error("%v", err)
+		return nil, err
+	}
+
+	deadline := time.Now().Add(s.LockWait)
+	waiting := false
+	var release func()
+	for {
+		var err error
+		release, err = s.storeFS.Lock(filepath.Join(s.StorePath, lockFile), exclusive)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrLocked) {
+			logger.Error("%v", err)
+			return nil, err
+		}
+		if !time.Now().Before(deadline) {
+			return nil, s.lockedError(exclusive)
+		}
+		if !waiting {
+			logger.Info("%v, waiting up to %s", s.lockedError(exclusive), s.LockWait)
+			waiting = true
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	s.removeStaleHolders(exclusive)
+
+	holder, err := s.writeHolder(exclusive)
+	if err != nil {
+		logger.Warning("Could not record lock holder: %v", err)
+	}
+	logger.Debug("Store locked (%s)", model.LockHolder{Exclusive: exclusive}.Mode())
+
+	return &StoreLock{release: release, holder: holder, fsys: s.storeFS}, nil
+}
+
+// Release removes the holder record and releases the lock.
+func (l *StoreLock) Release() {
+	if l == nil || l.release == nil {
+		return
+	}
+	if l.holder != "" {
+		l.fsys.Remove(l.holder)
+	}
+	l.release()
+	l.release = nil
+}
+
+// GetLockHolders returns the recorded holders of the store lock.
+func (s *FileStore) GetLockHolders() []model.LockHolder {
+	var holders []model.LockHolder
+
+	entries, err := s.storeFS.ReadDir(filepath.Join(s.StorePath, holdersPath))
+	if err != nil {
+		return nil
+	}
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		content, err := s.storeFS.ReadFile(filepath.Join(s.StorePath, holdersPath, e.Name()))
+		if err != nil {
+			continue
+		}
+		var holder model.LockHolder
+		if err := json.Unmarshal(content, &holder); err != nil {
+			continue
+		}
+		holders = append(holders, holder)
+	}
+	return holders
+}
+
+// isLockPath reports if the path belongs to the lock file or the holder records, they are not part of the store content.
+func (s *FileStore) isLockPath(path string) bool {
+	return path == filepath.Join(s.StorePath, lockFile) || path == filepath.Join(s.StorePath, holdersPath)
+}
+
+func skipLockPath(d os.DirEntry) error {
+	if d.IsDir() {
+		return filepath.SkipDir
+	}
+	return nil
+}
+
+func (s *FileStore) lockedError(exclusive bool) error {
+	holders := s.GetLockHolders()
+	if len(holders) == 0 {
+		return fmt.Errorf("%w by another process", ErrLocked)
+	}
+
+	var names []string
+	for _, h := range holders {
+		if !exclusive && !h.Exclusive {
+			continue
+		}
+		names = append(names, fmt.Sprintf("%s (pid %d on %s since %s, %s)",
+			h.Command, h.PID, h.Host, h.Started.Format(time.RFC3339), h.Mode()))
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("%w by another process", ErrLocked)
+	}
+	return fmt.Errorf("%w by %s", ErrLocked, strings.Join(names, ", "))
+}
+
+func (s *FileStore) writeHolder(exclusive bool) (string, error) {
+	dir := filepath.Join(s.StorePath, holdersPath)
+	if err := s.storeFS.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	host, _ := os.Hostname()
+	holder := model.LockHolder{
+		PID:       os.Getpid(),
+		Host:      host,
+		Started:   time.Now().UTC(),
+		Exclusive: exclusive,
+		Command:   strings.Join(append([]string{filepath.Base(os.Args[0])}, os.Args[1:]...), " "),
+	}
+
+	content, err := json.Marshal(holder)
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, host+"-"+strconv.Itoa(holder.PID)+".json")
+	return path, s.storeFS.WriteFile(path, content, 0600)
+}
+
+// removeStaleHolders deletes holder records of processes which no longer hold the lock.
+// With the exclusive lock taken every other record is stale, otherwise records of
+// processes on this host that are gone.
+func (s *FileStore) removeStaleHolders(exclusive bool) {
+	dir := filepath.Join(s.StorePath, holdersPath)
+	host, _ := os.Hostname()
+
+	for _, h := range s.GetLockHolders() {
+		if h.Host == host && h.PID == os.Getpid() {
+			continue
+		}
+		if !exclusive && (h.Host != host || processAlive(h.PID)) {
+			continue
+		}
+		path := filepath.Join(dir, h.Host+"-"+strconv.Itoa(h.PID)+".json")
+		if err := s.storeFS.Remove(path); err == nil {
+			logger.Debug("Removed stale lock holder pid %d on %s", h.PID, h.Host)
+		}
+	}
+}
+
+func tryLock(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrLocked
+	}
+	return err
+}
+
+func unlock(file *os.File) {
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
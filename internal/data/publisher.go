@@ -0,0 +1,198 @@
+package data
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+const publicationStatusFile = "publication.json"
+
+// defaultPublishAttempts is the number of tries of a copy to a publish target, the pause between
+// the tries doubles starting with publishRetryDelay.
+const (
+	defaultPublishAttempts = 3
+	publishRetryDelay      = 2 * time.Second
+)
+
+// Publisher copies published files to a publish target.
+type Publisher interface {
+	// Put copies the files to the target, existing files are replaced.
+	Put(files []model.BundleFile) error
+}
+
+// NewPublisher returns the publisher of a publish target for the files of the CA. The ldap publisher
+// names its entries after the configured name of the CA and writes only the files of its certificate.
+func NewPublisher(target model.PublishTarget, caName string, caCert *x509.Certificate) (Publisher, error) {
+	switch target.Type {
+	case model.PublishTargetDir:
+		if target.Path == "" {
+			return nil, fmt.Errorf("publish target %s has no path", target.DisplayName())
+		}
+		return dirPublisher{path: target.Path}, nil
+	case model.PublishTargetWebDAV:
+		return newWebDAVPublisher(target)
+	case model.PublishTargetSFTP:
+		return newSFTPPublisher(target)
+	case model.PublishTargetLDAP:
+		return newLDAPPublisher(target, caName, caCert)
+	default:
+		return nil, fmt.Errorf("publish target %s has the unknown type %q", target.DisplayName(), target.Type)
+	}
+}
+
+// dirPublisher mirrors the published files to a local folder, e.g. the document root of a web server.
+type dirPublisher struct {
+	path string
+}
+
+func (p dirPublisher) Put(files []model.BundleFile) error {
+	if err := os.MkdirAll(p.path, 0755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := writeFileAtomic(filepath.Join(p.path, f.Name), f.Data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadPublicationStatus returns the outcome of the last copies to the publish targets.
+func (s *FileStore) ReadPublicationStatus() ([]model.PublicationStatus, error) {
+	var status []model.PublicationStatus
+	err := s.readJsonFromStore(publicationStatusFile, &status)
+	return status, err
+}
+
+// SyncPublished copies all files of the publish folder to the publish targets.
+func (s *FileStore) SyncPublished() error {
+	config, err := s.ReadCaConfiguration()
+	if err != nil {
+		return err
+	}
+	f := s.getFolderByName("ca-publish")
+	entries, err := s.fs(f.path).ReadDir(f.path)
+	if err != nil {
+		return err
+	}
+	var files []model.BundleFile
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		content, err := s.readFile(filepath.Join(f.path, e.Name()))
+		if err != nil {
+			return err
+		}
+		files = append(files, model.BundleFile{Name: e.Name(), Data: content})
+	}
+	return s.pushToTargets(config, files)
+}
+
+// pushPublished copies newly published files to the publish targets. A failed copy is logged and kept
+// in the publication status, the local publication stands.
+func (s *FileStore) pushPublished(files []model.BundleFile) {
+	config, err := s.ReadCaConfiguration()
+	if err != nil || len(config.PublishTargets) == 0 {
+		return
+	}
+	s.pushToTargets(config, files)
+}
+
+// pushToTargets copies the files to each target together with the files whose copy failed before,
+// and records the outcome in the publication status.
+func (s *FileStore) pushToTargets(config model.Config, files []model.BundleFile) error {
+	last, err := s.ReadPublicationStatus()
+	if err != nil {
+		logger.Warning("Unreadable publication status: %v", err)
+	}
+	var caCert *x509.Certificate
+	if der, err := s.ReadCaCertificate(); err == nil {
+		caCert, _ = x509.ParseCertificate(der)
+	}
+
+	var failed error
+	status := make([]model.PublicationStatus, 0, len(config.PublishTargets))
+	for _, target := range config.PublishTargets {
+		name := target.DisplayName()
+		current := model.PublicationStatus{Target: name}
+		if i := slices.IndexFunc(last, func(st model.PublicationStatus) bool { return st.Target == name }); i >= 0 {
+			current = last[i]
+		}
+
+		pending := s.withFailedFiles(files, current.Failed)
+		err := putWithRetry(target, config.Name, caCert, pending)
+		now := time.Now().UTC()
+		if err != nil {
+			logger.Error("Publication to %s failed: %v", name, err)
+			failed = errors.Join(failed, fmt.Errorf("%s: %w", name, err))
+			current.LastFailure = &now
+			current.Error = err.Error()
+			current.Failed = nil
+			for _, f := range pending {
+				current.Failed = append(current.Failed, f.Name)
+			}
+		} else {
+			logger.Debug("Published %d files to %s", len(pending), name)
+			current.LastSuccess = &now
+			current.Error = ""
+			current.Failed = nil
+		}
+		status = append(status, current)
+	}
+
+	if err := s.writeJsonToStore(publicationStatusFile, status); err != nil {
+		logger.Error("Failed to write the publication status: %v", err)
+	}
+	return failed
+}
+
+// withFailedFiles adds the files of the publish folder whose last copy failed to the files,
+// files removed from the publish folder since are dropped.
+func (s *FileStore) withFailedFiles(files []model.BundleFile, failed []string) []model.BundleFile {
+	all := slices.Clone(files)
+	for _, name := range failed {
+		if slices.ContainsFunc(all, func(f model.BundleFile) bool { return f.Name == name }) {
+			continue
+		}
+		content, _, err := s.ReadPublished(name)
+		if err != nil {
+			logger.Debug("Skipping %s: %v", name, err)
+			continue
+		}
+		all = append(all, model.BundleFile{Name: name, Data: content})
+	}
+	return all
+}
+
+// putWithRetry copies the files to the target, failed copies are tried again after a pause.
+func putWithRetry(target model.PublishTarget, caName string, caCert *x509.Certificate, files []model.BundleFile) error {
+	publisher, err := NewPublisher(target, caName, caCert)
+	if err != nil {
+		return err
+	}
+	attempts := target.Attempts
+	if attempts <= 0 {
+		attempts = defaultPublishAttempts
+	}
+
+	delay := publishRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = publisher.Put(files)
+		if err == nil || attempt >= attempts {
+			return err
+		}
+		logger.Warning("Publication to %s failed, attempt %d of %d: %v", target.DisplayName(), attempt, attempts, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
@@ -0,0 +1,73 @@
+package data
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// webdavPublisher uploads the files with HTTP PUT into a WebDAV collection or to any server accepting PUT.
+type webdavPublisher struct {
+	base     *url.URL
+	user     string
+	password string
+	client   *http.Client
+}
+
+func newWebDAVPublisher(target model.PublishTarget) (*webdavPublisher, error) {
+	base, err := url.Parse(target.URL)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("publish target %s needs a http or https URL", target.DisplayName())
+	}
+	p := &webdavPublisher{
+		base:   base,
+		user:   target.User,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	if target.PasswordEnv != "" {
+		p.password = os.Getenv(target.PasswordEnv)
+	}
+	return p, nil
+}
+
+func (p *webdavPublisher) Put(files []model.BundleFile) error {
+	for _, f := range files {
+		if err := p.put(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *webdavPublisher) put(f model.BundleFile) error {
+	dest := p.base.JoinPath(f.Name)
+	req, err := http.NewRequest(http.MethodPut, dest.String(), bytes.NewReader(f.Data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if p.user != "" {
+		req.SetBasicAuth(p.user, p.password)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("PUT %s: %s", dest.Redacted(), resp.Status)
+	}
+	return nil
+}
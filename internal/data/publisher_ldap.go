@@ -0,0 +1,389 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// ldapPublisher writes the certificate and the CRL of the CA into the attributes of existing LDAP entries,
+// the classic locations of ldap:// AIA and CDP URLs in Active Directory and OpenLDAP.
+type ldapPublisher struct {
+	addr       string
+	tls        bool
+	serverName string
+	bindDN     string
+	password   string
+	caDN       string
+	crlDN      string
+	// caCert picks the files of this CA from the publish folder
+	caCert *x509.Certificate
+	root   bool
+}
+
+func newLDAPPublisher(target model.PublishTarget, caName string, caCert *x509.Certificate) (*ldapPublisher, error) {
+	u, err := url.Parse(target.URL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Hostname() == "" {
+		return nil, fmt.Errorf("publish target %s needs a ldap://host or ldaps://host URL", target.DisplayName())
+	}
+	if target.CaDN == "" && target.CrlDN == "" {
+		return nil, fmt.Errorf("publish target %s has neither ca_dn nor crl_dn", target.DisplayName())
+	}
+	if caCert == nil {
+		return nil, fmt.Errorf("publish target %s needs the certificate of the CA", target.DisplayName())
+	}
+	// {ca} is the lower case name of the URL templates, a DN with it would miss the entry the certificates name
+	if strings.Contains(target.CaDN, "{ca}") || strings.Contains(target.CrlDN, "{ca}") {
+		return nil, fmt.Errorf("publish target %s uses {ca} in a DN, use {ca_name} like the ldap URL templates", target.DisplayName())
+	}
+	port := u.Port()
+	if port == "" {
+		port = "389"
+		if u.Scheme == "ldaps" {
+			port = "636"
+		}
+	}
+	p := &ldapPublisher{
+		addr:       net.JoinHostPort(u.Hostname(), port),
+		tls:        u.Scheme == "ldaps",
+		serverName: u.Hostname(),
+		bindDN:     target.User,
+		caDN:       LDAPEntryDN(target.CaDN, caName),
+		crlDN:      LDAPEntryDN(target.CrlDN, caName),
+		caCert:     caCert,
+		root:       bytes.Equal(caCert.RawIssuer, caCert.RawSubject) && caCert.CheckSignatureFrom(caCert) == nil,
+	}
+	if target.PasswordEnv != "" {
+		p.password = os.Getenv(target.PasswordEnv)
+	}
+	return p, nil
+}
+
+// ldapModification replaces the attributes of an entry with the value.
+type ldapModification struct {
+	dn    string
+	attrs []string
+	value []byte
+}
+
+// Put writes the certificate and the CRLs of the CA, the certificates and CRLs of other CAs in the
+// publish folder, e.g. of the root in the shared folder of a sub CA, are skipped.
+func (p *ldapPublisher) Put(files []model.BundleFile) error {
+	var mods []ldapModification
+	for _, f := range files {
+		var m ldapModification
+		switch ext := filepath.Ext(f.Name); {
+		case (ext == ".cer" || ext == ".crt") && p.caDN != "":
+			if !bytes.Equal(f.Data, p.caCert.Raw) {
+				continue
+			}
+			m = ldapModification{dn: p.caDN, attrs: []string{"cACertificate;binary"}}
+		case ext == ".crl" && p.crlDN != "":
+			crl, err := x509.ParseRevocationList(f.Data)
+			if err != nil || crl.CheckSignatureFrom(p.caCert) != nil {
+				continue
+			}
+			m = ldapModification{dn: p.crlDN, attrs: []string{"certificateRevocationList;binary"}}
+			// the CRL of a root covers the CA certificates it issued, clients look for it as ARL
+			if p.root {
+				m.attrs = append(m.attrs, "authorityRevocationList;binary")
+			}
+		default:
+			continue
+		}
+		m.value = f.Data
+		// URL templates may publish the same file under several names
+		if slices.ContainsFunc(mods, func(o ldapModification) bool { return o.dn == m.dn && o.attrs[0] == m.attrs[0] }) {
+			continue
+		}
+		mods = append(mods, m)
+	}
+	if len(mods) == 0 {
+		return nil
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	var conn net.Conn
+	var err error
+	if p.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", p.addr, &tls.Config{ServerName: p.serverName})
+	} else {
+		conn, err = dialer.Dial("tcp", p.addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+
+	c := &ldapConn{w: conn, r: bufio.NewReader(conn)}
+	if p.bindDN != "" {
+		if err := c.bind(p.bindDN, p.password); err != nil {
+			return err
+		}
+	}
+	for _, m := range mods {
+		if err := c.modify(m); err != nil {
+			return err
+		}
+	}
+	c.unbind()
+	return nil
+}
+
+// LDAPv3 protocol operations of RFC 4511, the application tags of the requests and responses.
+const (
+	ldapBindRequest    = 0
+	ldapBindResponse   = 1
+	ldapUnbindRequest  = 2
+	ldapModifyRequest  = 6
+	ldapModifyResponse = 7
+	ldapReplace        = 2
+	ldapMaxMessage     = 1 << 20
+)
+
+type ldapMessage struct {
+	ID int
+	Op asn1.RawValue
+}
+
+type ldapBind struct {
+	Version  int
+	Name     []byte
+	Password []byte `asn1:"tag:0"`
+}
+
+type ldapModify struct {
+	Object  []byte
+	Changes []ldapChange
+}
+
+type ldapChange struct {
+	Operation    asn1.Enumerated
+	Modification ldapAttribute
+}
+
+type ldapAttribute struct {
+	Type   []byte
+	Values [][]byte `asn1:"set"`
+}
+
+// ldapConn is a minimal LDAP client which sends one request at a time.
+type ldapConn struct {
+	w  io.Writer
+	r  *bufio.Reader
+	id int
+}
+
+func (c *ldapConn) bind(dn string, password string) error {
+	return c.request("bind", ldapBindRequest, ldapBindResponse, ldapBind{Version: 3, Name: []byte(dn), Password: []byte(password)})
+}
+
+func (c *ldapConn) modify(m ldapModification) error {
+	req := ldapModify{Object: []byte(m.dn)}
+	for _, attr := range m.attrs {
+		req.Changes = append(req.Changes, ldapChange{
+			Operation:    ldapReplace,
+			Modification: ldapAttribute{Type: []byte(attr), Values: [][]byte{m.value}},
+		})
+	}
+	return c.request("modify "+m.dn, ldapModifyRequest, ldapModifyResponse, req)
+}
+
+// unbind closes the session, the server sends no response.
+func (c *ldapConn) unbind() {
+	c.id++
+	msg, err := asn1.Marshal(ldapMessage{ID: c.id, Op: asn1.RawValue{Class: asn1.ClassApplication, Tag: ldapUnbindRequest}})
+	if err == nil {
+		c.w.Write(msg)
+	}
+}
+
+// request sends the operation and returns the error of the LDAP result of the response.
+func (c *ldapConn) request(name string, tag int, responseTag int, op any) error {
+	c.id++
+	opBytes, err := asn1.MarshalWithParams(op, fmt.Sprintf("application,tag:%d", tag))
+	if err != nil {
+		return err
+	}
+	msg, err := asn1.Marshal(ldapMessage{ID: c.id, Op: asn1.RawValue{FullBytes: opBytes}})
+	if err != nil {
+		return err
+	}
+	if _, err := c.w.Write(msg); err != nil {
+		return err
+	}
+
+	id, opTag, result, err := c.readResponse()
+	if err != nil {
+		return err
+	}
+	if id != c.id || opTag != 0x60|byte(responseTag) {
+		return fmt.Errorf("ldap %s: unexpected response %d to message %d", name, opTag&0x1f, id)
+	}
+	return ldapResultError(name, result)
+}
+
+// readResponse reads a message and returns its id, the tag and the content of the protocol operation.
+// Responses are parsed as BER, servers like Active Directory use lengths DER does not allow.
+func (c *ldapConn) readResponse() (int, byte, []byte, error) {
+	tag, err := c.r.ReadByte()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if tag != 0x30 {
+		return 0, 0, nil, fmt.Errorf("ldap: unexpected message tag %#x", tag)
+	}
+	length, err := ldapReadLength(c.r)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(c.r, msg); err != nil {
+		return 0, 0, nil, err
+	}
+
+	_, idBytes, rest, err := berNext(msg)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	opTag, op, _, err := berNext(rest)
+	return berInt(idBytes), opTag, op, err
+}
+
+// ldapResultError returns the error of an LDAPResult, nil for success.
+func ldapResultError(name string, result []byte) error {
+	_, codeBytes, rest, err := berNext(result)
+	if err != nil {
+		return err
+	}
+	code := berInt(codeBytes)
+	if code == 0 {
+		return nil
+	}
+	_, _, rest, err = berNext(rest)
+	if err != nil {
+		return fmt.Errorf("ldap %s: result %d", name, code)
+	}
+	_, diagnostic, _, _ := berNext(rest)
+	msg := ldapResultNames[code]
+	if msg == "" {
+		msg = fmt.Sprintf("result %d", code)
+	}
+	if len(diagnostic) > 0 {
+		msg += ", " + string(diagnostic)
+	}
+	return fmt.Errorf("ldap %s: %s", name, msg)
+}
+
+var ldapResultNames = map[int]string{
+	16: "no such attribute",
+	17: "undefined attribute type",
+	21: "invalid attribute syntax",
+	32: "no such object",
+	34: "invalid DN syntax",
+	49: "invalid credentials",
+	50: "insufficient access rights",
+	53: "unwilling to perform",
+	65: "object class violation",
+}
+
+var errBERTruncated = errors.New("ldap: truncated ber encoding")
+
+func ldapReadLength(r *bufio.Reader) (int, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b < 0x80 {
+		return int(b), nil
+	}
+	n := int(b & 0x7f)
+	if n == 0 || n > 4 {
+		return 0, fmt.Errorf("ldap: unsupported length encoding %#x", b)
+	}
+	length := 0
+	for i := 0; i < n; i++ {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(b)
+	}
+	if length > ldapMaxMessage {
+		return 0, fmt.Errorf("ldap: message of %d bytes is too large", length)
+	}
+	return length, nil
+}
+
+// berNext returns the tag and the content of the first element of b and the rest. Only the
+// single byte tags and definite lengths of LDAP are supported.
+func berNext(b []byte) (byte, []byte, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errBERTruncated
+	}
+	tag := b[0]
+	length := int(b[1])
+	b = b[2:]
+	if length >= 0x80 {
+		n := length & 0x7f
+		if n == 0 || n > 4 || len(b) < n {
+			return 0, nil, nil, errBERTruncated
+		}
+		length = 0
+		for _, v := range b[:n] {
+			length = length<<8 | int(v)
+		}
+		b = b[n:]
+	}
+	if len(b) < length {
+		return 0, nil, nil, errBERTruncated
+	}
+	return tag, b[:length], b[length:], nil
+}
+
+// berInt decodes the content of a small non negative INTEGER or ENUMERATED.
+func berInt(b []byte) int {
+	v := 0
+	for _, x := range b {
+		v = v<<8 | int(x)
+	}
+	return v
+}
+
+// LDAPEntryDN expands a ca_dn or crl_dn template for the CA, {ca_name} is the name of the CA escaped for a DN.
+// ldap:// URL templates expand {ca_name} to the same DN.
+func LDAPEntryDN(template string, caName string) string {
+	return strings.ReplaceAll(template, "{ca_name}", LDAPEscapeDNValue(caName))
+}
+
+// LDAPEscapeDNValue escapes the special characters of an attribute value of a DN, RFC 4514.
+func LDAPEscapeDNValue(v string) string {
+	var sb strings.Builder
+	for i, r := range v {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, r),
+			i == 0 && (r == ' ' || r == '#'),
+			i == len(v)-1 && r == ' ':
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
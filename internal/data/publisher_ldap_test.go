@@ -0,0 +1,287 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// fakeLDAPOp is a request received by the fake LDAP server.
+type fakeLDAPOp struct {
+	tag    byte
+	bind   ldapBind
+	modify ldapModify
+}
+
+// fakeLDAPServer accepts one connection and answers bind requests with success and modify requests
+// with the result code until the client unbinds or disconnects. The received requests are sent to the channel.
+func fakeLDAPServer(t *testing.T, modifyResult int) (string, <-chan []fakeLDAPOp) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	ops := make(chan []fakeLDAPOp, 1)
+	go func() {
+		var received []fakeLDAPOp
+		defer func() { ops <- received }()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		r := bufio.NewReader(conn)
+		for {
+			msg, err := readFakeLDAPMessage(r)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				t.Errorf("fake ldap: %v", err)
+				return
+			}
+			var m ldapMessage
+			if _, err := asn1.Unmarshal(msg, &m); err != nil {
+				t.Errorf("fake ldap: %v", err)
+				return
+			}
+			op := fakeLDAPOp{tag: m.Op.FullBytes[0]}
+			var responseTag, code int
+			switch m.Op.Tag {
+			case ldapBindRequest:
+				_, err = asn1.UnmarshalWithParams(m.Op.FullBytes, &op.bind, "application,tag:0")
+				responseTag = ldapBindResponse
+			case ldapModifyRequest:
+				_, err = asn1.UnmarshalWithParams(m.Op.FullBytes, &op.modify, "application,tag:6")
+				responseTag, code = ldapModifyResponse, modifyResult
+			case ldapUnbindRequest:
+				received = append(received, op)
+				return
+			default:
+				t.Errorf("fake ldap: unexpected operation %d", m.Op.Tag)
+				return
+			}
+			if err != nil {
+				t.Errorf("fake ldap: %v", err)
+				return
+			}
+			received = append(received, op)
+
+			result, _ := asn1.MarshalWithParams(struct {
+				Code       asn1.Enumerated
+				MatchedDN  []byte
+				Diagnostic []byte
+			}{asn1.Enumerated(code), nil, []byte("fake diagnostic")}, fmt.Sprintf("application,tag:%d", responseTag))
+			response, _ := asn1.Marshal(ldapMessage{ID: m.ID, Op: asn1.RawValue{FullBytes: result}})
+			if _, err := conn.Write(response); err != nil {
+				return
+			}
+		}
+	}()
+	return l.Addr().String(), ops
+}
+
+// readFakeLDAPMessage reads the next LDAPMessage, the client sends DER.
+func readFakeLDAPMessage(r *bufio.Reader) ([]byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if tag != 0x30 {
+		return nil, fmt.Errorf("unexpected message tag %#x", tag)
+	}
+	length, err := ldapReadLength(r)
+	if err != nil {
+		return nil, err
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: body})
+}
+
+// testCA returns a CA certificate issued by the parent, self-signed without a parent, its key and
+// the DER of a CRL signed by it.
+func testCA(t *testing.T, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now(),
+		NextUpdate: time.Now().Add(time.Hour),
+	}, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, crl
+}
+
+func TestLDAPPublisherPut(t *testing.T) {
+	addr, ops := fakeLDAPServer(t, 0)
+	t.Setenv("TINY_TEST_LDAP_PASSWORD", "secret")
+	root, _, crl := testCA(t, "Test Root CA", nil, nil)
+
+	publisher, err := NewPublisher(model.PublishTarget{
+		Type:        model.PublishTargetLDAP,
+		URL:         "ldap://" + addr,
+		User:        "cn=admin,dc=example,dc=com",
+		PasswordEnv: "TINY_TEST_LDAP_PASSWORD",
+		CaDN:        "cn={ca_name},cn=AIA,dc=example,dc=com",
+		CrlDN:       "cn={ca_name},cn=CDP,dc=example,dc=com",
+	}, "Test Root, CA", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// URL templates name the files, the entries are named after the CA
+	err = publisher.Put([]model.BundleFile{
+		{Name: "root.cer", Data: root.Raw},
+		{Name: "root.cer.pem", Data: []byte("-----BEGIN CERTIFICATE-----")},
+		{Name: "root-1.crl", Data: crl},
+		{Name: "root-2.crl", Data: crl},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := <-ops
+	if len(received) != 4 {
+		t.Fatalf("got %d requests, want bind, two modifies and unbind", len(received))
+	}
+	bind := received[0].bind
+	if received[0].tag != 0x60 || bind.Version != 3 || string(bind.Name) != "cn=admin,dc=example,dc=com" || string(bind.Password) != "secret" {
+		t.Errorf("bind = %d %q %q", bind.Version, bind.Name, bind.Password)
+	}
+	checkLDAPModify(t, received[1], `cn=Test Root\, CA,cn=AIA,dc=example,dc=com`, root.Raw, "cACertificate;binary")
+	checkLDAPModify(t, received[2], `cn=Test Root\, CA,cn=CDP,dc=example,dc=com`, crl, "certificateRevocationList;binary", "authorityRevocationList;binary")
+	if received[3].tag != 0x42 {
+		t.Errorf("last request has the tag %#x, want unbind", received[3].tag)
+	}
+}
+
+func TestLDAPPublisherPutSubCA(t *testing.T) {
+	addr, ops := fakeLDAPServer(t, 0)
+	root, rootKey, rootCRL := testCA(t, "Test Root CA", nil, nil)
+	sub, _, crl := testCA(t, "Test Sub CA", root, rootKey)
+
+	publisher, err := NewPublisher(model.PublishTarget{
+		Type:  model.PublishTargetLDAP,
+		URL:   "ldap://" + addr,
+		CaDN:  "cn={ca_name},cn=AIA,dc=example,dc=com",
+		CrlDN: "cn={ca_name},cn=CDP,dc=example,dc=com",
+	}, "Test Sub CA", sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the publish folder of a sub CA holds the files of the root too
+	err = publisher.Put([]model.BundleFile{
+		{Name: "Test Root CA.cer", Data: root.Raw},
+		{Name: "Test Root CA.crl", Data: rootCRL},
+		{Name: "Test Sub CA.cer", Data: sub.Raw},
+		{Name: "Test Sub CA.crl", Data: crl},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := <-ops
+	if len(received) != 3 {
+		t.Fatalf("got %d requests, want two modifies and unbind", len(received))
+	}
+	checkLDAPModify(t, received[0], "cn=Test Sub CA,cn=AIA,dc=example,dc=com", sub.Raw, "cACertificate;binary")
+	// the CRL of a sub CA lists end entity certificates only, it is no ARL
+	checkLDAPModify(t, received[1], "cn=Test Sub CA,cn=CDP,dc=example,dc=com", crl, "certificateRevocationList;binary")
+}
+
+func TestLDAPPublisherPutError(t *testing.T) {
+	addr, ops := fakeLDAPServer(t, 50)
+	root, _, _ := testCA(t, "Test CA", nil, nil)
+
+	publisher, err := NewPublisher(model.PublishTarget{
+		Type: model.PublishTargetLDAP,
+		URL:  "ldap://" + addr,
+		CaDN: "cn={ca_name},dc=example,dc=com",
+	}, "Test CA", root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = publisher.Put([]model.BundleFile{{Name: "Test CA.cer", Data: root.Raw}})
+	if err == nil || !strings.Contains(err.Error(), "insufficient access rights, fake diagnostic") {
+		t.Fatalf("Put error = %v, want insufficient access rights", err)
+	}
+	// without a bind DN the modify is sent anonymously
+	if received := <-ops; len(received) != 1 || received[0].tag != 0x66 {
+		t.Errorf("got %d requests, want the failed modify only", len(received))
+	}
+}
+
+func checkLDAPModify(t *testing.T, op fakeLDAPOp, dn string, value []byte, attrs ...string) {
+	t.Helper()
+	if op.tag != 0x66 {
+		t.Fatalf("request has the tag %#x, want modify", op.tag)
+	}
+	if string(op.modify.Object) != dn {
+		t.Errorf("modify DN = %q, want %q", op.modify.Object, dn)
+	}
+	if len(op.modify.Changes) != len(attrs) {
+		t.Fatalf("modify of %s has %d changes, want %d", dn, len(op.modify.Changes), len(attrs))
+	}
+	for i, change := range op.modify.Changes {
+		if change.Operation != ldapReplace || string(change.Modification.Type) != attrs[i] {
+			t.Errorf("change %d = %d %s, want replace %s", i, change.Operation, change.Modification.Type, attrs[i])
+		}
+		if len(change.Modification.Values) != 1 || !bytes.Equal(change.Modification.Values[0], value) {
+			t.Errorf("change %d of %s does not carry the DER value", i, dn)
+		}
+	}
+}
+
+func TestLDAPPublisherRefusesURLName(t *testing.T) {
+	root, _, _ := testCA(t, "Test CA", nil, nil)
+	// {ca} is the lower case name of the URL templates, not the name of the entry
+	_, err := NewPublisher(model.PublishTarget{
+		Type:  model.PublishTargetLDAP,
+		URL:   "ldap://127.0.0.1",
+		CrlDN: "cn={ca},dc=example,dc=com",
+	}, "Test CA", root)
+	if err == nil || !strings.Contains(err.Error(), "{ca_name}") {
+		t.Fatalf("NewPublisher error = %v, want {ca} refused", err)
+	}
+}
@@ -0,0 +1,346 @@
+package data
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/model"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpPublisher uploads the files over SFTP. Each file is written to a temporary name and renamed,
+// clients of the server never see a partially uploaded CRL.
+type sftpPublisher struct {
+	addr   string
+	dir    string
+	config *ssh.ClientConfig
+}
+
+func newSFTPPublisher(target model.PublishTarget) (*sftpPublisher, error) {
+	u, err := url.Parse(target.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "sftp" || u.Hostname() == "" {
+		return nil, fmt.Errorf("publish target %s needs a sftp://user@host/path URL", target.DisplayName())
+	}
+	user := u.User.Username()
+	if user == "" {
+		user = target.User
+	}
+	if user == "" {
+		return nil, fmt.Errorf("publish target %s has no user", target.DisplayName())
+	}
+
+	var auth []ssh.AuthMethod
+	if target.KeyFile != "" {
+		raw, err := os.ReadFile(target.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("key of publish target %s: %w", target.DisplayName(), err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if target.PasswordEnv != "" {
+		auth = append(auth, ssh.Password(os.Getenv(target.PasswordEnv)))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("publish target %s has neither a key file nor a password", target.DisplayName())
+	}
+
+	knownHostsFile := target.KnownHosts
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	return &sftpPublisher{
+		addr: net.JoinHostPort(u.Hostname(), port),
+		dir:  u.Path,
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            auth,
+			HostKeyCallback: hostKeys,
+			Timeout:         30 * time.Second,
+		},
+	}, nil
+}
+
+func (p *sftpPublisher) Put(files []model.BundleFile) error {
+	client, err := ssh.Dial("tcp", p.addr, p.config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	w, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return err
+	}
+
+	conn := &sftpConn{w: w, r: r}
+	if err := conn.init(); err != nil {
+		return err
+	}
+	dir := p.dir
+	if dir == "" {
+		dir = "."
+	}
+	for _, f := range files {
+		if err := conn.putFile(dir, f.Name, f.Data); err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// The SFTP version 3 packets and flags used to upload files, draft-ietf-secsh-filexfer-02.
+const (
+	sshFxpInit     = 1
+	sshFxpVersion  = 2
+	sshFxpOpen     = 3
+	sshFxpClose    = 4
+	sshFxpWrite    = 6
+	sshFxpRemove   = 13
+	sshFxpRename   = 18
+	sshFxpStatus   = 101
+	sshFxpHandle   = 102
+	sshFxpExtended = 200
+
+	sshFxfWrite = 0x02
+	sshFxfCreat = 0x08
+	sshFxfTrunc = 0x10
+
+	sshFilexferAttrPermissions = 0x04
+
+	sftpPosixRename = "posix-rename@openssh.com"
+	sftpChunkSize   = 32 * 1024
+	sftpMaxPacket   = 256 * 1024
+)
+
+// sftpConn is a minimal SFTP client which writes whole files, one request at a time.
+type sftpConn struct {
+	w           io.Writer
+	r           io.Reader
+	id          uint32
+	posixRename bool
+}
+
+func (c *sftpConn) init() error {
+	if err := c.send(sshFxpInit, binary.BigEndian.AppendUint32(nil, 3)); err != nil {
+		return err
+	}
+	kind, data, err := c.recv()
+	if err != nil {
+		return err
+	}
+	if kind != sshFxpVersion {
+		return fmt.Errorf("sftp: unexpected packet %d", kind)
+	}
+	if _, data, err = sftpUint32(data); err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		var name, value string
+		if name, data, err = sftpString(data); err != nil {
+			return err
+		}
+		if value, data, err = sftpString(data); err != nil {
+			return err
+		}
+		if name == sftpPosixRename && value == "1" {
+			c.posixRename = true
+		}
+	}
+	return nil
+}
+
+// putFile writes the file to a temporary name in the folder and renames it.
+func (c *sftpConn) putFile(dir string, name string, content []byte) error {
+	dest := path.Join(dir, name)
+	tmp := path.Join(dir, "."+name+tempSuffix)
+
+	handle, err := c.open(tmp)
+	if err != nil {
+		return err
+	}
+	for offset := 0; offset < len(content); offset += sftpChunkSize {
+		end := min(offset+sftpChunkSize, len(content))
+		payload := sftpAppendString(nil, handle)
+		payload = binary.BigEndian.AppendUint64(payload, uint64(offset))
+		payload = sftpAppendString(payload, string(content[offset:end]))
+		if err = c.status(sshFxpWrite, payload); err != nil {
+			break
+		}
+	}
+	if closeErr := c.status(sshFxpClose, sftpAppendString(nil, handle)); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = c.rename(tmp, dest)
+	}
+	if err != nil {
+		c.status(sshFxpRemove, sftpAppendString(nil, tmp))
+	}
+	return err
+}
+
+func (c *sftpConn) open(name string) (string, error) {
+	payload := sftpAppendString(nil, name)
+	payload = binary.BigEndian.AppendUint32(payload, sshFxfWrite|sshFxfCreat|sshFxfTrunc)
+	payload = binary.BigEndian.AppendUint32(payload, sshFilexferAttrPermissions)
+	payload = binary.BigEndian.AppendUint32(payload, 0644)
+
+	kind, data, err := c.request(sshFxpOpen, payload)
+	if err != nil {
+		return "", err
+	}
+	switch kind {
+	case sshFxpHandle:
+		handle, _, err := sftpString(data)
+		return handle, err
+	case sshFxpStatus:
+		return "", sftpStatusError(data)
+	default:
+		return "", fmt.Errorf("sftp: unexpected packet %d", kind)
+	}
+}
+
+// rename replaces dest, SFTP version 3 renames fail if dest exists unless the server offers the posix rename.
+func (c *sftpConn) rename(src string, dest string) error {
+	if c.posixRename {
+		payload := sftpAppendString(nil, sftpPosixRename)
+		payload = sftpAppendString(payload, src)
+		return c.status(sshFxpExtended, sftpAppendString(payload, dest))
+	}
+	c.status(sshFxpRemove, sftpAppendString(nil, dest))
+	return c.status(sshFxpRename, sftpAppendString(sftpAppendString(nil, src), dest))
+}
+
+// status sends a request which is answered with a status.
+func (c *sftpConn) status(kind byte, payload []byte) error {
+	reply, data, err := c.request(kind, payload)
+	if err != nil {
+		return err
+	}
+	if reply != sshFxpStatus {
+		return fmt.Errorf("sftp: unexpected packet %d", reply)
+	}
+	return sftpStatusError(data)
+}
+
+// request sends a request with the next id and returns the reply without the id.
+func (c *sftpConn) request(kind byte, payload []byte) (byte, []byte, error) {
+	c.id++
+	if err := c.send(kind, append(binary.BigEndian.AppendUint32(nil, c.id), payload...)); err != nil {
+		return 0, nil, err
+	}
+	reply, data, err := c.recv()
+	if err != nil {
+		return 0, nil, err
+	}
+	id, data, err := sftpUint32(data)
+	if err != nil {
+		return 0, nil, err
+	}
+	if id != c.id {
+		return 0, nil, fmt.Errorf("sftp: reply to request %d, expected %d", id, c.id)
+	}
+	return reply, data, nil
+}
+
+func (c *sftpConn) send(kind byte, payload []byte) error {
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+1))
+	packet = append(packet, kind)
+	_, err := c.w.Write(append(packet, payload...))
+	return err
+}
+
+func (c *sftpConn) recv() (byte, []byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length == 0 || length > sftpMaxPacket {
+		return 0, nil, fmt.Errorf("sftp: invalid packet length %d", length)
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(c.r, packet); err != nil {
+		return 0, nil, err
+	}
+	return packet[0], packet[1:], nil
+}
+
+// sftpStatusError returns the error of a status reply, nil for SSH_FX_OK.
+func sftpStatusError(data []byte) error {
+	code, data, err := sftpUint32(data)
+	if err != nil {
+		return err
+	}
+	if code == 0 {
+		return nil
+	}
+	msg, _, _ := sftpString(data)
+	return fmt.Errorf("sftp: %s (status %d)", msg, code)
+}
+
+var errSFTPShortPacket = errors.New("sftp: short packet")
+
+func sftpUint32(data []byte) (uint32, []byte, error) {
+	if len(data) < 4 {
+		return 0, nil, errSFTPShortPacket
+	}
+	return binary.BigEndian.Uint32(data), data[4:], nil
+}
+
+func sftpString(data []byte) (string, []byte, error) {
+	length, data, err := sftpUint32(data)
+	if err != nil {
+		return "", nil, err
+	}
+	if uint32(len(data)) < length {
+		return "", nil, errSFTPShortPacket
+	}
+	return string(data[:length]), data[length:], nil
+}
+
+func sftpAppendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
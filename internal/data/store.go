@@ -0,0 +1,150 @@
+package data
+
+import (
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// Store keeps everything a CA owns. The paths returned by a store are references into it,
+// they are only meaningful to the same store. The names of StoreFileName are references too. FileStore is the implementation with the folder layout of tiny pki.
+type Store interface {
+	KeyStore
+	ConfigStore
+	CertificateStore
+	RevocationStore
+	CRLStore
+	RequestStore
+	PublicationStore
+	SSHStore
+	SCEPStore
+	MaintenanceStore
+}
+
+// KeyStore keeps the encrypted private key of the CA.
+type KeyStore interface {
+	ReadKey() ([]byte, error)
+	ReadKeyNonce() ([]byte, error)
+	WriteKey(encryptedKey []byte) error
+	WriteKeyNonce(nonce []byte) error
+}
+
+// ConfigStore keeps the configuration, the certificate and the issuer chain of the CA.
+type ConfigStore interface {
+	IsCaConfigured() bool
+	ReadSetupConfiguration(root bool) (model.Config, error)
+	ReadCaConfiguration() (model.Config, error)
+	WriteCaConfiguration(config model.Config) error
+	ReadCaCertificate() ([]byte, error)
+	WriteRawCaCertificate(certBytes []byte) (string, error)
+	ReadCaChain() ([]byte, error)
+	WriteCaChain(chain []byte) error
+}
+
+// CertificateStore keeps the issued certificates and the registry of issued CA certificates.
+type CertificateStore interface {
+	WriteRawIssuedCertificate(certBytes []byte, filename string) (string, error)
+	ReadIssuedCertificate(filename string) ([]byte, error)
+	GetIssuedCertificatesFromCaStore() ([]model.FileContentWithPath, error)
+	WriteRawIssuedCaCertificate(certBytes []byte, chain []byte, filename string) (string, error)
+	WriteIssuedChain(chain []byte, filename string) (string, error)
+	Issued(src string) error
+	GetIncommingSubCer() ([]model.FileContentWithPath, error)
+	ReadSubCaRegistry() ([]model.SubCa, error)
+	WriteSubCaRegistry(subs []model.SubCa) error
+}
+
+// RevocationStore keeps the revoked certificates and the details of their revocation.
+type RevocationStore interface {
+	GetRevokedCertificatesFromCaStore() ([]model.FileContentWithPath, error)
+	GetNewRevokations() ([]model.FileContentWithPath, error)
+	ImportRevokedCertificate(in model.FileContentWithPath) error
+	WriteRawRevokedCertificate(certBytes []byte, filename string) (string, error)
+	ReadRevocations() ([]model.Revocation, error)
+	WriteRevocations(revocations []model.Revocation) error
+}
+
+// CRLStore keeps the issued CRLs.
+type CRLStore interface {
+	WriteCRL(data []byte, id string) (string, error)
+	GetLatestCRL() ([]byte, error)
+	GetCRLs() ([]model.FileContentWithPath, error)
+}
+
+// RequestStore holds the pending certificate requests.
+type RequestStore interface {
+	GetCertificateRequests() []model.FileContentWithPath
+	GetCaCertificateRequests() ([]model.FileContentWithPath, error)
+	WriteRawRequest(csrBytes []byte, filename string) (string, error)
+	ArchiveRequest(request string)
+	Delete(path string) error
+	ReadRequestRegistry() ([]model.RequestRecord, error)
+	WriteRequestRegistry(requests []model.RequestRecord) error
+	ReadRequestStatus(request string) (model.RequestStatus, error)
+	WriteRequestStatus(request string, status model.RequestStatus) error
+}
+
+// PublicationStore holds what is handed out, the published certificates and CRLs and the transfer bundles.
+// Published files are copied to the publish targets of the configuration.
+type PublicationStore interface {
+	Publish(src string, destName string) error
+	PublishRawCertificate(certBytes []byte, destName string) error
+	ReadPublished(name string) ([]byte, time.Time, error)
+	ReadPublicationStatus() ([]model.PublicationStatus, error)
+	SyncPublished() error
+	GetBundleSources(kind string) ([]model.FileContentWithPath, error)
+	ImportBundleFile(kind string, name string, content []byte) (string, error)
+	WriteBundle(dest string, filename string, files []model.BundleFile) (string, error)
+}
+
+// SCEPStore keeps the RA key and certificate and the challenges of SCEP.
+type SCEPStore interface {
+	IsSCEPConfigured() bool
+	ReadSCEPKey() ([]byte, error)
+	ReadSCEPKeyNonce() ([]byte, error)
+	WriteSCEPKey(encryptedKey []byte) error
+	WriteSCEPKeyNonce(nonce []byte) error
+	ReadSCEPCertificate() ([]byte, error)
+	WriteSCEPCertificate(cert []byte) error
+	ReadSCEPChallenges() ([]model.SCEPChallenge, error)
+	WriteSCEPChallenges(challenges []model.SCEPChallenge) error
+}
+
+// SSHStore keeps the key, the profiles and the signed certificates of the SSH CA.
+type SSHStore interface {
+	IsSSHConfigured() bool
+	ReadSSHKey() ([]byte, error)
+	ReadSSHKeyNonce() ([]byte, error)
+	WriteSSHKey(encryptedKey []byte) error
+	WriteSSHKeyNonce(nonce []byte) error
+	ReadSSHConfiguration() (model.SSHConfig, error)
+	WriteSSHConfiguration(conf model.SSHConfig) error
+	ReadSSHRevocations() ([]model.SSHRevocation, error)
+	WriteSSHRevocations(revocations []model.SSHRevocation) error
+	GetSSHRequests() []model.FileContentWithPath
+	WriteSSHCertificate(cert []byte, filename string) (string, error)
+	GetSSHCertificatesFromCaStore() ([]model.FileContentWithPath, error)
+	WriteSSHPublicKey(key []byte) (string, error)
+	WriteKRL(krl []byte) (string, error)
+}
+
+// MaintenanceStore covers locking, the folders, backups and the journal of interrupted operations.
+type MaintenanceStore interface {
+	LockStore(exclusive bool) (*StoreLock, error)
+	SetupFolders()
+	GetPathByName(name string) string
+	GetFolderPermissions() []model.FolderPermission
+	RepairFolder(name string) error
+	GetStoreFiles() ([]model.BundleFile, error)
+	IsStoreEmpty() (bool, error)
+	RestoreStoreFiles(files []model.BundleFile) error
+	WriteBackup(dest string, filename string, raw []byte) (string, error)
+	StoreFileName(folderName string, filename string) string
+	StoreFileExists(name string) bool
+	RemoveTempFiles() (int, error)
+	ReadJournal() (*model.JournalEntry, error)
+	WriteJournal(entry model.JournalEntry) error
+	ClearJournal() error
+}
+
+var _ Store = (*FileStore)(nil)
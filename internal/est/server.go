@@ -0,0 +1,381 @@
+// Package est serves the enrollment over secure transport protocol (RFC 7030) for a CA.
+package est
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"deleteonerror.com/tyinypki/internal/ca"
+	"deleteonerror.com/tyinypki/internal/data"
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+	"deleteonerror.com/tyinypki/internal/pkcs7"
+)
+
+// PathPrefix is the well-known path of the EST operations.
+const PathPrefix = "/.well-known/est/"
+
+// maxRequestSize limits the size of a posted certificate request.
+const maxRequestSize = 64 * 1024
+
+var (
+	oidECDSAWithSHA384   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidExtensionRequest  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 14}
+	oidSubjectAltName    = asn1.ObjectIdentifier{2, 5, 29, 17}
+	profilesWithAltNames = []string{"server", "webserver"}
+)
+
+// Server handles the EST operations cacerts, simpleenroll, simplereenroll and csrattrs.
+// An optional label in the path, e.g. /.well-known/est/webserver/simpleenroll, selects the profile.
+// Certificates are issued by CA.IssueRequest, the same way as requests placed in the request folders. A request
+// waiting for approval is answered with 202, the client posts it again until operators decided.
+type Server struct {
+	CA *ca.Authority
+	// Users may enroll the profiles of their entry with HTTP basic auth.
+	Users Users
+	// Enrollers may enroll the profiles of their entry with a valid client certificate of the CA. Other client
+	// certificates only re-enroll themselves, the subject of a certificate grants no right to enroll others.
+	Enrollers Enrollers
+	// DefaultProfile is used if the path has no label.
+	DefaultProfile string
+
+	// mu serializes the use of the CA, the store lock is taken per request
+	mu sync.Mutex
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, PathPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+
+	segments := strings.Split(strings.TrimPrefix(r.URL.Path, PathPrefix), "/")
+	// without label the enrollment takes the default profile, the re-enrollment the profile of the client certificate
+	profile := ""
+	switch len(segments) {
+	case 1:
+	case 2:
+		profile = segments[0]
+		if !slices.Contains(ca.Profiles, profile) {
+			http.NotFound(w, r)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	operation := segments[len(segments)-1]
+	switch operation {
+	case "cacerts", "csrattrs":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	case "simpleenroll", "simplereenroll":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	switch operation {
+	case "cacerts":
+		s.caCerts(w)
+	case "csrattrs":
+		s.csrAttrs(w, cmp.Or(profile, s.DefaultProfile))
+	case "simpleenroll":
+		s.enroll(w, r, profile, false)
+	case "simplereenroll":
+		s.enroll(w, r, profile, true)
+	}
+}
+
+// caCerts returns the CA certificate and its issuers, it needs no authentication.
+func (s *Server) caCerts(w http.ResponseWriter) {
+	release, ok := s.lock(w, false)
+	if !ok {
+		return
+	}
+	chain := s.CA.CertificateChain()
+	release()
+
+	if len(chain) == 0 {
+		http.Error(w, "no ca certificate", http.StatusServiceUnavailable)
+		return
+	}
+
+	var certs [][]byte
+	for _, c := range chain {
+		certs = append(certs, c.Raw)
+	}
+	s.writeCertificates(w, certs)
+}
+
+// csrAttrs names the signature algorithm of the CA and, for server profiles, asks for the subject alternative names.
+func (s *Server) csrAttrs(w http.ResponseWriter, profile string) {
+	attrs := []any{oidECDSAWithSHA384}
+	if slices.Contains(profilesWithAltNames, profile) {
+		attrs = append(attrs, struct {
+			Type   asn1.ObjectIdentifier
+			Values []asn1.ObjectIdentifier `asn1:"set"`
+		}{oidExtensionRequest, []asn1.ObjectIdentifier{oidSubjectAltName}})
+	}
+
+	der, err := asn1.Marshal(attrs)
+	if err != nil {
+		logger.Error("%v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/csrattrs")
+	writeBase64(w, der)
+}
+
+// enroll issues a certificate for the posted request. The enrollment needs a basic auth user or a client
+// certificate of this CA listed in the enrollers, both allowed to enroll the profile. The re-enrollment needs
+// a valid client certificate of this CA with the profile and the subject and alternative names of the request.
+func (s *Server) enroll(w http.ResponseWriter, r *http.Request, profile string, renew bool) {
+	csr, err := readRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	release, ok := s.lock(w, true)
+	if !ok {
+		return
+	}
+	defer release()
+
+	peer := s.verifiedPeer(r)
+	if !renew && profile == "" {
+		profile = s.DefaultProfile
+	}
+	switch {
+	case renew:
+		if peer == nil {
+			http.Error(w, "re-enrollment needs a valid client certificate", http.StatusUnauthorized)
+			return
+		}
+		if !sameIdentity(csr, peer) {
+			http.Error(w, "the request does not match the client certificate", http.StatusBadRequest)
+			return
+		}
+		peerProfile := ca.CertificateProfile(peer)
+		if peerProfile == "" || (profile != "" && profile != peerProfile) {
+			logger.Warning("EST re-enrollment of %s with profile %s refused, the client certificate has the key usages of %q", peer.Subject.CommonName, profile, peerProfile)
+			http.Error(w, "the profile does not match the client certificate", http.StatusForbidden)
+			return
+		}
+		profile = peerProfile
+	case peer != nil && len(s.Enrollers.profiles(peer)) > 0:
+		if !slices.Contains(s.Enrollers.profiles(peer), profile) {
+			logger.Warning("EST enrollment of %s with profile %s refused for %s", csr.Subject.CommonName, profile, peer.Subject.CommonName)
+			http.Error(w, "profile not allowed", http.StatusForbidden)
+			return
+		}
+	default:
+		name, ok := s.checkBasicAuth(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="est"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !s.Users.allowed(name, profile, s.DefaultProfile) {
+			logger.Warning("EST enrollment of %s with profile %s refused for %s", csr.Subject.CommonName, profile, name)
+			http.Error(w, "profile not allowed", http.StatusForbidden)
+			return
+		}
+		peer = nil
+	}
+
+	// a request waiting for approval is posted again until it is decided, RFC 7030 section 4.2.3
+	requester := clientName(r, peer)
+	sum := sha256.Sum256(csr.Raw)
+	transaction := hex.EncodeToString(sum[:])
+	queued, found, err := s.CA.TransactionRecord(requester, transaction)
+	if err != nil {
+		logger.Error("%v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if found {
+		s.writeQueued(w, queued)
+		return
+	}
+
+	record := model.RequestRecord{Requester: requester, Transaction: transaction}
+	cert, err := s.CA.IssueRequest(csr, profile, record, false)
+	var pending *ca.PendingError
+	if errors.As(err, &pending) {
+		s.writeQueued(w, pending.Record)
+		return
+	}
+	if err != nil {
+		logger.Error("EST enrollment of %s failed: %v", csr.Subject.CommonName, err)
+		http.Error(w, "enrollment failed", http.StatusInternalServerError)
+		return
+	}
+	logger.Info("EST issued %s (serial %s) with profile %s to %s", cert.Subject.CommonName, cert.SerialNumber, profile, requester)
+
+	s.writeCertificates(w, [][]byte{cert.Raw})
+}
+
+// writeQueued answers a request waiting for approval with 202 and Retry-After, a decided one with
+// the certificate or 403 with the reason of the rejection.
+func (s *Server) writeQueued(w http.ResponseWriter, queued model.RequestRecord) {
+	switch queued.Status {
+	case model.RequestPending:
+		w.Header().Set("Retry-After", "300")
+		http.Error(w, "the request "+queued.ID+" waits for approval", http.StatusAccepted)
+	case model.RequestIssued:
+		cert, err := s.CA.IssuedCertificate(queued.Serial)
+		if err != nil {
+			logger.Error("%v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		s.writeCertificates(w, [][]byte{cert.Raw})
+	default:
+		http.Error(w, "the request "+queued.ID+" was rejected: "+queued.Reason, http.StatusForbidden)
+	}
+}
+
+// lock takes the store lock and refreshes the CA, release frees both.
+func (s *Server) lock(w http.ResponseWriter, exclusive bool) (func(), bool) {
+	s.mu.Lock()
+	lock, err := s.CA.Store().LockStore(exclusive)
+	if err != nil {
+		s.mu.Unlock()
+		if errors.Is(err, data.ErrLocked) {
+			w.Header().Set("Retry-After", "10")
+			http.Error(w, "the ca is busy", http.StatusServiceUnavailable)
+			return nil, false
+		}
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return nil, false
+	}
+	s.CA.Refresh()
+
+	return func() {
+		lock.Release()
+		s.mu.Unlock()
+	}, true
+}
+
+// verifiedPeer returns the TLS client certificate if it is a valid certificate of this CA.
+func (s *Server) verifiedPeer(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	peer := r.TLS.PeerCertificates[0]
+	if err := s.CA.VerifyIssued(peer); err != nil {
+		logger.Warning("EST client certificate %s rejected: %v", peer.Subject.CommonName, err)
+		return nil
+	}
+	return peer
+}
+
+// checkBasicAuth returns the basic auth user if the password matches.
+func (s *Server) checkBasicAuth(r *http.Request) (string, bool) {
+	name, password, ok := r.BasicAuth()
+	if !ok || s.Users == nil {
+		return "", false
+	}
+	if !s.Users.Check(name, password) {
+		logger.Warning("EST basic auth of %s failed", name)
+		return "", false
+	}
+	return name, true
+}
+
+func (s *Server) writeCertificates(w http.ResponseWriter, certs [][]byte) {
+	p7, err := pkcs7.DegenerateCertificates(certs...)
+	if err != nil {
+		logger.Error("%v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkcs7-mime; smime-type=certs-only")
+	writeBase64(w, p7)
+}
+
+// readRequest decodes the base64 encoded request, a DER encoded request is accepted as well.
+func readRequest(r *http.Request) (*x509.CertificateRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxRequestSize {
+		return nil, errors.New("request too large")
+	}
+
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		der = body
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, errors.New("no pkcs#10 certificate request found")
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, errors.New("invalid request signature")
+	}
+	return csr, nil
+}
+
+// sameIdentity reports if the request has the subject and the alternative names of the certificate.
+func sameIdentity(csr *x509.CertificateRequest, cert *x509.Certificate) bool {
+	// compared as names, the CA may encode the strings of the subject other than the client
+	if csr.Subject.String() != cert.Subject.String() {
+		return false
+	}
+	if !slices.Equal(csr.DNSNames, cert.DNSNames) || !slices.Equal(csr.EmailAddresses, cert.EmailAddresses) {
+		return false
+	}
+	if !slices.EqualFunc(csr.IPAddresses, cert.IPAddresses, func(a, b net.IP) bool { return a.Equal(b) }) {
+		return false
+	}
+	return slices.EqualFunc(csr.URIs, cert.URIs, func(a, b *url.URL) bool { return a.String() == b.String() })
+}
+
+func clientName(r *http.Request, peer *x509.Certificate) string {
+	if peer != nil {
+		return peer.Subject.CommonName
+	}
+	name, _, _ := r.BasicAuth()
+	return name
+}
+
+// writeBase64 writes the body in base64 with line breaks as RFC 7030 asks for.
+func writeBase64(w http.ResponseWriter, der []byte) {
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+
+	encoded := base64.StdEncoding.EncodeToString(der)
+	var buf bytes.Buffer
+	for len(encoded) > 64 {
+		buf.WriteString(encoded[:64] + "\r\n")
+		encoded = encoded[64:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,153 @@
+package est
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"deleteonerror.com/tyinypki/internal/ca"
+	"golang.org/x/crypto/argon2"
+)
+
+// Users holds the argon2id password hashes of the clients allowed to enroll with basic auth.
+type Users map[string]userHash
+
+type userHash struct {
+	salt []byte
+	hash []byte
+	// profiles the user may enroll, the default profile of the server if empty
+	profiles []string
+}
+
+// LoadUsers reads a users file, each line is name:salt:hash:profiles with base64 encoded salt and hash
+// and the comma separated profiles the user may enroll. Without profiles the user may enroll the default
+// profile of the server only. Empty lines and lines starting with # are skipped.
+func LoadUsers(path string) (Users, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	users := Users{}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 && len(fields) != 4 {
+			return nil, fmt.Errorf("%s:%d: expected name:salt:hash:profiles", path, n)
+		}
+		salt, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid salt: %w", path, n, err)
+		}
+		hash, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid hash: %w", path, n, err)
+		}
+		user := userHash{salt: salt, hash: hash}
+		if len(fields) == 4 {
+			if user.profiles, err = ca.ParseProfiles(fields[3]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+			}
+		}
+		users[fields[0]] = user
+	}
+	return users, scanner.Err()
+}
+
+// Enrollers holds the profiles the owners of client certificates may enroll by the hex encoded
+// SHA-256 fingerprint of the certificate.
+type Enrollers map[string][]string
+
+// LoadEnrollers reads an enrollers file, each line is fingerprint:profiles with the hex encoded SHA-256
+// fingerprint of a client certificate of the CA and the comma separated profiles its owner may enroll.
+// Colons between the bytes of the fingerprint are allowed. Empty lines and lines starting with # are skipped.
+func LoadEnrollers(path string) (Enrollers, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	enrollers := Enrollers{}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: expected fingerprint:profiles", path, n)
+		}
+		fingerprint := strings.ToLower(strings.ReplaceAll(line[:i], ":", ""))
+		if raw, err := hex.DecodeString(fingerprint); err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("%s:%d: invalid SHA-256 fingerprint", path, n)
+		}
+		profiles, err := ca.ParseProfiles(line[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		enrollers[fingerprint] = profiles
+	}
+	return enrollers, scanner.Err()
+}
+
+// profiles returns the profiles the owner of the certificate may enroll.
+func (e Enrollers) profiles(cert *x509.Certificate) []string {
+	sum := sha256.Sum256(cert.Raw)
+	return e[hex.EncodeToString(sum[:])]
+}
+
+// Check reports if the password matches the hash of the user.
+func (u Users) Check(name string, password string) bool {
+	h, ok := u[name]
+	if !ok {
+		// hash anyway, unknown users take as long as wrong passwords
+		h = userHash{salt: make([]byte, 16), hash: make([]byte, 32)}
+	}
+	hash := hashPassword([]byte(password), h.salt)
+	return subtle.ConstantTimeCompare(hash, h.hash) == 1 && ok
+}
+
+// allowed reports if the user may enroll the profile.
+func (u Users) allowed(name string, profile string, defaultProfile string) bool {
+	h, ok := u[name]
+	if !ok {
+		return false
+	}
+	if len(h.profiles) == 0 {
+		return profile == defaultProfile
+	}
+	return slices.Contains(h.profiles, profile)
+}
+
+// UserLine returns the line of the users file for the name, the profiles and the password with a new salt.
+func UserLine(name string, profiles []string, password []byte) (string, error) {
+	if name == "" || strings.ContainsAny(name, ":\n") {
+		return "", fmt.Errorf("invalid user name %q", name)
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%s:%s:%s", name,
+		base64.StdEncoding.EncodeToString(salt),
+		base64.StdEncoding.EncodeToString(hashPassword(password, salt)),
+		strings.Join(profiles, ",")), nil
+}
+
+func hashPassword(password []byte, salt []byte) []byte {
+	return argon2.IDKey(password, salt, 1, 64*1024, 4, 32)
+}
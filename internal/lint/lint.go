@@ -0,0 +1,196 @@
+// Package lint checks certificate and CRL templates against RFC 5280 and the CA/Browser Forum
+// baseline requirements before they are signed.
+package lint
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// maxTLSValidity is the longest validity of TLS server certificates allowed by the baseline requirements.
+const maxTLSValidity = 398 * 24 * time.Hour
+
+// maxSerialLength is the longest serial or CRL number in octets, RFC 5280 4.1.2.2 and 5.2.3.
+const maxSerialLength = 20
+
+var oidNetscape = asn1.ObjectIdentifier{2, 16, 840, 1, 113730, 1}
+
+type findings []model.LintFinding
+
+func (f *findings) add(severity string, lint string, format string, args ...any) {
+	*f = append(*f, model.LintFinding{Lint: lint, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// Certificate checks a certificate template. The issuer is the certificate of the signing CA,
+// for a self signed certificate it is the template itself. The public key is the key to certify.
+func Certificate(template *x509.Certificate, issuer *x509.Certificate, pub crypto.PublicKey) []model.LintFinding {
+	var f findings
+	selfSigned := issuer == template
+
+	checkSerial(&f, "e_serial_number", template.SerialNumber)
+	checkKey(&f, pub)
+
+	if !template.NotAfter.After(template.NotBefore) {
+		f.add(model.LintError, "e_validity", "the certificate expires before it is valid")
+	}
+	if !selfSigned && template.NotAfter.After(issuer.NotAfter) {
+		f.add(model.LintWarning, "w_validity_beyond_issuer", "the certificate is valid until %s, after the issuer expires at %s",
+			template.NotAfter.UTC().Format(time.RFC3339), issuer.NotAfter.UTC().Format(time.RFC3339))
+	}
+
+	if template.IsCA {
+		if !template.BasicConstraintsValid {
+			f.add(model.LintError, "e_ca_basic_constraints", "a CA certificate needs the basic constraints extension")
+		}
+		if template.KeyUsage&x509.KeyUsageCertSign == 0 {
+			f.add(model.LintError, "e_ca_key_usage", "a CA certificate needs the keyCertSign key usage")
+		}
+	} else {
+		if template.KeyUsage&(x509.KeyUsageCertSign|x509.KeyUsageCRLSign) != 0 {
+			f.add(model.LintError, "e_ee_key_usage", "an end entity certificate must not have the keyCertSign or cRLSign key usage")
+		}
+		if !template.BasicConstraintsValid {
+			f.add(model.LintNotice, "n_ee_basic_constraints", "the end entity certificate has no basic constraints extension with cA false")
+		}
+		if len(template.SubjectKeyId) == 0 {
+			f.add(model.LintWarning, "w_ski_missing", "the certificate has no subject key identifier")
+		}
+	}
+
+	sans := len(template.DNSNames) + len(template.IPAddresses) + len(template.EmailAddresses) + len(template.URIs)
+	if len(template.Subject.ToRDNSequence()) == 0 && sans == 0 {
+		f.add(model.LintError, "e_empty_subject_without_san", "the certificate has neither a subject nor alternative names")
+	}
+	if !template.IsCA && slices.Contains(template.ExtKeyUsage, x509.ExtKeyUsageServerAuth) {
+		checkTLSServer(&f, template)
+	}
+
+	if len(template.SubjectKeyId) > 0 && len(template.SubjectKeyId) != 20 {
+		f.add(model.LintNotice, "n_ski_length", "the subject key identifier has %d bytes, RFC 7093 uses the leftmost 160 bits of the hash", len(template.SubjectKeyId))
+	}
+	if selfSigned {
+		if len(template.AuthorityKeyId) > 0 && !bytes.Equal(template.AuthorityKeyId, template.SubjectKeyId) {
+			f.add(model.LintError, "e_self_signed_aki", "the authority key identifier of a self signed certificate differs from its subject key identifier")
+		}
+	} else {
+		if len(issuer.SubjectKeyId) == 0 && len(template.AuthorityKeyId) == 0 {
+			f.add(model.LintError, "e_aki_missing", "the issuer has no subject key identifier for the authority key identifier")
+		}
+		if len(template.CRLDistributionPoints) == 0 {
+			f.add(model.LintWarning, "w_cdp_missing", "the certificate has no CRL distribution point")
+		}
+		if len(template.IssuingCertificateURL) == 0 {
+			f.add(model.LintNotice, "n_aia_missing", "the certificate has no authority information access for the issuer")
+		}
+	}
+
+	for _, ext := range slices.Concat(template.Extensions, template.ExtraExtensions) {
+		if len(ext.Id) > len(oidNetscape) && ext.Id[:len(oidNetscape)].Equal(oidNetscape) {
+			f.add(model.LintWarning, "w_netscape_extension", "the obsolete Netscape extension %s is not part of RFC 5280", ext.Id)
+		}
+	}
+	return f
+}
+
+// checkTLSServer checks the names and the validity of a TLS server certificate.
+func checkTLSServer(f *findings, template *x509.Certificate) {
+	if len(template.DNSNames)+len(template.IPAddresses) == 0 {
+		f.add(model.LintError, "e_tls_san", "a TLS server certificate needs a DNS name or IP address as alternative name")
+	}
+	if cn := template.Subject.CommonName; cn != "" {
+		inSAN := slices.ContainsFunc(template.DNSNames, func(name string) bool { return strings.EqualFold(name, cn) }) ||
+			slices.ContainsFunc(template.IPAddresses, func(ip net.IP) bool { return ip.String() == cn })
+		if !inSAN {
+			f.add(model.LintError, "e_cn_not_in_san", "the common name %s of a TLS server certificate is not one of its alternative names", cn)
+		}
+	}
+	if template.NotAfter.Sub(template.NotBefore) > maxTLSValidity {
+		f.add(model.LintWarning, "w_tls_validity", "a TLS server certificate should not be valid for more than 398 days")
+	}
+}
+
+// CRL checks a CRL template, the issuer is the certificate of the signing CA.
+func CRL(template *x509.RevocationList, issuer *x509.Certificate) []model.LintFinding {
+	var f findings
+
+	checkSerial(&f, "e_crl_number", template.Number)
+	if template.NextUpdate.IsZero() || !template.NextUpdate.After(template.ThisUpdate) {
+		f.add(model.LintError, "e_crl_next_update", "the next update must follow this update")
+	} else if template.NextUpdate.After(issuer.NotAfter) {
+		f.add(model.LintWarning, "w_crl_next_update_beyond_issuer", "the next update %s is after the issuer expires at %s",
+			template.NextUpdate.UTC().Format(time.RFC3339), issuer.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if issuer.KeyUsage != 0 && issuer.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		f.add(model.LintError, "e_crl_issuer_key_usage", "the issuer has no cRLSign key usage")
+	}
+	if len(issuer.SubjectKeyId) == 0 {
+		f.add(model.LintError, "e_crl_aki", "the issuer has no subject key identifier for the authority key identifier")
+	}
+
+	for _, entry := range template.RevokedCertificateEntries {
+		checkSerial(&f, "e_crl_entry_serial", entry.SerialNumber)
+		if entry.RevocationTime.IsZero() {
+			f.add(model.LintWarning, "w_crl_entry_time", "serial %s has no revocation date", entry.SerialNumber)
+		}
+	}
+	return f
+}
+
+func checkSerial(f *findings, lint string, serial *big.Int) {
+	if serial == nil || serial.Sign() <= 0 {
+		f.add(model.LintError, lint, "the number must be positive")
+		return
+	}
+	// the DER encoding adds a leading zero byte if the high bit is set
+	if length := serial.BitLen()/8 + 1; length > maxSerialLength {
+		f.add(model.LintError, lint, "the number has %d octets, at most %d are allowed", length, maxSerialLength)
+	}
+}
+
+func checkKey(f *findings, pub crypto.PublicKey) {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			f.add(model.LintError, "e_weak_key", "the RSA key has %d bits, at least 2048 are required", key.N.BitLen())
+		}
+	case *ecdsa.PublicKey:
+		if key.Curve.Params().BitSize < 256 {
+			f.add(model.LintError, "e_weak_key", "the ECDSA curve %s is too weak", key.Curve.Params().Name)
+		}
+	case ed25519.PublicKey:
+	default:
+		f.add(model.LintWarning, "w_key_type", "unknown public key type %T", pub)
+	}
+}
+
+// Blocking returns the findings with the severity level or above, an empty level blocks errors.
+// The level none blocks nothing.
+func Blocking(all []model.LintFinding, level string) []model.LintFinding {
+	if level == "" {
+		level = model.LintError
+	}
+	min := slices.Index(model.LintSeverities, level)
+	if min < 0 {
+		return nil
+	}
+	var blocking []model.LintFinding
+	for _, finding := range all {
+		if slices.Index(model.LintSeverities, finding.Severity) >= min {
+			blocking = append(blocking, finding)
+		}
+	}
+	return blocking
+}
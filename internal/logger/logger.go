@@ -3,8 +3,10 @@ package logger
 import (
 	"fmt"
 	"log"
+	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 const (
@@ -16,6 +18,45 @@ const (
 
 var LogSeverity = INFO
 
+// Logger receives the messages of a CA, the format follows fmt.Sprintf.
+type Logger interface {
+	Debug(format string, a ...interface{})
+	Info(format string, a ...interface{})
+	Warning(format string, a ...interface{})
+	Error(format string, a ...interface{})
+}
+
+// Default writes to the standard log and honours LogSeverity.
+var Default Logger = standard{}
+
+type standard struct{}
+
+func (standard) Debug(format string, a ...interface{})   { Debug(format, a...) }
+func (standard) Info(format string, a ...interface{})    { Info(format, a...) }
+func (standard) Warning(format string, a ...interface{}) { Warning(format, a...) }
+func (standard) Error(format string, a ...interface{})   { logCaller(2, format, a...) }
+
+// FromEnvironment sets LogSeverity from the environment variable `TINY_LOG`.
+func FromEnvironment() {
+	severity, exists := os.LookupEnv("TINY_LOG")
+	if !exists {
+		LogSeverity = INFO
+	} else {
+		switch strings.ToLower(severity) {
+		case "debug", "dev":
+			LogSeverity = DEBUG
+		case "warning":
+			LogSeverity = WARNING
+		case "error":
+			LogSeverity = ERROR
+		default:
+			Warning("Environment variable `TINY_LOG` ignored, Loglevel is default `Info`")
+			LogSeverity = INFO
+		}
+	}
+	Info("loglevel is %d", LogSeverity)
+}
+
 func Debug(format string, a ...interface{}) {
 	if LogSeverity <= DEBUG {
 		logMessage("DEBUG", format, a...)
@@ -35,8 +76,13 @@ func Warning(format string, a ...interface{}) {
 }
 
 func Error(format string, a ...interface{}) {
+	logCaller(2, format, a...)
+}
+
+// logCaller logs an error with the file and line of the caller, skip counts the frames above logCaller.
+func logCaller(skip int, format string, a ...interface{}) {
 	if LogSeverity <= ERROR {
-		_, file, line, ok := runtime.Caller(1)
+		_, file, line, ok := runtime.Caller(skip)
 
 		if ok {
 			filename := filepath.Base(file)
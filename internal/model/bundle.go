@@ -0,0 +1,28 @@
+package model
+
+import "time"
+
+// BundleFile is a file carried in a transfer bundle.
+type BundleFile struct {
+	// The name inside the bundle, e.g. "csr/tiny pki Sub CA.csr".
+	Name string
+	Data []byte
+}
+
+// BundleManifest describes the content of a transfer bundle, it is signed by the producing CA.
+type BundleManifest struct {
+	Version  int       `json:"version"`
+	Producer string    `json:"producer"`
+	Created  time.Time `json:"created"`
+	// The hex encoded SHA-256 fingerprint of the producer's public key.
+	Fingerprint string               `json:"fingerprint"`
+	Files       []BundleManifestFile `json:"files"`
+}
+
+type BundleManifestFile struct {
+	Name string `json:"name"`
+	// The kind of the file, it decides where the file is placed on import: csr, ca-cert or publish.
+	Kind   string `json:"kind"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
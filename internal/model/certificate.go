@@ -0,0 +1,25 @@
+package model
+
+import (
+	"math/big"
+	"time"
+)
+
+// Status of an issued certificate.
+const (
+	CertificateValid   = "valid"
+	CertificateRevoked = "revoked"
+	CertificateExpired = "expired"
+)
+
+// IssuedCertificate describes a certificate in the issued folder of the store.
+type IssuedCertificate struct {
+	Serial    *big.Int  `json:"serial"`
+	Subject   string    `json:"subject"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	Status    string    `json:"status"`
+	// The file name of the certificate in the store.
+	File string `json:"file"`
+}
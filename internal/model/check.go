@@ -0,0 +1,25 @@
+package model
+
+const (
+	CheckOk      = "ok"
+	CheckWarning = "warning"
+	CheckError   = "error"
+)
+
+// CheckFinding is the result of a single store consistency check.
+type CheckFinding struct {
+	Check   string `json:"check"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	// Repaired is set if the problem was fixed by the repair mode.
+	Repaired bool `json:"repaired,omitempty"`
+}
+
+// FolderPermission compares the permissions of a folder with the permissions it was created with.
+type FolderPermission struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Expected uint32 `json:"expected"`
+	Actual   uint32 `json:"actual"`
+	Exists   bool   `json:"exists"`
+}
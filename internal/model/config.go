@@ -6,13 +6,32 @@ import (
 )
 
 type Config struct {
-	Name               string   `json:"common_name"`
-	Country            string   `json:"country_iso"`
-	Organization       string   `json:"organization"`
-	OrganizationalUnit string   `json:"organizational_unit"`
-	BaseUrl            string   `json:"base_url"`
-	LastIssuedSerial   *big.Int `json:"last_issued_serial"`
-	LastCRLNumber      *big.Int `json:"last_crl_number"`
+	Name               string `json:"common_name"`
+	Country            string `json:"country_iso"`
+	Organization       string `json:"organization"`
+	OrganizationalUnit string `json:"organizational_unit"`
+	BaseUrl            string `json:"base_url"`
+	// CdpUrls, AiaUrls and OcspUrls are the templates of the URLs in issued certificates, see the docs for
+	// the placeholders. The CRL and the CA certificate are published under the file names of the http URLs.
+	CdpUrls  []string `json:"cdp_urls,omitempty"`
+	AiaUrls  []string `json:"aia_urls,omitempty"`
+	OcspUrls []string `json:"ocsp_urls,omitempty"`
+	// LdapCdp is an ldap:// URL added to the CRL distribution points of issued certificates after the others.
+	LdapCdp string `json:"ldap_cdp,omitempty"`
+	// PathLen limits the number of CA levels below this CA, nil leaves it to the issuer.
+	PathLen *int `json:"path_len,omitempty"`
+	// RequireApproval keeps new requests pending until an operator approves them.
+	RequireApproval bool `json:"require_approval,omitempty"`
+	// SensitiveProfiles need the approval of two distinct operators.
+	SensitiveProfiles []string `json:"sensitive_profiles,omitempty"`
+	// LintBlocking is the lowest severity of lint findings which stops signing, error if empty, none never stops.
+	LintBlocking string `json:"lint_blocking,omitempty"`
+	// CRLRefreshDays is the number of days before the next update of the last CRL at which a new CRL is published, 30 if zero.
+	CRLRefreshDays int `json:"crl_refresh_days,omitempty"`
+	// PublishTargets receive a copy of every published certificate and CRL.
+	PublishTargets   []PublishTarget `json:"publish_targets,omitempty"`
+	LastIssuedSerial *big.Int        `json:"last_issued_serial"`
+	LastCRLNumber    *big.Int        `json:"last_crl_number"`
 }
 
 type configAlias Config
@@ -25,10 +44,20 @@ func (src *Config) UnmarshalJSON(bytes []byte) error {
 	}
 
 	src.BaseUrl = tmp.BaseUrl
+	src.CdpUrls = tmp.CdpUrls
+	src.AiaUrls = tmp.AiaUrls
+	src.OcspUrls = tmp.OcspUrls
+	src.LdapCdp = tmp.LdapCdp
 	src.Country = tmp.Country
 	src.Name = tmp.Name
 	src.Organization = tmp.Organization
 	src.OrganizationalUnit = tmp.OrganizationalUnit
+	src.PathLen = tmp.PathLen
+	src.RequireApproval = tmp.RequireApproval
+	src.SensitiveProfiles = tmp.SensitiveProfiles
+	src.LintBlocking = tmp.LintBlocking
+	src.CRLRefreshDays = tmp.CRLRefreshDays
+	src.PublishTargets = tmp.PublishTargets
 
 	if tmp.LastCRLNumber == nil {
 		src.LastCRLNumber = big.NewInt(0)
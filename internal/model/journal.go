@@ -0,0 +1,29 @@
+package model
+
+import (
+	"math/big"
+	"time"
+)
+
+// Journal operations.
+const (
+	JournalIssue   = "issue"
+	JournalIssueCa = "issue-ca"
+	JournalCross   = "cross"
+	JournalCrl     = "crl"
+)
+
+// JournalEntry describes an operation in progress. It is written before the serial or CRL number
+// is reserved and removed when the operation is finished.
+type JournalEntry struct {
+	Operation string `json:"operation"`
+	// The reserved serial or CRL number, recovery checks the store file holds it.
+	Number *big.Int `json:"number"`
+	// The store file the operation is committed with, relative to the store.
+	File string `json:"file"`
+	// The request file which is archived when the operation is finished.
+	Request string `json:"request,omitempty"`
+	// The name the certificate is published with, CA certificates only.
+	Publish string    `json:"publish,omitempty"`
+	Started time.Time `json:"started"`
+}
@@ -0,0 +1,19 @@
+package model
+
+// Severities of lint findings, from the lowest to the highest.
+const (
+	LintNotice  = "notice"
+	LintWarning = "warning"
+	LintError   = "error"
+)
+
+// LintSeverities are the severities of lint findings in ascending order.
+var LintSeverities = []string{LintNotice, LintWarning, LintError}
+
+// LintFinding is a deviation of a certificate or CRL from RFC 5280 or the CA/Browser Forum
+// baseline requirements, found before it is signed.
+type LintFinding struct {
+	Lint     string `json:"lint"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
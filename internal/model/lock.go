@@ -0,0 +1,19 @@
+package model
+
+import "time"
+
+// LockHolder describes a process holding the store lock.
+type LockHolder struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	Started   time.Time `json:"started"`
+	Exclusive bool      `json:"exclusive"`
+	Command   string    `json:"command"`
+}
+
+func (h LockHolder) Mode() string {
+	if h.Exclusive {
+		return "exclusive"
+	}
+	return "shared"
+}
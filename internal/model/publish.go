@@ -0,0 +1,65 @@
+package model
+
+import "time"
+
+// Publish target types.
+const (
+	PublishTargetDir    = "dir"
+	PublishTargetWebDAV = "webdav"
+	PublishTargetSFTP   = "sftp"
+	PublishTargetLDAP   = "ldap"
+)
+
+// PublishTarget is a location the published certificates and CRLs are copied to after every publication.
+type PublishTarget struct {
+	// Name identifies the target in the logs and the publication status, the path or URL if empty.
+	Name string `json:"name,omitempty"`
+	// Type is dir, webdav, sftp or ldap.
+	Type string `json:"type"`
+	// Path is the mirror folder of a dir target.
+	Path string `json:"path,omitempty"`
+	// URL is the collection of a webdav target, http://host/pki/, sftp://user@host:22/path of a sftp target
+	// or ldap://host:389 or ldaps://host:636 of a ldap target.
+	URL string `json:"url,omitempty"`
+	// User is the user of the basic authentication of webdav, of sftp if the URL has none, or the bind DN of ldap.
+	User string `json:"user,omitempty"`
+	// PasswordEnv names the environment variable holding the password, passwords are not kept in the configuration.
+	PasswordEnv string `json:"password_env,omitempty"`
+	// KeyFile is the unencrypted private key of the sftp user.
+	KeyFile string `json:"key_file,omitempty"`
+	// KnownHosts is the known_hosts file with the key of the sftp server, ~/.ssh/known_hosts if empty.
+	KnownHosts string `json:"known_hosts,omitempty"`
+	// CaDN is the LDAP entry receiving the CA certificate as cACertificate, {ca_name} stands for the configured name of the CA.
+	CaDN string `json:"ca_dn,omitempty"`
+	// CrlDN is the LDAP entry receiving the CRL as certificateRevocationList, for a root also as authorityRevocationList.
+	CrlDN string `json:"crl_dn,omitempty"`
+	// Attempts is the number of tries of a copy, 3 if zero.
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// DisplayName returns the name of the target, the path or URL if it has no name.
+func (t PublishTarget) DisplayName() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	if t.Path != "" {
+		return t.Path
+	}
+	return t.URL
+}
+
+// PublicationStatus is the outcome of the copies to a publish target.
+type PublicationStatus struct {
+	Target      string     `json:"target"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	// Error is the error of the last failed copy, it is cleared by the next successful one.
+	Error string `json:"error,omitempty"`
+	// Failed are the files not copied yet, they are copied again with the next publication.
+	Failed []string `json:"failed,omitempty"`
+}
+
+// IsFailing reports if files are waiting to be copied to the target.
+func (s PublicationStatus) IsFailing() bool {
+	return len(s.Failed) > 0
+}
@@ -1,6 +1,10 @@
 package model
 
-import "net"
+import (
+	"math/big"
+	"net"
+	"time"
+)
 
 // CertificateRequest represents the details needed to create a certificate request.
 type CertificateRequest struct {
@@ -16,3 +20,67 @@ type CertificateRequest struct {
 	// The Uniform Resource Identifiers (URIs) that should be included in the SAN field.
 	URIs []string
 }
+
+// Status of a tracked certificate request.
+const (
+	RequestReceived = "received"
+	RequestPending  = "pending"
+	RequestIssued   = "issued"
+	RequestRejected = "rejected"
+	// RequestFailed requests could not be issued, they stay in the request folder and are tried again.
+	RequestFailed = "failed"
+)
+
+// RequestDecision records which operator approved or rejected a request and when.
+type RequestDecision struct {
+	// Operator is the name of the OS user, UID its user id which tells the operators apart.
+	Operator string    `json:"operator"`
+	UID      string    `json:"uid,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// RequestRecord tracks a certificate request submitted through the API or, with approval, any request waiting for operators.
+type RequestRecord struct {
+	ID      string `json:"id"`
+	Profile string `json:"profile"`
+	Subject string `json:"subject"`
+	// Requester is token:<name> or cert:<sha256 fingerprint> of the API client which submitted the request,
+	// the EST user, scep or the request folder.
+	Requester string    `json:"requester"`
+	Status    string    `json:"status"`
+	Received  time.Time `json:"received"`
+	// Serial is the serial number of the issued certificate.
+	Serial *big.Int `json:"serial,omitempty"`
+	// Reason tells the requester why the request was rejected.
+	Reason string `json:"reason,omitempty"`
+	// Source is the path of a request placed in the request folders.
+	Source string `json:"source,omitempty"`
+	// Transaction identifies the request for clients which poll for the outcome, the SCEP transaction id
+	// or the SHA-256 of an EST request.
+	Transaction string `json:"transaction,omitempty"`
+	// Request is the DER encoded request, kept until it is decided.
+	Request   []byte            `json:"request,omitempty"`
+	Approvals []RequestDecision `json:"approvals,omitempty"`
+	// Lint holds the findings of the linter, for a rejected request the blocking ones.
+	Lint      []LintFinding    `json:"lint,omitempty"`
+	Rejection *RequestDecision `json:"rejection,omitempty"`
+}
+
+// RequestStatus is written next to a request placed in the request folders, so a requester
+// without access to the CA learns what happened to it.
+type RequestStatus struct {
+	// ID stays the same while the request is received, pending or failed.
+	ID      string `json:"id"`
+	Request string `json:"request"`
+	// Fingerprint is the hex encoded SHA-256 hash of the request file.
+	Fingerprint string   `json:"fingerprint"`
+	Profile     string   `json:"profile"`
+	Status      string   `json:"status"`
+	Reason      string   `json:"reason,omitempty"`
+	Serial      *big.Int `json:"serial,omitempty"`
+	Certificate string   `json:"certificate,omitempty"`
+	Chain       string   `json:"chain,omitempty"`
+	// Lint holds the findings of the linter, for a rejected request the blocking ones.
+	Lint    []LintFinding `json:"lint,omitempty"`
+	Updated time.Time     `json:"updated"`
+}
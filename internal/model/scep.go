@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// SCEPChallenge is a one time challenge password for SCEP enrollment, only its hash is kept.
+type SCEPChallenge struct {
+	// Hash is the hex encoded SHA-256 hash of the challenge password.
+	Hash string `json:"hash"`
+	// Profile is the profile of the certificate, client or server.
+	Profile string `json:"profile"`
+	// Approval holds requests with this challenge as pending until an operator approves them.
+	Approval bool      `json:"approval"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+}
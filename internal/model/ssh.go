@@ -0,0 +1,52 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// SSH certificate types, they match the names of the request folders.
+const (
+	SSHUser = "user"
+	SSHHost = "host"
+)
+
+// SSHConfig is the state of the SSH CA, it is kept in the store next to the SSH CA key.
+type SSHConfig struct {
+	// Profiles holds the profile of each certificate type.
+	Profiles map[string]SSHProfile `json:"profiles"`
+	// The serial number of the last signed certificate.
+	LastSerial uint64 `json:"last_serial"`
+	// The version of the last published KRL.
+	LastKRLVersion uint64 `json:"last_krl_version"`
+}
+
+// SSHProfile describes the certificates signed for one certificate type.
+type SSHProfile struct {
+	// Validity is the lifetime of a certificate as Go duration, e.g. "24h".
+	Validity string `json:"validity"`
+	// Principals are the user or host names of a certificate, {name} is replaced by
+	// the name of the request file without `.pub`.
+	Principals []string `json:"principals"`
+	// CriticalOptions like "force-command" or "source-address", user certificates only.
+	CriticalOptions map[string]string `json:"critical_options,omitempty"`
+	// Extensions like "permit-pty", user certificates only.
+	Extensions map[string]string `json:"extensions,omitempty"`
+}
+
+// ExpandPrincipals returns the principals of the profile for the request name.
+func (p SSHProfile) ExpandPrincipals(name string) []string {
+	var result []string
+	for _, principal := range p.Principals {
+		result = append(result, strings.ReplaceAll(principal, "{name}", name))
+	}
+	return result
+}
+
+// SSHRevocation is the record of a revoked SSH certificate.
+type SSHRevocation struct {
+	Serial    uint64    `json:"serial"`
+	KeyId     string    `json:"key_id"`
+	Reason    int       `json:"reason"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"math/big"
+	"time"
+)
+
+// SubCa is the registry entry of a CA certificate issued by this CA.
+type SubCa struct {
+	// The common name of the subordinate CA.
+	Name string `json:"common_name"`
+	// The serial number of the issued CA certificate.
+	Serial *big.Int `json:"serial"`
+	// The hex encoded subject key identifier of the issued CA certificate.
+	SubjectKeyId string    `json:"subject_key_id"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	// The file name of the issued certificate in the store.
+	File string `json:"file"`
+	// Cross is set for cross certificates of a CA outside of this hierarchy.
+	Cross bool `json:"cross,omitempty"`
+	// RevokedAt is set once the CA certificate is revoked.
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// The RFC 5280 revocation reason name.
+	Reason string `json:"reason,omitempty"`
+}
+
+// IsRevoked reports if the CA certificate was revoked.
+func (s SubCa) IsRevoked() bool {
+	return s.RevokedAt != nil
+}
+
+// Revocation holds the details of a revoked certificate which do not fit into the certificate file.
+type Revocation struct {
+	Serial    *big.Int  `json:"serial"`
+	Reason    int       `json:"reason"`
+	RevokedAt time.Time `json:"revoked_at"`
+}
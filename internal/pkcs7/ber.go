@@ -0,0 +1,165 @@
+package pkcs7
+
+import "errors"
+
+var errTruncated = errors.New("pkcs7: truncated ber encoding")
+
+// toDER rewrites a BER encoding, like the indefinite lengths written by OpenSSL, in the
+// definite lengths encoding/asn1 expects. Constructed OCTET STRINGs become primitive ones.
+func toDER(ber []byte) ([]byte, error) {
+	out, rest, err := convertElement(ber)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("pkcs7: trailing data after message")
+	}
+	return out, nil
+}
+
+// convertElement converts the first element of b and returns the rest.
+func convertElement(b []byte) ([]byte, []byte, error) {
+	if len(b) < 2 {
+		return nil, nil, errTruncated
+	}
+
+	tagLen := 1
+	if b[0]&0x1f == 0x1f {
+		for {
+			if tagLen >= len(b) {
+				return nil, nil, errTruncated
+			}
+			tagLen++
+			if b[tagLen-1]&0x80 == 0 {
+				break
+			}
+		}
+	}
+	tag := b[:tagLen]
+	constructed := b[0]&0x20 != 0
+	b = b[tagLen:]
+
+	if len(b) == 0 {
+		return nil, nil, errTruncated
+	}
+	var content []byte
+	var rest []byte
+	switch {
+	case b[0] == 0x80:
+		if !constructed {
+			return nil, nil, errors.New("pkcs7: indefinite length of a primitive element")
+		}
+		b = b[1:]
+		var children []byte
+		for {
+			if len(b) < 2 {
+				return nil, nil, errTruncated
+			}
+			if b[0] == 0 && b[1] == 0 {
+				rest = b[2:]
+				break
+			}
+			child, r, err := convertElement(b)
+			if err != nil {
+				return nil, nil, err
+			}
+			children = append(children, child...)
+			b = r
+		}
+		content = children
+	default:
+		length, n, err := parseLength(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		b = b[n:]
+		if length > len(b) {
+			return nil, nil, errTruncated
+		}
+		content, rest = b[:length], b[length:]
+		if constructed {
+			var children []byte
+			for len(content) > 0 {
+				child, r, err := convertElement(content)
+				if err != nil {
+					return nil, nil, err
+				}
+				children = append(children, child...)
+				content = r
+			}
+			content = children
+		}
+	}
+
+	// a constructed universal OCTET STRING is the concatenation of its segments
+	if constructed && len(tag) == 1 && tag[0] == 0x24 {
+		octets, err := concatOctets(content)
+		if err != nil {
+			return nil, nil, err
+		}
+		return appendElement(nil, []byte{0x04}, octets), rest, nil
+	}
+	return appendElement(nil, tag, content), rest, nil
+}
+
+// concatOctets returns the contents of the DER encoded OCTET STRINGs in b.
+func concatOctets(b []byte) ([]byte, error) {
+	var out []byte
+	for len(b) > 0 {
+		if b[0] != 0x04 {
+			return nil, errors.New("pkcs7: unexpected element in a constructed octet string")
+		}
+		length, n, err := parseLength(b[1:])
+		if err != nil {
+			return nil, err
+		}
+		start := 1 + n
+		if start+length > len(b) {
+			return nil, errTruncated
+		}
+		out = append(out, b[start:start+length]...)
+		b = b[start+length:]
+	}
+	return out, nil
+}
+
+// parseLength parses a definite length and returns it with the number of bytes it took.
+func parseLength(b []byte) (int, int, error) {
+	if len(b) == 0 {
+		return 0, 0, errTruncated
+	}
+	if b[0]&0x80 == 0 {
+		return int(b[0]), 1, nil
+	}
+	n := int(b[0] & 0x7f)
+	if n == 0 || n > 4 || len(b) < 1+n {
+		return 0, 0, errors.New("pkcs7: invalid length")
+	}
+	length := 0
+	for _, c := range b[1 : 1+n] {
+		length = length<<8 | int(c)
+	}
+	if length < 0 {
+		return 0, 0, errors.New("pkcs7: invalid length")
+	}
+	return length, 1 + n, nil
+}
+
+// appendElement appends the tag, the definite length and the content to out.
+func appendElement(out []byte, tag []byte, content []byte) []byte {
+	out = append(out, tag...)
+	l := len(content)
+	switch {
+	case l < 0x80:
+		out = append(out, byte(l))
+	case l < 0x100:
+		out = append(out, 0x81, byte(l))
+	case l < 0x10000:
+		out = append(out, 0x82, byte(l>>8), byte(l))
+	case l < 0x1000000:
+		out = append(out, 0x83, byte(l>>16), byte(l>>8), byte(l))
+	default:
+		out = append(out, 0x84, byte(l>>24), byte(l>>16), byte(l>>8), byte(l))
+	}
+	return append(out, content...)
+}
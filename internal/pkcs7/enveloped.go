@@ -0,0 +1,189 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+var (
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+
+	// OIDs of the content encryption algorithms, the parameter is the IV.
+	OIDEncryptionDES3   = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	OIDEncryptionAES128 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	OIDEncryptionAES192 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	OIDEncryptionAES256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+type recipientInfo struct {
+	Version                int
+	IssuerAndSerialNumber  issuerAndSerial
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []recipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+// Decrypt returns the content of a BER or DER encoded EnvelopedData, the content key is
+// encrypted with RSA PKCS#1 v1.5 to the certificate. It also returns the content encryption
+// algorithm, so an answer can use the same.
+func Decrypt(ber []byte, cert *x509.Certificate, key *rsa.PrivateKey) ([]byte, asn1.ObjectIdentifier, error) {
+	der, err := toDER(ber)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var ci parsedContentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, nil, fmt.Errorf("pkcs7: %w", err)
+	}
+	if !ci.ContentType.Equal(oidEnvelopedData) {
+		return nil, nil, errors.New("pkcs7: not an enveloped data message")
+	}
+	var ed envelopedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+		return nil, nil, fmt.Errorf("pkcs7: %w", err)
+	}
+
+	var recipient *recipientInfo
+	for i, r := range ed.RecipientInfos {
+		if bytes.Equal(r.IssuerAndSerialNumber.Issuer.FullBytes, cert.RawIssuer) && r.IssuerAndSerialNumber.Serial.Cmp(cert.SerialNumber) == 0 {
+			recipient = &ed.RecipientInfos[i]
+		}
+	}
+	if recipient == nil {
+		return nil, nil, errors.New("pkcs7: the message is not encrypted to the certificate")
+	}
+	if !recipient.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAEncryption) {
+		return nil, nil, fmt.Errorf("pkcs7: unsupported key encryption algorithm %s", recipient.KeyEncryptionAlgorithm.Algorithm)
+	}
+	contentKey, err := rsa.DecryptPKCS1v15(nil, key, recipient.EncryptedKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pkcs7: %w", err)
+	}
+
+	eci := ed.EncryptedContentInfo
+	encrypted := eci.EncryptedContent.Bytes
+	if eci.EncryptedContent.IsCompound {
+		if encrypted, err = concatOctets(encrypted); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	algorithm := eci.ContentEncryptionAlgorithm.Algorithm
+	block, err := newCipher(algorithm, contentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil || len(iv) != block.BlockSize() {
+		return nil, nil, errors.New("pkcs7: invalid iv")
+	}
+	if len(encrypted) == 0 || len(encrypted)%block.BlockSize() != 0 {
+		return nil, nil, errors.New("pkcs7: invalid length of the encrypted content")
+	}
+
+	content := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(content, encrypted)
+
+	padding := int(content[len(content)-1])
+	if padding == 0 || padding > block.BlockSize() || !bytes.Equal(content[len(content)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, nil, errors.New("pkcs7: invalid padding")
+	}
+	return content[:len(content)-padding], algorithm, nil
+}
+
+// Encrypt returns an EnvelopedData of the content for the RSA key of the recipient certificate.
+func Encrypt(content []byte, recipient *x509.Certificate, algorithm asn1.ObjectIdentifier) ([]byte, error) {
+	pub, ok := recipient.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("pkcs7: the recipient has no rsa key")
+	}
+
+	keySize := 32
+	switch {
+	case algorithm.Equal(OIDEncryptionDES3), algorithm.Equal(OIDEncryptionAES192):
+		keySize = 24
+	case algorithm.Equal(OIDEncryptionAES128):
+		keySize = 16
+	}
+	contentKey := make([]byte, keySize)
+	if _, err := rand.Read(contentKey); err != nil {
+		return nil, err
+	}
+	block, err := newCipher(algorithm, contentKey)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, block.BlockSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	padding := block.BlockSize() - len(content)%block.BlockSize()
+	padded := append(append([]byte(nil), content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	encrypted := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, padded)
+
+	encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, contentKey)
+	if err != nil {
+		return nil, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	ed := envelopedData{
+		Version: 0,
+		RecipientInfos: []recipientInfo{{
+			Version:                0,
+			IssuerAndSerialNumber:  issuerAndSerial{Issuer: asn1.RawValue{FullBytes: recipient.RawIssuer}, Serial: recipient.SerialNumber},
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedKey:           encryptedKey,
+		}},
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                oidData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: algorithm, Parameters: asn1.RawValue{FullBytes: ivParam}},
+			EncryptedContent:           asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: encrypted},
+		},
+	}
+	der, err := asn1.Marshal(ed)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidEnvelopedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der},
+	})
+}
+
+func newCipher(algorithm asn1.ObjectIdentifier, key []byte) (cipher.Block, error) {
+	switch {
+	case algorithm.Equal(OIDEncryptionDES3):
+		return des.NewTripleDESCipher(key)
+	case algorithm.Equal(OIDEncryptionAES128), algorithm.Equal(OIDEncryptionAES192), algorithm.Equal(OIDEncryptionAES256):
+		return aes.NewCipher(key)
+	}
+	return nil, fmt.Errorf("pkcs7: unsupported content encryption algorithm %s", algorithm)
+}
@@ -0,0 +1,296 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+var (
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA1          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type parsedContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type parsedSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      parsedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+// SignedData is a parsed SignedData with one signer.
+type SignedData struct {
+	// Content is the signed content, nil if the message has none.
+	Content []byte
+	// Certificates are the certificates of the message.
+	Certificates []*x509.Certificate
+	// Signer is the certificate of the signer, it is part of Certificates.
+	Signer *x509.Certificate
+
+	signer     signerInfo
+	attributes []attribute
+}
+
+// Attribute is an authenticated attribute added by Sign.
+type Attribute struct {
+	Type  asn1.ObjectIdentifier
+	Value any
+}
+
+// ParseSignedData parses a BER or DER encoded SignedData. The signature is not checked, see Verify.
+func ParseSignedData(ber []byte) (*SignedData, error) {
+	der, err := toDER(ber)
+	if err != nil {
+		return nil, err
+	}
+
+	var ci parsedContentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("pkcs7: %w", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("pkcs7: not a signed data message")
+	}
+
+	var sd parsedSignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("pkcs7: %w", err)
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("pkcs7: expected one signer, found %d", len(sd.SignerInfos))
+	}
+
+	result := &SignedData{signer: sd.SignerInfos[0]}
+	if len(sd.ContentInfo.Content.Bytes) > 0 {
+		if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &result.Content); err != nil {
+			return nil, fmt.Errorf("pkcs7: %w", err)
+		}
+	}
+
+	if len(sd.Certificates.Bytes) > 0 {
+		result.Certificates, err = x509.ParseCertificates(sd.Certificates.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("pkcs7: %w", err)
+		}
+	}
+	for _, c := range result.Certificates {
+		if bytes.Equal(c.RawIssuer, result.signer.IssuerAndSerialNumber.Issuer.FullBytes) &&
+			c.SerialNumber.Cmp(result.signer.IssuerAndSerialNumber.Serial) == 0 {
+			result.Signer = c
+		}
+	}
+	if result.Signer == nil {
+		return nil, errors.New("pkcs7: the certificate of the signer is missing")
+	}
+
+	if len(result.signer.AuthenticatedAttributes.Bytes) > 0 {
+		if _, err := asn1.UnmarshalWithParams(result.signer.AuthenticatedAttributes.FullBytes, &result.attributes, "set,tag:0"); err != nil {
+			return nil, fmt.Errorf("pkcs7: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// Verify checks the signature of the signer and the message digest of the content.
+// The certificate of the signer is not verified.
+func (sd *SignedData) Verify() error {
+	hash, err := hashOf(sd.signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	signed := sd.Content
+	if len(sd.attributes) > 0 {
+		var digest []byte
+		if err := sd.Attribute(oidAttributeMessageDigest, &digest); err != nil {
+			return errors.New("pkcs7: the message digest attribute is missing")
+		}
+		h := hash.New()
+		h.Write(sd.Content)
+		if !bytes.Equal(h.Sum(nil), digest) {
+			return errors.New("pkcs7: the message digest does not match the content")
+		}
+		// the attributes are signed as SET, not with the implicit tag of the signer info
+		signed = append([]byte{0x31}, sd.signer.AuthenticatedAttributes.FullBytes[1:]...)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch pub := sd.Signer.PublicKey.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(pub, hash, digest, sd.signer.EncryptedDigest)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, sd.signer.EncryptedDigest) {
+			err = errors.New("invalid ecdsa signature")
+		}
+	default:
+		err = fmt.Errorf("unsupported key type %T", pub)
+	}
+	if err != nil {
+		return fmt.Errorf("pkcs7: %w", err)
+	}
+	return nil
+}
+
+// Attribute unmarshals the value of the authenticated attribute into out.
+func (sd *SignedData) Attribute(oid asn1.ObjectIdentifier, out any) error {
+	for _, a := range sd.attributes {
+		if a.Type.Equal(oid) && len(a.Values) > 0 {
+			_, err := asn1.Unmarshal(a.Values[0].FullBytes, out)
+			return err
+		}
+	}
+	return fmt.Errorf("pkcs7: attribute %s not found", oid)
+}
+
+// Sign returns a SignedData of the content signed with SHA-256 by the key of the certificate.
+// The content type, the message digest and the signing time are added to the attributes.
+// A nil content is left out of the message.
+func Sign(content []byte, attributes []Attribute, cert *x509.Certificate, key crypto.Signer, now time.Time) ([]byte, error) {
+	h := crypto.SHA256.New()
+	h.Write(content)
+
+	attributes = append([]Attribute{
+		{Type: oidAttributeContentType, Value: oidData},
+		{Type: oidAttributeMessageDigest, Value: h.Sum(nil)},
+		{Type: oidAttributeSigningTime, Value: now.UTC()},
+	}, attributes...)
+	attrs, err := marshalAttributes(attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := crypto.SHA256.New()
+	digest.Write(attrs)
+	signature, err := key.Sign(rand.Reader, digest.Sum(nil), crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	encryption := pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	if _, ok := key.Public().(*ecdsa.PublicKey); ok {
+		encryption = pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}}
+	}
+	sha256 := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+
+	si := signerInfo{
+		Version:               1,
+		IssuerAndSerialNumber: issuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, Serial: cert.SerialNumber},
+		DigestAlgorithm:       sha256,
+		// the signed SET becomes the implicit [0] of the signer info
+		AuthenticatedAttributes:   asn1.RawValue{FullBytes: append([]byte{0xa0}, attrs[1:]...)},
+		DigestEncryptionAlgorithm: encryption,
+		EncryptedDigest:           signature,
+	}
+
+	inner := contentInfo{ContentType: oidData}
+	if content != nil {
+		octets, err := asn1.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		inner.Content = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: octets}
+	}
+
+	sd := struct {
+		Version          int
+		DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+		ContentInfo      contentInfo
+		Certificates     asn1.RawValue
+		SignerInfos      []signerInfo `asn1:"set"`
+	}{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256},
+		ContentInfo:      inner,
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw},
+		SignerInfos:      []signerInfo{si},
+	}
+	der, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der},
+	})
+}
+
+// marshalAttributes returns the DER encoded SET of the attributes, sorted as DER asks for.
+func marshalAttributes(attributes []Attribute) ([]byte, error) {
+	var encoded [][]byte
+	for _, a := range attributes {
+		value, err := asn1.Marshal(a.Value)
+		if err != nil {
+			return nil, err
+		}
+		attr, err := asn1.Marshal(attribute{Type: a.Type, Values: []asn1.RawValue{{FullBytes: value}}})
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, attr)
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+
+	return appendElement(nil, []byte{0x31}, bytes.Join(encoded, nil)), nil
+}
+
+func hashOf(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("pkcs7: unsupported digest algorithm %s", oid)
+}
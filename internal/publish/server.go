@@ -0,0 +1,92 @@
+// Package publish serves the published CA certificates and CRLs under the AIA and CDP URLs of the issued certificates.
+package publish
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/data"
+	"deleteonerror.com/tyinypki/internal/logger"
+)
+
+// Server serves the files of the publish folder of a store. Every request reads the file again,
+// so newly published CRLs are served at once. Certificates and CRLs are served DER encoded.
+type Server struct {
+	Store data.Store
+	// MaxAge limits how long clients may cache a file. A CRL is never cached beyond its next update,
+	// a new CRL reaches the clients after MaxAge at the latest.
+	MaxAge time.Duration
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// the publish folder is flat, the URL templates may place the files below any path
+	name := path.Base(r.URL.Path)
+	raw, modTime, err := s.Store.ReadPublished(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logger.Error("%v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	contentType, body, maxAge := s.prepare(name, raw)
+	hash := sha256.Sum256(body)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(hash[:16])+`"`)
+	if maxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	// ServeContent adds Last-Modified and answers conditional and range requests
+	http.ServeContent(w, r, name, modTime, bytes.NewReader(body))
+}
+
+// prepare returns the content type, the body and the caching time of a published file.
+func (s *Server) prepare(name string, raw []byte) (string, []byte, time.Duration) {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".crl":
+		der := toDER(raw)
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			logger.Warning("Published CRL %s is invalid: %v", name, err)
+			return "application/pkix-crl", der, 0
+		}
+		return "application/pkix-crl", der, min(s.MaxAge, time.Until(crl.NextUpdate))
+	case ".cer", ".crt":
+		return "application/pkix-cert", toDER(raw), s.MaxAge
+	case ".pem":
+		return "application/x-pem-file", raw, s.MaxAge
+	case ".pub":
+		return "text/plain; charset=utf-8", raw, s.MaxAge
+	default:
+		return "application/octet-stream", raw, s.MaxAge
+	}
+}
+
+// toDER returns the content of the first PEM block, raw is returned if it is no PEM.
+func toDER(raw []byte) []byte {
+	if block, _ := pem.Decode(raw); block != nil {
+		return block.Bytes
+	}
+	return raw
+}
@@ -1,16 +1,18 @@
 package request
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 
 	"deleteonerror.com/tyinypki/internal/logger"
 	"deleteonerror.com/tyinypki/internal/model"
 )
 
-func CreateSubCaRequest(conf model.Config, key ecdsa.PrivateKey) []byte {
+func CreateSubCaRequest(conf model.Config, key crypto.Signer) []byte {
 
 	subject := pkix.Name{
 		Organization:       []string{conf.Organization},
@@ -23,7 +25,16 @@ func CreateSubCaRequest(conf model.Config, key ecdsa.PrivateKey) []byte {
 		Subject: subject,
 	}
 
-	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, csr, &key)
+	if conf.PathLen != nil {
+		ext, err := CreateBasicConstraintsExtension(*conf.PathLen)
+		if err != nil {
+			logger.Error("%v", err)
+		} else {
+			csr.ExtraExtensions = append(csr.ExtraExtensions, ext)
+		}
+	}
+
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, csr, key)
 	if err != nil {
 		logger.Error("%v", err)
 	}
@@ -47,3 +58,24 @@ func CreateSimpleRequest(key ecdsa.PrivateKey, req model.CertificateRequest) ([]
 	}
 	return csrBytes, nil
 }
+
+// BasicConstraints is the ASN.1 structure of the basic constraints extension (RFC 5280, 4.2.1.9).
+type BasicConstraints struct {
+	IsCA       bool `asn1:"optional"`
+	MaxPathLen int  `asn1:"optional,default:-1"`
+}
+
+// CreateBasicConstraintsExtension returns a CA basic constraints extension with the given path length,
+// it is used to ask the issuer for a path length in a CA request.
+func CreateBasicConstraintsExtension(pathLen int) (pkix.Extension, error) {
+	value, err := asn1.Marshal(BasicConstraints{IsCA: true, MaxPathLen: pathLen})
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	return pkix.Extension{
+		Id:       asn1.ObjectIdentifier{2, 5, 29, 19},
+		Critical: true,
+		Value:    value,
+	}, nil
+}
@@ -0,0 +1,389 @@
+// Package scep serves the simple certificate enrollment protocol (RFC 8894) for a CA.
+package scep
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"deleteonerror.com/tyinypki/internal/ca"
+	"deleteonerror.com/tyinypki/internal/data"
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+	"deleteonerror.com/tyinypki/internal/pkcs7"
+)
+
+// maxMessageSize limits the size of a posted message.
+const maxMessageSize = 64 * 1024
+
+// requester names SCEP clients in the records of queued requests.
+const requester = "scep"
+
+// capabilities are returned by GetCACaps, renewal and GetCert are not supported.
+var capabilities = []string{"POSTPKIOperation", "SHA-1", "SHA-256", "SHA-512", "AES", "DES3", "SCEPStandard"}
+
+var (
+	oidMessageType       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
+	oidPKIStatus         = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}
+	oidFailInfo          = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 4}
+	oidSenderNonce       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}
+	oidRecipientNonce    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 6}
+	oidTransactionID     = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+	oidChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+)
+
+// Message types of RFC 8894.
+const (
+	msgCertRep  = "3"
+	msgPKCSReq  = "19"
+	msgCertPoll = "20"
+)
+
+// PKI status of a CertRep.
+const (
+	statusSuccess = "0"
+	statusFailure = "2"
+	statusPending = "3"
+)
+
+// Failure reasons of a CertRep.
+const (
+	failBadMessageCheck = "1"
+	failBadRequest      = "2"
+	failBadCertID       = "4"
+)
+
+// Server handles GetCACert, GetCACaps and PKIOperation with PKCSReq and CertPoll messages.
+// Requests are encrypted to the RA certificate of the CA and need a challenge password created
+// with CA.NewSCEPChallenge, certificates are issued by CA.IssueRequest. Requests waiting for approval are
+// queued with the other requests of the CA, the client polls them by transaction id.
+type Server struct {
+	CA *ca.Authority
+
+	// mu serializes the use of the CA, the store lock is taken per request
+	mu sync.Mutex
+}
+
+// certRep is the answer to a PKI message.
+type certRep struct {
+	status   string
+	failInfo string
+	cert     *x509.Certificate
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get("operation") {
+	case "GetCACert":
+		s.getCACert(w)
+	case "GetCACaps":
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, strings.Join(capabilities, "\n"))
+	case "PKIOperation":
+		s.pkiOperation(w, r)
+	default:
+		http.Error(w, "unknown operation", http.StatusBadRequest)
+	}
+}
+
+// getCACert returns the RA certificate followed by the CA certificate and its issuers.
+func (s *Server) getCACert(w http.ResponseWriter) {
+	release, ok := s.lock(w, false)
+	if !ok {
+		return
+	}
+	ra, _, err := s.CA.SCEPRecipient()
+	chain := s.CA.CertificateChain()
+	release()
+	if err != nil {
+		logger.Error("%v", err)
+		http.Error(w, "scep is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	certs := [][]byte{ra.Raw}
+	for _, c := range chain {
+		certs = append(certs, c.Raw)
+	}
+	p7, err := pkcs7.DegenerateCertificates(certs...)
+	if err != nil {
+		logger.Error("%v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-x509-ca-ra-cert")
+	_, _ = w.Write(p7)
+}
+
+func (s *Server) pkiOperation(w http.ResponseWriter, r *http.Request) {
+	message, err := readMessage(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sd, err := pkcs7.ParseSignedData(message)
+	if err != nil {
+		http.Error(w, "no scep message", http.StatusBadRequest)
+		return
+	}
+
+	var messageType, transactionID string
+	var senderNonce []byte
+	if sd.Attribute(oidMessageType, &messageType) != nil ||
+		sd.Attribute(oidTransactionID, &transactionID) != nil ||
+		sd.Attribute(oidSenderNonce, &senderNonce) != nil {
+		http.Error(w, "the scep attributes are missing", http.StatusBadRequest)
+		return
+	}
+
+	release, ok := s.lock(w, true)
+	if !ok {
+		return
+	}
+	defer release()
+
+	ra, raKey, err := s.CA.SCEPRecipient()
+	if err != nil {
+		logger.Error("%v", err)
+		http.Error(w, "scep is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	algorithm := pkcs7.OIDEncryptionAES256
+	var rep certRep
+	if err := sd.Verify(); err != nil {
+		logger.Warning("SCEP transaction %s: %v", transactionID, err)
+		rep = failure(failBadMessageCheck)
+	} else {
+		var content []byte
+		content, algorithm, err = pkcs7.Decrypt(sd.Content, ra, raKey)
+		if err != nil {
+			logger.Warning("SCEP transaction %s: %v", transactionID, err)
+			rep, algorithm = failure(failBadMessageCheck), pkcs7.OIDEncryptionAES256
+		} else {
+			switch messageType {
+			case msgPKCSReq:
+				rep = s.pkcsReq(transactionID, sd.Signer, content)
+			case msgCertPoll:
+				rep = s.certPoll(transactionID)
+			default:
+				logger.Warning("SCEP transaction %s: message type %s is not supported", transactionID, messageType)
+				rep = failure(failBadRequest)
+			}
+		}
+	}
+
+	response, err := s.signCertRep(rep, transactionID, senderNonce, sd.Signer, algorithm)
+	if err != nil {
+		logger.Error("SCEP transaction %s: %v", transactionID, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pki-message")
+	_, _ = w.Write(response)
+}
+
+// pkcsReq checks the challenge password of the request and issues the certificate or queues the
+// request for approval. A repeated request returns the state of the queued one.
+func (s *Server) pkcsReq(transactionID string, signer *x509.Certificate, content []byte) certRep {
+	if queued, found, err := s.CA.TransactionRecord(requester, transactionID); err != nil {
+		logger.Error("%v", err)
+		return failure(failBadRequest)
+	} else if found {
+		return s.queuedRep(queued)
+	}
+
+	csr, err := x509.ParseCertificateRequest(content)
+	if err != nil || csr.CheckSignature() != nil {
+		logger.Warning("SCEP transaction %s: invalid certificate request", transactionID)
+		return failure(failBadRequest)
+	}
+	// the message is signed by the key of the request, renewal with another key is not supported
+	if pub, ok := csr.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(signer.PublicKey) {
+		logger.Warning("SCEP transaction %s: the message is not signed by the key of the request", transactionID)
+		return failure(failBadMessageCheck)
+	}
+
+	password, err := challengePassword(csr)
+	if err != nil {
+		logger.Warning("SCEP transaction %s: %v", transactionID, err)
+		return failure(failBadRequest)
+	}
+	challenge, err := s.CA.SCEPChallenge(password)
+	if err != nil {
+		logger.Warning("SCEP transaction %s of %s: %v", transactionID, csr.Subject.CommonName, err)
+		return failure(failBadRequest)
+	}
+
+	// the challenge is consumed first, a certificate is never issued for a challenge which stays valid
+	if err := s.CA.UseSCEPChallenge(challenge); err != nil {
+		logger.Error("Could not consume the SCEP challenge of transaction %s: %v", transactionID, err)
+		return failure(failBadRequest)
+	}
+
+	record := model.RequestRecord{Requester: requester, Transaction: transactionID}
+	cert, err := s.CA.IssueRequest(csr, challenge.Profile, record, challenge.Approval)
+	var pending *ca.PendingError
+	if err != nil && !errors.As(err, &pending) {
+		logger.Error("SCEP enrollment of %s failed: %v", csr.Subject.CommonName, err)
+		// the client may send a corrected request with the same challenge
+		if err := s.CA.RestoreSCEPChallenge(challenge); err != nil {
+			logger.Error("Could not restore the SCEP challenge of transaction %s: %v", transactionID, err)
+		}
+		return failure(failBadRequest)
+	}
+	if pending != nil {
+		return certRep{status: statusPending}
+	}
+	logger.Info("SCEP issued %s (serial %s) with profile %s", cert.Subject.CommonName, cert.SerialNumber, challenge.Profile)
+	return certRep{status: statusSuccess, cert: cert}
+}
+
+// certPoll returns the state of a request waiting for approval.
+func (s *Server) certPoll(transactionID string) certRep {
+	queued, found, err := s.CA.TransactionRecord(requester, transactionID)
+	if err != nil {
+		logger.Error("%v", err)
+		return failure(failBadRequest)
+	}
+	if !found {
+		return failure(failBadCertID)
+	}
+	return s.queuedRep(queued)
+}
+
+// queuedRep returns the state of a queued request, the certificate once it is approved.
+func (s *Server) queuedRep(queued model.RequestRecord) certRep {
+	switch queued.Status {
+	case model.RequestIssued:
+		cert, err := s.CA.IssuedCertificate(queued.Serial)
+		if err != nil {
+			logger.Error("%v", err)
+			return failure(failBadRequest)
+		}
+		return certRep{status: statusSuccess, cert: cert}
+	case model.RequestPending:
+		return certRep{status: statusPending}
+	default:
+		return failure(failBadRequest)
+	}
+}
+
+func failure(failInfo string) certRep {
+	return certRep{status: statusFailure, failInfo: failInfo}
+}
+
+// signCertRep returns the CertRep signed by the RA, the issued certificate is encrypted to the
+// certificate which signed the request.
+func (s *Server) signCertRep(rep certRep, transactionID string, recipientNonce []byte, recipient *x509.Certificate, algorithm asn1.ObjectIdentifier) ([]byte, error) {
+	senderNonce := make([]byte, 16)
+	if _, err := rand.Read(senderNonce); err != nil {
+		return nil, err
+	}
+
+	attributes := []pkcs7.Attribute{
+		{Type: oidMessageType, Value: msgCertRep},
+		{Type: oidPKIStatus, Value: rep.status},
+		{Type: oidTransactionID, Value: transactionID},
+		{Type: oidSenderNonce, Value: senderNonce},
+		{Type: oidRecipientNonce, Value: recipientNonce},
+	}
+	if rep.failInfo != "" {
+		attributes = append(attributes, pkcs7.Attribute{Type: oidFailInfo, Value: rep.failInfo})
+	}
+
+	var content []byte
+	if rep.cert != nil {
+		certs, err := pkcs7.DegenerateCertificates(rep.cert.Raw)
+		if err != nil {
+			return nil, err
+		}
+		content, err = pkcs7.Encrypt(certs, recipient, algorithm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ra, raKey, err := s.CA.SCEPRecipient()
+	if err != nil {
+		return nil, err
+	}
+	return pkcs7.Sign(content, attributes, ra, raKey, s.CA.Now())
+}
+
+// lock takes the store lock and refreshes the CA, release frees both.
+func (s *Server) lock(w http.ResponseWriter, exclusive bool) (func(), bool) {
+	s.mu.Lock()
+	lock, err := s.CA.Store().LockStore(exclusive)
+	if err != nil {
+		s.mu.Unlock()
+		if errors.Is(err, data.ErrLocked) {
+			w.Header().Set("Retry-After", "10")
+			http.Error(w, "the ca is busy", http.StatusServiceUnavailable)
+			return nil, false
+		}
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return nil, false
+	}
+	s.CA.Refresh()
+
+	return func() {
+		lock.Release()
+		s.mu.Unlock()
+	}, true
+}
+
+// readMessage returns the message of a POST body or of the message parameter of a GET.
+func readMessage(r *http.Request) ([]byte, error) {
+	switch r.Method {
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > maxMessageSize {
+			return nil, errors.New("message too large")
+		}
+		return body, nil
+	case http.MethodGet:
+		// clients do not always escape the + of base64
+		message := strings.ReplaceAll(r.URL.Query().Get("message"), " ", "+")
+		return base64.StdEncoding.DecodeString(message)
+	}
+	return nil, errors.New("method not allowed")
+}
+
+// challengePassword returns the challenge password attribute of the request, the x509 package
+// does not parse it.
+func challengePassword(csr *x509.CertificateRequest) (string, error) {
+	var tbs struct {
+		Version    int
+		Subject    asn1.RawValue
+		PublicKey  asn1.RawValue
+		Attributes []struct {
+			Type   asn1.ObjectIdentifier
+			Values []asn1.RawValue `asn1:"set"`
+		} `asn1:"tag:0"`
+	}
+	if _, err := asn1.Unmarshal(csr.RawTBSCertificateRequest, &tbs); err != nil {
+		return "", err
+	}
+
+	for _, a := range tbs.Attributes {
+		if !a.Type.Equal(oidChallengePassword) || len(a.Values) == 0 {
+			continue
+		}
+		var password string
+		if _, err := asn1.Unmarshal(a.Values[0].FullBytes, &password); err != nil {
+			return "", err
+		}
+		return password, nil
+	}
+	return "", errors.New("the request has no challenge password")
+}
@@ -0,0 +1,22 @@
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+func PrintFindings(findings []model.CheckFinding) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tSTATUS\tMESSAGE")
+	for _, f := range findings {
+		status := f.Status
+		if f.Repaired {
+			status += " (repaired)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Check, status, f.Message)
+	}
+	w.Flush()
+}
@@ -0,0 +1,49 @@
+package terminal
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+func PrintSubAuthorities(subs []model.SubCa) {
+	if len(subs) == 0 {
+		fmt.Println("No sub CAs issued.")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTYPE\tSERIAL\tNOT AFTER\tSTATUS")
+	for _, sub := range subs {
+		status := "valid"
+		if sub.NotAfter.Before(time.Now()) {
+			status = "expired"
+		}
+		if sub.IsRevoked() {
+			status = fmt.Sprintf("revoked %s (%s)", sub.RevokedAt.Format("2006-01-02"), sub.Reason)
+		}
+		kind := "sub"
+		if sub.Cross {
+			kind = "cross"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", sub.Name, kind, sub.Serial, sub.NotAfter.Format("2006-01-02"), status)
+	}
+	w.Flush()
+}
+
+func PrintChains(chains [][]*x509.Certificate) {
+	if len(chains) == 0 {
+		fmt.Println("  none")
+		return
+	}
+	for i, chain := range chains {
+		fmt.Printf("  chain %d:\n", i+1)
+		for depth, cert := range chain {
+			fmt.Printf("    %d: %s (issuer %s, serial %s)\n", depth, cert.Subject.CommonName, cert.Issuer.CommonName, cert.SerialNumber)
+		}
+	}
+}
@@ -9,10 +9,12 @@ import (
 	"golang.org/x/term"
 )
 
+// AskPassphrase asks for the pass phrase of the CA. The prompt goes to stderr, stdout stays free for
+// the output of the command, e.g. JSON.
 func AskPassphrase() []byte {
-	fmt.Print("Enter Password [min 12 characters]: ")
+	fmt.Fprint(os.Stderr, "Enter Password [min 12 characters]: ")
 	bytePassword, err := term.ReadPassword(int(syscall.Stdin))
-	fmt.Print("\n")
+	fmt.Fprint(os.Stderr, "\n")
 	if err != nil {
 		logger.Error("Failed to read pass phrase from terminal: %s", err)
 		os.Exit(1)
@@ -20,9 +22,75 @@ func AskPassphrase() []byte {
 
 	// Info: if we init in debug and use it for production, we fail, because we are not able to enter a short passphrase on production
 	if len(bytePassword) < 12 && logger.LogSeverity != 0 {
-		fmt.Println("You take security serious! Try again ...")
+		fmt.Fprintln(os.Stderr, "You take security serious! Try again ...")
 		return AskPassphrase()
 	}
 
 	return bytePassword
 }
+
+// AskPassphraseOnTerminal asks for the pass phrase on the controlling terminal,
+// stdin and stdout stay free for a pipeline.
+func AskPassphraseOnTerminal() ([]byte, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("no terminal to read the pass phrase from: %w", err)
+	}
+	defer tty.Close()
+
+	fmt.Fprint(tty, "Enter Password [min 12 characters]: ")
+	bytePassword, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprint(tty, "\n")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pass phrase from terminal: %w", err)
+	}
+	return bytePassword, nil
+}
+
+// AskNewBackupPassphrase asks twice for the pass phrase protecting a backup.
+func AskNewBackupPassphrase() []byte {
+	for {
+		first := askSecret("Enter Backup Password [min 12 characters]: ")
+		if len(first) < 12 && logger.LogSeverity != 0 {
+			fmt.Fprintln(os.Stderr, "You take security serious! Try again ...")
+			continue
+		}
+		second := askSecret("Repeat Backup Password: ")
+		if string(first) == string(second) {
+			return first
+		}
+		fmt.Fprintln(os.Stderr, "Passwords do not match! Try again ...")
+	}
+}
+
+// AskNewUserPassword asks twice for the password of a client user.
+func AskNewUserPassword(name string) []byte {
+	for {
+		first := askSecret(fmt.Sprintf("Enter Password of %s [min 12 characters]: ", name))
+		if len(first) < 12 && logger.LogSeverity != 0 {
+			fmt.Fprintln(os.Stderr, "You take security serious! Try again ...")
+			continue
+		}
+		second := askSecret("Repeat Password: ")
+		if string(first) == string(second) {
+			return first
+		}
+		fmt.Fprintln(os.Stderr, "Passwords do not match! Try again ...")
+	}
+}
+
+// AskBackupPassphrase asks for the pass phrase of an existing backup.
+func AskBackupPassphrase() []byte {
+	return askSecret("Enter Backup Password: ")
+}
+
+func askSecret(prompt string) []byte {
+	fmt.Fprint(os.Stderr, prompt)
+	bytePassword, err := term.ReadPassword(int(syscall.Stdin))
+	fmt.Fprint(os.Stderr, "\n")
+	if err != nil {
+		logger.Error("Failed to read pass phrase from terminal: %s", err)
+		os.Exit(1)
+	}
+	return bytePassword
+}
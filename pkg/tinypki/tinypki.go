@@ -0,0 +1,120 @@
+// Package tinypki embeds a tiny PKI certificate authority into a Go program.
+//
+// A CA works on a Store and holds all of its state, so several CAs can be used in one process:
+//
+//	store := tinypki.NewFileStore("/var/lib/tinyPKI", "/var/tinyPKI")
+//	authority, err := tinypki.New(tinypki.Options{Store: store, Passphrase: pass})
+//	if err != nil {
+//		return err
+//	}
+//	cert, err := authority.Issue(csr, "webserver")
+//
+// All methods return errors, nothing in this package exits the process.
+package tinypki
+
+import (
+	"deleteonerror.com/tyinypki/internal/ca"
+	"deleteonerror.com/tyinypki/internal/data"
+	"deleteonerror.com/tyinypki/internal/logger"
+	"deleteonerror.com/tyinypki/internal/model"
+)
+
+// CA is a root or sub CA on one store.
+type CA = ca.Authority
+
+// Options configure a CA: the store, the signer or the passphrase of the key in the store, the clock and the logger.
+type Options = ca.Options
+
+// Store keeps everything a CA owns: keys, configuration, issued and revoked certificates,
+// CRLs, requests and publication. Implement it to keep a CA somewhere else.
+type Store = data.Store
+
+// FileStore is the Store with the folder layout of tiny pki, on the disk, in memory or in a database file.
+type FileStore = data.FileStore
+
+// StoreLock is an advisory lock on a store, see Store.LockStore.
+type StoreLock = data.StoreLock
+
+// ErrLocked is returned by Store.LockStore if another process holds a conflicting lock.
+var ErrLocked = data.ErrLocked
+
+// Logger receives the messages of a CA.
+type Logger = logger.Logger
+
+// Config is the configuration of a CA.
+type Config = model.Config
+
+// CrossOptions holds the constraints placed into a cross certificate.
+type CrossOptions = ca.CrossOptions
+
+// SubCa is an entry of the registry of CA certificates issued by a CA.
+type SubCa = model.SubCa
+
+// SSHProfile describes the SSH certificates signed for a certificate type, see CA.SignSSHKey.
+type SSHProfile = model.SSHProfile
+
+// The SSH certificate types accepted by CA.SignSSHKey.
+const (
+	SSHUser = model.SSHUser
+	SSHHost = model.SSHHost
+)
+
+// PendingError is returned instead of a certificate if the request waits for approval, see CA.ApproveRequest.
+type PendingError = ca.PendingError
+
+// SCEPProfiles are the profiles a SCEP challenge may issue, see CA.NewSCEPChallenge.
+var SCEPProfiles = ca.SCEPProfiles
+
+// RequestRecord is a request submitted with CA.SubmitRequest and its outcome.
+type RequestRecord = model.RequestRecord
+
+// IssuedCertificate is an entry of the certificates listed by CA.Certificates.
+type IssuedCertificate = model.IssuedCertificate
+
+// CheckFinding is the result of one check of CA.CheckStore.
+type CheckFinding = model.CheckFinding
+
+// Profiles are the certificate profiles accepted by CA.Issue.
+var Profiles = ca.Profiles
+
+// ParseProfiles parses comma separated profile names.
+func ParseProfiles(list string) ([]string, error) {
+	return ca.ParseProfiles(list)
+}
+
+// RootBundleKinds and SubBundleKinds are the file kinds a root and a sub CA export with CA.ExportBundle.
+var (
+	RootBundleKinds = ca.RootBundleKinds
+	SubBundleKinds  = ca.SubBundleKinds
+)
+
+// New returns a CA for the store in the options.
+func New(opts Options) (*CA, error) {
+	return ca.New(opts)
+}
+
+// NewFileStore returns a store with the CA files below storePath and the work folders below workPath.
+func NewFileStore(storePath string, workPath string) *FileStore {
+	return data.NewFileStore(storePath, workPath)
+}
+
+// StoreFromEnvironment returns the store the commands use, configured by `CONTAINER`, `TINY_ROOT_PATH` and `TINY_LOCK_WAIT`.
+func StoreFromEnvironment() *FileStore {
+	return data.FromEnvironment()
+}
+
+// NewMemoryStore returns a store held in memory, e.g. for tests of issuance and revocation.
+func NewMemoryStore() *FileStore {
+	return data.NewMemoryStore()
+}
+
+// OpenDBStore returns a store which keeps the CA in the single database file at path,
+// the work folders stay on the file system below workPath.
+func OpenDBStore(path string, workPath string) (*FileStore, error) {
+	return data.OpenDBStore(path, workPath)
+}
+
+// ParseRevocationReason returns the reason code for a RFC 5280 reason name, as used by CA.Revoke.
+func ParseRevocationReason(name string) (int, error) {
+	return ca.ParseRevocationReason(name)
+}